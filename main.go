@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"net/http/httputil"
 	"os"
 
+	"github.com/kaancfidan/bouncer/models"
 	"github.com/kaancfidan/bouncer/services"
 )
 
@@ -29,7 +31,7 @@ func main() {
 		log.Fatalf("could not open config file: %v", err)
 	}
 
-	server, err := newServer(f, cfgFile)
+	server, admin, err := newServer(f, cfgFile)
 	if err != nil {
 		log.Fatalf("could not create server: %v", err)
 	}
@@ -41,6 +43,13 @@ func main() {
 
 	http.HandleFunc("/", server.Handle)
 
+	if admin != nil {
+		go func() {
+			log.Printf("Relationship admin API listening on %s.", admin.Addr)
+			log.Fatal(admin.ListenAndServe())
+		}()
+	}
+
 	log.Printf("Bouncer[%s] started.", version)
 	defer log.Printf("Bouncer shut down.")
 
@@ -48,16 +57,17 @@ func main() {
 	log.Fatal(err)
 }
 
-func newServer(f *flags, configReader io.Reader) (*services.Server, error) {
+// newServer creates the server, and the relationship admin API server if it is enabled
+func newServer(f *flags, configReader io.Reader) (*services.Server, *http.Server, error) {
 	parser := services.YamlConfigParser{}
 	cfg, err := parser.ParseConfig(configReader)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse config: %w", err)
+		return nil, nil, fmt.Errorf("could not parse config: %w", err)
 	}
 
 	err = services.ValidateConfig(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("invalid config: %w", err)
+		return nil, nil, fmt.Errorf("invalid config: %w", err)
 	}
 
 	var upstream http.Handler
@@ -65,21 +75,66 @@ func newServer(f *flags, configReader io.Reader) (*services.Server, error) {
 		upstream = httputil.NewSingleHostReverseProxy(cfg.Server.ParsedURL)
 	}
 
-	authenticator, err := services.NewAuthenticator(
-		[]byte(f.signingKey),
-		f.signingAlg,
-		cfg.Authentication)
+	authenticator, err := newAuthenticator(f, cfg.Authentication)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create authenticator: %w", err)
+	}
 
+	claimAuthorizer, err := services.NewAuthorizer(cfg.ClaimPolicies, cfg.Roles, cfg.Server.RedactClaims)
 	if err != nil {
-		return nil, fmt.Errorf("could not create authenticator: %w", err)
+		return nil, nil, fmt.Errorf("could not create authorizer: %w", err)
+	}
+
+	var authorizer services.Authorizer = claimAuthorizer
+	if cfg.Rego != nil {
+		authorizer, err = services.NewRegoAuthorizer(context.Background(), *cfg.Rego, cfg.RoutePolicies, authorizer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create rego authorizer: %w", err)
+		}
+	}
+
+	var admin *http.Server
+	if cfg.Relationships != nil {
+		store, err := services.NewFileTupleStore(cfg.Relationships.Store)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create tuple store: %w", err)
+		}
+
+		authorizer = services.NewRelationAuthorizer(*cfg.Relationships, store, authorizer)
+
+		if cfg.Relationships.AdminListenAddress != "" {
+			relationshipAdmin := services.NewRelationshipAdmin(
+				*cfg.Relationships, cfg.Server.TrustedProxies, store, authenticator, claimAuthorizer)
+
+			admin = &http.Server{
+				Addr:    cfg.Relationships.AdminListenAddress,
+				Handler: http.HandlerFunc(relationshipAdmin.Handle),
+			}
+		}
 	}
 
 	return services.NewServer(
 		upstream,
-		services.NewRouteMatcher(cfg.RoutePolicies),
-		services.NewAuthorizer(cfg.ClaimPolicies),
+		services.NewRouteMatcher(cfg.RoutePolicies, cfg.Server.Paths),
+		authorizer,
 		authenticator,
-		cfg.Server), nil
+		cfg.Server), admin, nil
+}
+
+func newAuthenticator(f *flags, cfg models.AuthenticationConfig) (services.Authenticator, error) {
+	if cfg.Introspection != nil {
+		if f.signingKey != "" {
+			return nil, fmt.Errorf("signing key cannot be used together with token introspection")
+		}
+
+		return services.NewIntrospectionAuthenticator(*cfg.Introspection, cfg.TokenSources)
+	}
+
+	return services.NewAuthenticator(
+		context.Background(),
+		[]byte(f.signingKey),
+		f.signingAlg,
+		cfg)
 }
 
 func parseFlags() *flags {
@@ -95,7 +150,7 @@ func parseFlags() *flags {
 
 	flag.StringVar(&f.signingAlg, "a",
 		lookupEnv("BOUNCER_SIGNING_ALG", ""),
-		"signing algorithm, accepted values = "+
+		"signing algorithm, optional with OpenID Connect discovery, accepted values = "+
 			"[\"ES256\",\"ES256K,\"ES384\",\"ES512\",\"EdDSA\",\"HS256\","+
 			"\"HS384\",\"HS512\",\"PS256\",\"PS384\",\"PS512\",\"RS256\",\"RS384\",\"RS512\"]")
 
@@ -64,13 +64,49 @@ func TestNewServer(t *testing.T) {
 			cfgContent: "claimPolicies: {}\nroutePolicies: []",
 			wantErr:    true,
 		},
+		{
+			name:  "introspection",
+			flags: &flags{},
+			cfgContent: "authentication:\n introspection:\n  url: https://idp/introspect\n" +
+				"claimPolicies: {}\nroutePolicies: []",
+			wantErr: false,
+		},
+		{
+			name: "introspection with signing key",
+			flags: &flags{
+				signingKey: "SuperSecretKey123!",
+				signingAlg: "HS256",
+			},
+			cfgContent: "authentication:\n introspection:\n  url: https://idp/introspect\n" +
+				"claimPolicies: {}\nroutePolicies: []",
+			wantErr: true,
+		},
+		{
+			name: "invalid expression",
+			flags: &flags{
+				signingKey: "SuperSecretKey123!",
+				signingAlg: "HS256",
+			},
+			cfgContent: "claimPolicies:\n Test:\n  - expression: claims.role ==\nroutePolicies: []",
+			wantErr:    true,
+		},
+		{
+			name: "missing rego bundle",
+			flags: &flags{
+				signingKey: "SuperSecretKey123!",
+				signingAlg: "HS256",
+			},
+			cfgContent: "rego:\n bundle: /nonexistent/bundle\n" +
+				"claimPolicies: {}\nroutePolicies:\n - path: /**\n   regoQuery: data.bouncer.allow",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			buf := bytes.Buffer{}
 			buf.WriteString(tt.cfgContent)
 
-			_, err := newServer(tt.flags, &buf)
+			_, _, err := newServer(tt.flags, &buf)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("newServer() error = %v, wantErr %v", err, tt.wantErr)
 				return
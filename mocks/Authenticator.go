@@ -1,33 +1,57 @@
-// Code generated by mockery v1.0.0. DO NOT EDIT.
-
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	models "github.com/kaancfidan/bouncer/models"
+	mock "github.com/stretchr/testify/mock"
+)
 
-// Authenticator is an autogenerated mock type for the Authenticator type
+// Authenticator is a mock type for the Authenticator type
 type Authenticator struct {
 	mock.Mock
 }
 
-// Authenticate provides a mock function with given fields: authHeader
-func (_m *Authenticator) Authenticate(authHeader string) (map[string]any, error) {
-	ret := _m.Called(authHeader)
+// Authenticate provides a mock function with given fields: ctx, request
+func (_m *Authenticator) Authenticate(ctx context.Context, request *models.RequestContext) (map[string]any, string, error) {
+	ret := _m.Called(ctx, request)
 
 	var r0 map[string]any
-	if rf, ok := ret.Get(0).(func(string) map[string]any); ok {
-		r0 = rf(authHeader)
+	if rf, ok := ret.Get(0).(func(context.Context, *models.RequestContext) map[string]any); ok {
+		r0 = rf(ctx, request)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(map[string]any)
 		}
 	}
 
-	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(authHeader)
+	var r1 string
+	if rf, ok := ret.Get(1).(func(context.Context, *models.RequestContext) string); ok {
+		r1 = rf(ctx, request)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, *models.RequestContext) error); ok {
+		r2 = rf(ctx, request)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// ReadsForm provides a mock function with given fields:
+func (_m *Authenticator) ReadsForm() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
 	} else {
-		r1 = ret.Error(1)
+		r0 = ret.Get(0).(bool)
 	}
 
-	return r0, r1
+	return r0
 }
@@ -1,29 +1,32 @@
-// Code generated by mockery v1.0.0. DO NOT EDIT.
-
 package mocks
 
+import context "context"
 import mock "github.com/stretchr/testify/mock"
 import models "github.com/kaancfidan/bouncer/models"
 
-// Authorizer is an autogenerated mock type for the Authorizer type
+// Authorizer is a mock type for the Authorizer type
 type Authorizer struct {
 	mock.Mock
 }
 
-// Authorize provides a mock function with given fields: policyNames, claims
-func (_m *Authorizer) Authorize(policyNames []string, claims map[string]any) (string, error) {
-	ret := _m.Called(policyNames, claims)
+// Authorize provides a mock function with given fields: ctx, matchedPolicies, request, claims
+func (_m *Authorizer) Authorize(
+	ctx context.Context,
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) (models.Decision, error) {
+	ret := _m.Called(ctx, matchedPolicies, request, claims)
 
-	var r0 string
-	if rf, ok := ret.Get(0).(func([]string, map[string]any) string); ok {
-		r0 = rf(policyNames, claims)
+	var r0 models.Decision
+	if rf, ok := ret.Get(0).(func(context.Context, []models.RoutePolicy, *models.RequestContext, map[string]any) models.Decision); ok {
+		r0 = rf(ctx, matchedPolicies, request, claims)
 	} else {
-		r0 = ret.Get(0).(string)
+		r0 = ret.Get(0).(models.Decision)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func([]string, map[string]any) error); ok {
-		r1 = rf(policyNames, claims)
+	if rf, ok := ret.Get(1).(func(context.Context, []models.RoutePolicy, *models.RequestContext, map[string]any) error); ok {
+		r1 = rf(ctx, matchedPolicies, request, claims)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -44,3 +47,41 @@ func (_m *Authorizer) IsAnonymousAllowed(matchedPolicies []models.RoutePolicy, m
 
 	return r0
 }
+
+// CheckTokenRequirements provides a mock function with given fields: matchedPolicies, issuer, claims
+func (_m *Authorizer) CheckTokenRequirements(matchedPolicies []models.RoutePolicy, issuer string, claims map[string]any) string {
+	ret := _m.Called(matchedPolicies, issuer, claims)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func([]models.RoutePolicy, string, map[string]any) string); ok {
+		r0 = rf(matchedPolicies, issuer, claims)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// CheckDenyPolicies provides a mock function with given fields: matchedPolicies, request, claims
+func (_m *Authorizer) CheckDenyPolicies(
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) (string, error) {
+	ret := _m.Called(matchedPolicies, request, claims)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func([]models.RoutePolicy, *models.RequestContext, map[string]any) string); ok {
+		r0 = rf(matchedPolicies, request, claims)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]models.RoutePolicy, *models.RequestContext, map[string]any) error); ok {
+		r1 = rf(matchedPolicies, request, claims)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
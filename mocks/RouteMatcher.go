@@ -1,22 +1,20 @@
-// Code generated by mockery v1.0.0. DO NOT EDIT.
-
 package mocks
 
 import mock "github.com/stretchr/testify/mock"
 import models "github.com/kaancfidan/bouncer/models"
 
-// RouteMatcher is an autogenerated mock type for the RouteMatcher type
+// RouteMatcher is a mock type for the RouteMatcher type
 type RouteMatcher struct {
 	mock.Mock
 }
 
-// MatchRoutePolicies provides a mock function with given fields: path, method
-func (_m *RouteMatcher) MatchRoutePolicies(path string, method string) ([]models.RoutePolicy, error) {
-	ret := _m.Called(path, method)
+// MatchRoutePolicies provides a mock function with given fields: path, request
+func (_m *RouteMatcher) MatchRoutePolicies(path string, request *models.RequestContext) ([]models.RoutePolicy, error) {
+	ret := _m.Called(path, request)
 
 	var r0 []models.RoutePolicy
-	if rf, ok := ret.Get(0).(func(string, string) []models.RoutePolicy); ok {
-		r0 = rf(path, method)
+	if rf, ok := ret.Get(0).(func(string, *models.RequestContext) []models.RoutePolicy); ok {
+		r0 = rf(path, request)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]models.RoutePolicy)
@@ -24,8 +22,8 @@ func (_m *RouteMatcher) MatchRoutePolicies(path string, method string) ([]models
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(path, method)
+	if rf, ok := ret.Get(1).(func(string, *models.RequestContext) error); ok {
+		r1 = rf(path, request)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -2,11 +2,71 @@ package models
 
 import "net/url"
 
-// AuthenticationConfig holds JWT validation related parameters
-type AuthenticationConfig struct {
+// IssuerConfig holds JWT validation related parameters of a trusted issuer
+type IssuerConfig struct {
 	Issuer             string `yaml:"issuer"`
 	Audience           string `yaml:"audience"`
 	ClockSkewInSeconds int    `yaml:"clockSkewInSeconds"`
+
+	// SigningKey is the key material to verify tokens with, in any format accepted by the signing key flag
+	SigningKey string `yaml:"signingKey"`
+	// Algorithms is the allowlist of signing algorithms accepted from this issuer
+	Algorithms []string `yaml:"algorithms"`
+
+	// JWKSURL is the location of the JWK Set to verify tokens with, used in place of a static signing key
+	JWKSURL string `yaml:"jwksUrl"`
+	// JWKSRefreshIntervalInSeconds overrides the refresh interval derived from the JWKS response cache headers
+	JWKSRefreshIntervalInSeconds int `yaml:"jwksRefreshIntervalInSeconds"`
+	// JWKSMinRefreshIntervalInSeconds limits how often the JWK Set can be fetched,
+	// including the refetches triggered by tokens with unknown key IDs
+	JWKSMinRefreshIntervalInSeconds int `yaml:"jwksMinRefreshIntervalInSeconds"`
+
+	// OIDCDiscoveryURL is the location of the OpenID Connect discovery document
+	// to discover the issuer, JWKS URL and signing algorithms from
+	OIDCDiscoveryURL string `yaml:"oidcDiscoveryUrl"`
+	// Discover enables OpenID Connect discovery from the well-known location under the configured issuer
+	Discover bool `yaml:"discover"`
+	// DiscoveryIntervalInSeconds is the interval between OpenID Connect re-discoveries
+	DiscoveryIntervalInSeconds int `yaml:"discoveryIntervalInSeconds"`
+}
+
+// IntrospectionConfig holds OAuth2 token introspection (RFC 7662) related parameters
+type IntrospectionConfig struct {
+	// URL is the introspection endpoint that tokens are posted to
+	URL string `yaml:"url"`
+	// ClientID and ClientSecret are the client credentials to authenticate to the introspection endpoint with
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	// CacheTTLInSeconds is how long active tokens are cached, never longer than the token's expiration
+	CacheTTLInSeconds int `yaml:"cacheTtlInSeconds"`
+	// NegativeCacheTTLInSeconds is how long inactive tokens are cached
+	NegativeCacheTTLInSeconds int `yaml:"negativeCacheTtlInSeconds"`
+}
+
+// TokenSource is a location in the request to look for the token in.
+// Exactly one of the header, cookie, query parameter or form field names is expected to be set.
+type TokenSource struct {
+	Header string `yaml:"header"`
+	// Scheme is the expected prefix of the header value, e.g. Bearer
+	Scheme string `yaml:"scheme"`
+	Cookie string `yaml:"cookie"`
+	Query  string `yaml:"query"`
+	Form   string `yaml:"form"`
+}
+
+// AuthenticationConfig holds JWT validation related parameters.
+// The inlined issuer is the default one that the signing key and algorithm flags apply to,
+// further trusted issuers can be listed under Issuers.
+//
+// If Introspection is configured, tokens are validated by the introspection endpoint instead.
+//
+// TokenSources are tried in order to find the token in the request, the Bearer authorization header is used if none is
+// configured.
+type AuthenticationConfig struct {
+	IssuerConfig  `yaml:",inline"`
+	Issuers       []IssuerConfig       `yaml:"issuers"`
+	Introspection *IntrospectionConfig `yaml:"introspection"`
+	TokenSources  []TokenSource        `yaml:"tokenSources"`
 }
 
 // OriginalRequestHeaders contains headers to lookup for original request method and path details
@@ -14,6 +74,8 @@ type AuthenticationConfig struct {
 type OriginalRequestHeaders struct {
 	Method string `yaml:"method"`
 	Path   string `yaml:"path"`
+	// Host is optional, the Host header of the sub-request is used if not configured
+	Host string `yaml:"host"`
 }
 
 // ServerConfig holds operation mode (auth server / reverse proxy) related parameters
@@ -21,21 +83,128 @@ type ServerConfig struct {
 	OriginalRequestHeaders *OriginalRequestHeaders `yaml:"originalRequestHeaders"`
 	UpstreamURL            string                  `yaml:"upstreamUrl"`
 	ParsedURL              *url.URL                `yaml:"-"`
+	// TrustedProxies lists the CIDR ranges of proxies whose X-Forwarded-For header is trusted to find the client IP
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// AnonymousDenyStatus is the status code for anonymous requests rejected by deny route policies, 401 by default
+	AnonymousDenyStatus int `yaml:"anonymousDenyStatus"`
+	// DecisionHeader is the response header to summarize authorization decisions in, not sent if empty
+	DecisionHeader string `yaml:"decisionHeader"`
+	// RedactClaims lists the claims whose values are left out of authorization decisions
+	RedactClaims []string `yaml:"redactClaims"`
+	// Paths configures how request paths are canonicalized before route matching
+	Paths PathConfig `yaml:"paths"`
+}
+
+// PathConfig configures the canonicalization of request paths.
+// Percent-encodings are always decoded, dot segments removed and duplicate slashes collapsed.
+type PathConfig struct {
+	// EncodedSlashes is either reject (default) to reject paths with %2F, or decode to treat them as separators
+	EncodedSlashes string `yaml:"encodedSlashes"`
+	// CaseInsensitive matches paths to route policies ignoring case
+	CaseInsensitive bool `yaml:"caseInsensitive"`
+	// StripMatrixParams drops the ;name=value parameters of path segments, e.g. ;jsessionid=x
+	StripMatrixParams bool `yaml:"stripMatrixParams"`
+	// RejectNonCanonical rejects requests whose path is not canonical,
+	// instead of authorizing and forwarding the canonical path in proxy mode.
+	// Non-canonical paths, including those with stripped matrix parameters or decoded encoded slashes,
+	// are always rejected in authorization extension mode.
+	RejectNonCanonical bool `yaml:"rejectNonCanonical"`
 }
 
 // ClaimRequirement is a key-value pair for a given claim constraint.
-// When multiple claim values are provided, these values are effectively ORed.
+// When multiple claim values are provided, these values are effectively ORed, unless the operator says otherwise.
+//
+// Instead of a claim, a requirement can check a request header or query parameter the same way,
+// or the request host and client IP.
+// Rules that are awkward to express this way can be written as a CEL expression instead.
+//
+// A requirement can also compose other requirements with AllOf, AnyOf or Not,
+// or refer to another named claim policy with Policy.
 type ClaimRequirement struct {
 	Claim  string   `yaml:"claim"`
 	Values []string `yaml:"values"`
+	// Operator is how the claim is compared to the values, existence or equality to any of the values if empty
+	Operator string `yaml:"operator"`
+	// CaseInsensitive makes string comparisons ignore case
+	CaseInsensitive bool `yaml:"caseInsensitive"`
+	// ValueFrom compares the claim to a request value instead of Values,
+	// e.g. path.id for the {id} path parameter or claim.tenant for the tenant claim
+	ValueFrom string `yaml:"valueFrom"`
+
+	// Header checks the values of the named request header instead of a claim
+	Header string `yaml:"header"`
+	// Query checks the values of the named query parameter instead of a claim
+	Query string `yaml:"query"`
+	// Hosts passes if the request host is one of the listed hosts, *.example.com matches subdomains
+	Hosts []string `yaml:"hosts"`
+	// CIDRs passes if the client IP is in one of the listed ranges
+	CIDRs []string `yaml:"cidrs"`
+	// Expression passes if the CEL expression evaluates to true,
+	// with the claims, request and route variables available to it
+	Expression string `yaml:"expression"`
+
+	// AllOf passes if all nested requirements pass
+	AllOf []ClaimRequirement `yaml:"allOf"`
+	// AnyOf passes if at least one of the nested requirements passes
+	AnyOf []ClaimRequirement `yaml:"anyOf"`
+	// Not passes if the nested requirement fails
+	Not *ClaimRequirement `yaml:"not"`
+	// Policy passes if all requirements of the named claim policy pass
+	Policy string `yaml:"policy"`
 }
 
-// RoutePolicy matches a given path-method pair to a authorization policy
+// RoutePolicy matches a given request host, path, method, headers and query parameters to a authorization policy
 type RoutePolicy struct {
 	Path           string   `yaml:"path"`
 	Methods        []string `yaml:"methods"`
 	PolicyName     string   `yaml:"policyName"`
 	AllowAnonymous bool     `yaml:"allowAnonymous"`
+	// PolicyNames lists claim policies to check, instead of a single PolicyName
+	PolicyNames []string `yaml:"policyNames"`
+	// PolicyMode is either requireAll (default) or requireAny of the named claim policies to pass
+	PolicyMode string `yaml:"policyMode"`
+	// Override drops the claim policies and token requirements of less specific matching routes, instead of inheriting
+	Override bool `yaml:"override"`
+	// Priority orders the route before routes of lower priority, regardless of specificity. Defaults to 0.
+	Priority int `yaml:"priority"`
+	// Deny rejects matching requests, only if the named claim policies pass when any is named
+	Deny bool `yaml:"deny"`
+	// Hosts restricts the route to requests of the listed hosts, *.example.com matches subdomains
+	Hosts []string `yaml:"hosts"`
+	// Headers restricts the route to requests whose headers match the conditions, keyed by header name
+	Headers map[string]RequestMatch `yaml:"headers"`
+	// Query restricts the route to requests whose query parameters match the conditions, keyed by parameter name
+	Query map[string]RequestMatch `yaml:"query"`
+	// Issuers restricts the route to tokens of the listed trusted issuers
+	Issuers []string `yaml:"issuers"`
+	// Audiences restricts the route to tokens issued for at least one of the listed audiences
+	Audiences []string `yaml:"audiences"`
+	// Scopes restricts the route to tokens that are granted all the listed OAuth scopes
+	Scopes []string `yaml:"scopes"`
+	// RegoQuery names a rule of the Rego bundle, e.g. data.orders.allow, that must evaluate to true for the route
+	RegoQuery string `yaml:"regoQuery"`
+	// Relation requires the token subject to have a relation to an object
+	Relation *RelationRequirement `yaml:"relation"`
+	// PathParams holds the values captured by the path parameters of a matched route policy, nil in configuration
+	PathParams map[string]string `yaml:"-"`
+}
+
+// RequestMatch is a header or query parameter condition of a route policy.
+// Exactly one of Value, Regex or Present must be set.
+type RequestMatch struct {
+	// Value matches if any of the values equals it
+	Value string `yaml:"value"`
+	// Regex matches if any of the values matches the regular expression
+	Regex string `yaml:"regex"`
+	// Present matches if the header or parameter is present when true, or absent when false
+	Present *bool `yaml:"present"`
+}
+
+// RelationRequirement is a relationship check of the token subject
+type RelationRequirement struct {
+	// Check is the object type, relation and object ID to check, e.g. document#editor@{path.docId}.
+	// The object ID is either a literal or a {path.<name>} path parameter.
+	Check string `yaml:"check"`
 }
 
 // ClaimPolicyConfig is a type alias for claimPolicies section
@@ -44,10 +213,62 @@ type ClaimPolicyConfig map[string][]ClaimRequirement
 // RoutePolicyConfig is a type alias for routePolicies section
 type RoutePolicyConfig []RoutePolicy
 
+// RoleDefinition lists the roles a role inherits from, and the permissions it grants
+type RoleDefinition struct {
+	Inherits    []string `yaml:"inherits"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// RolesConfig defines a role hierarchy, used to expand the roles in tokens into effective permissions
+type RolesConfig struct {
+	// Claim holds the roles of the token, "roles" by default
+	Claim string `yaml:"claim"`
+	// PermissionsClaim receives the effective permissions of the roles, "permissions" by default
+	PermissionsClaim string                    `yaml:"permissionsClaim"`
+	Definitions      map[string]RoleDefinition `yaml:"definitions"`
+}
+
+// RegoConfig holds the location of the Rego bundle that route policies can query
+type RegoConfig struct {
+	// Bundle is the path of a local bundle directory with Rego policies and data files
+	Bundle string `yaml:"bundle"`
+	// TimeoutInMilliseconds bounds the evaluation of each query, 1000 by default
+	TimeoutInMilliseconds int `yaml:"timeoutInMilliseconds"`
+}
+
+// RelationDefinition defines how subjects can be related to objects of a type
+type RelationDefinition struct {
+	// Types lists the subject types that can be related directly by tuples,
+	// either object types like user or relations of object types like group#member
+	Types []string `yaml:"types"`
+	// Union lists the rewrites that imply the relation, either other relations of the same object like owner,
+	// or relations of the objects related by a relation like parent->viewer
+	Union []string `yaml:"union"`
+}
+
+// RelationshipsConfig defines the relationship schema, the tuple store and its admin API
+type RelationshipsConfig struct {
+	// Store is the path of the JSON file that tuples are persisted to
+	Store string `yaml:"store"`
+	// SubjectType is the object type of token subjects, "user" by default
+	SubjectType string `yaml:"subjectType"`
+	// SubjectClaim holds the ID of the token subject, "sub" by default
+	SubjectClaim string `yaml:"subjectClaim"`
+	// Schema defines the relations of each object type
+	Schema map[string]map[string]RelationDefinition `yaml:"schema"`
+	// AdminListenAddress enables the tuple admin API on the given address
+	AdminListenAddress string `yaml:"adminListenAddress"`
+	// AdminPolicyName is the claim policy that the tokens of admin API requests must pass
+	AdminPolicyName string `yaml:"adminPolicyName"`
+}
+
 // Config is the overall struct that matches the YAML structure
 type Config struct {
 	Server         ServerConfig         `yaml:"server"`
 	Authentication AuthenticationConfig `yaml:"authentication"`
+	Roles          RolesConfig          `yaml:"roles"`
+	Rego           *RegoConfig          `yaml:"rego"`
+	Relationships  *RelationshipsConfig `yaml:"relationships"`
 	ClaimPolicies  ClaimPolicyConfig    `yaml:"claimPolicies"`
 	RoutePolicies  RoutePolicyConfig    `yaml:"routePolicies"`
 }
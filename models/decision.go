@@ -0,0 +1,102 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Redacted replaces the values of sensitive claims and headers in decisions
+const Redacted = "[redacted]"
+
+// RequirementResult is the outcome of a single requirement evaluated for a decision
+type RequirementResult struct {
+	// RoutePolicy is the path of the route policy that the requirement belongs to
+	RoutePolicy string `json:"routePolicy"`
+	// Policy is the name of the claim policy that the requirement belongs to, empty for Rego queries and relations
+	Policy string `json:"policy,omitempty"`
+	// Requirement describes the requirement, or the branch of it that failed
+	Requirement string `json:"requirement"`
+	Passed      bool   `json:"passed"`
+	// Operator is how the actual values were compared to the expected values, empty for equality
+	Operator string `json:"operator,omitempty"`
+	// Actual holds the values found in the token or request, if the requirement compares values
+	Actual []string `json:"actual,omitempty"`
+	// Expected holds the values compared to, if the requirement compares values
+	Expected []string `json:"expected,omitempty"`
+	// Waived is set for failed requirements of a claim policy of a requireAny route policy,
+	// when another claim policy of the route passed. Waived requirements do not deny.
+	Waived bool `json:"waived,omitempty"`
+}
+
+// Decision is the outcome of an authorization, with every requirement evaluated to reach it
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// RoutePolicy is the path of the route policy that decided, the one with the first failed requirement if denied,
+	// or the most specific one if allowed
+	RoutePolicy  string              `json:"routePolicy"`
+	Requirements []RequirementResult `json:"requirements"`
+	Duration     time.Duration       `json:"duration"`
+}
+
+// Record adds requirement results to the decision.
+// The first failed requirement that is not waived denies the decision, and its route policy becomes the deciding one.
+func (d *Decision) Record(results ...RequirementResult) {
+	for _, r := range results {
+		if !r.Passed && !r.Waived && d.Allowed {
+			d.Allowed = false
+			d.RoutePolicy = r.RoutePolicy
+		}
+
+		d.Requirements = append(d.Requirements, r)
+	}
+}
+
+// Failed returns the failed requirements that are not waived
+func (d Decision) Failed() []RequirementResult {
+	var failed []RequirementResult
+	for _, r := range d.Requirements {
+		if !r.Passed && !r.Waived {
+			failed = append(failed, r)
+		}
+	}
+
+	return failed
+}
+
+// FailedRequirement describes the first failed requirement that is not waived,
+// or returns an empty string if none failed
+func (d Decision) FailedRequirement() string {
+	for _, r := range d.Requirements {
+		if !r.Passed && !r.Waived {
+			return r.Requirement
+		}
+	}
+
+	return ""
+}
+
+// String summarizes the decision with its failed requirements, e.g. deny route=/admin/** failed=[Admin: role]
+func (d Decision) String() string {
+	outcome := "deny"
+	if d.Allowed {
+		outcome = "allow"
+	}
+
+	summary := fmt.Sprintf("%s route=%s", outcome, d.RoutePolicy)
+
+	failed := d.Failed()
+	if len(failed) == 0 {
+		return summary
+	}
+
+	descriptions := make([]string, len(failed))
+	for i, r := range failed {
+		descriptions[i] = r.Requirement
+		if r.Policy != "" {
+			descriptions[i] = r.Policy + ": " + r.Requirement
+		}
+	}
+
+	return summary + " failed=[" + strings.Join(descriptions, ", ") + "]"
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// RequestContext holds the details of the original request to be authenticated and authorized.
+// In the case of sub-requests, method, path, query and host are those of the original request read from the headers.
+type RequestContext struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Host   string
+	// ClientIP is the address of the client, read from X-Forwarded-For if the request comes from a trusted proxy
+	ClientIP net.IP
+	// Form holds the fields of URL encoded form bodies, nil for other requests
+	Form url.Values
+	// PathParams holds the values captured by the path parameters of the route policy being checked
+	PathParams map[string]string
+}
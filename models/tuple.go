@@ -0,0 +1,9 @@
+package models
+
+// Tuple relates a subject to an object, e.g. user:alice is an editor of document:readme,
+// or the members of group:eng are viewers of folder:docs with the subject group:eng#member
+type Tuple struct {
+	Object   string `json:"object"`
+	Relation string `json:"relation"`
+	Subject  string `json:"subject"`
+}
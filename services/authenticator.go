@@ -1,92 +1,285 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/kaancfidan/bouncer/models"
 	"github.com/lestrrat-go/jwx/v2/jwa"
-	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 )
 
 // Authenticator interface
 type Authenticator interface {
-	Authenticate(authHeader string) (claims map[string]any, err error)
+	Authenticate(ctx context.Context, request *models.RequestContext) (claims map[string]any, issuer string, err error)
+	// ReadsForm reports if tokens are read from form fields, only then form bodies are parsed into the request context
+	ReadsForm() bool
 }
 
 // AuthenticatorImpl is a JWT based authentication implementation
 type AuthenticatorImpl struct {
-	key    jwk.Key
-	config models.AuthenticationConfig
-	alg    jwa.KeyAlgorithm
+	issuers []*issuerVerifier
+	tokens  tokenExtractor
 }
 
-// NewAuthenticator creates a new AuthenticatorImpl instance
+// issuerVerifier validates the tokens of a single trusted issuer
+type issuerVerifier struct {
+	keyProvider jws.KeyProvider
+	config      models.IssuerConfig
+	discovery   *oidcDiscovery
+}
+
+// NewAuthenticator creates a new AuthenticatorImpl instance.
+//
+// The signing key and algorithm apply to the default issuer inlined in the config, and take precedence over the
+// signing key and algorithms configured there. The other trusted issuers bring their own key sources and algorithms.
+//
+// The signing key can be a PEM encoded public key or certificate, a JSON encoded JWK or JWK Set,
+// or a raw HMAC secret. All keys found are checked to be compatible with the signing algorithm.
+//
+// If a JWKS URL is configured instead, the key set is fetched from that URL and kept up to date in the background
+// until the given context is cancelled.
+//
+// If OpenID Connect discovery is configured, the issuer, the JWKS URL and the accepted algorithms are discovered
+// and kept up to date in the same way. In that case the signing algorithm is optional and overrides the discovered
+// algorithms when set.
 func NewAuthenticator(
+	ctx context.Context,
 	signingKey []byte,
 	signingAlgorithm string,
 	config models.AuthenticationConfig) (*AuthenticatorImpl, error) {
 
-	key, err := jwk.FromRaw(signingKey)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse key: %v", err)
+	a := &AuthenticatorImpl{tokens: newTokenExtractor(config.TokenSources)}
+
+	// the default issuer is optional only if other issuers are listed
+	if len(config.Issuers) == 0 || len(signingKey) != 0 || hasKeySource(config.IssuerConfig) {
+		defaultIssuer := config.IssuerConfig
+
+		if len(signingKey) != 0 {
+			defaultIssuer.SigningKey = string(signingKey)
+		}
+
+		if signingAlgorithm != "" {
+			defaultIssuer.Algorithms = []string{signingAlgorithm}
+		}
+
+		v, err := newIssuerVerifier(ctx, defaultIssuer)
+		if err != nil {
+			return nil, err
+		}
+
+		a.issuers = append(a.issuers, v)
 	}
 
-	alg := jwa.KeyAlgorithmFrom(signingAlgorithm)
-	if _, isInvalid := alg.(jwa.InvalidKeyAlgorithm); isInvalid {
-		return nil, fmt.Errorf("unknown signing algorithm: %s", signingAlgorithm)
+	for i, cfg := range config.Issuers {
+		v, err := newIssuerVerifier(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not create verifier for issuer #%d (%s): %w", i+1, cfg.Issuer, err)
+		}
+
+		a.issuers = append(a.issuers, v)
 	}
 
-	return &AuthenticatorImpl{
-		key:    key,
-		config: config,
-		alg:    alg,
-	}, nil
+	return a, nil
+}
+
+func hasKeySource(cfg models.IssuerConfig) bool {
+	return cfg.SigningKey != "" || cfg.JWKSURL != "" || cfg.OIDCDiscoveryURL != "" || cfg.Discover
 }
 
-// Authenticate implements Bearer token authentication
-func (a AuthenticatorImpl) Authenticate(authHeader string) (map[string]any, error) {
-	splitToken := strings.Split(authHeader, " ")
+func newIssuerVerifier(ctx context.Context, cfg models.IssuerConfig) (*issuerVerifier, error) {
+	discoveryURL := cfg.OIDCDiscoveryURL
+	if discoveryURL == "" && cfg.Discover {
+		if cfg.Issuer == "" {
+			return nil, fmt.Errorf("issuer is required for discovery")
+		}
 
-	if len(splitToken) != 2 {
-		return nil, fmt.Errorf("invalid authentication header format")
+		discoveryURL = wellKnownURL(cfg.Issuer)
 	}
 
-	scheme := strings.ToLower(splitToken[0])
-	if scheme != "bearer" {
-		return nil, fmt.Errorf("authentication scheme expected to be \"bearer\", actual: %s", scheme)
+	var algs []jwa.SignatureAlgorithm
+	for _, name := range cfg.Algorithms {
+		alg, err := parseSigningAlgorithm(name)
+		if err != nil {
+			return nil, err
+		}
+
+		algs = append(algs, alg)
 	}
 
-	var options []jwt.ValidateOption
+	if algs == nil && discoveryURL == "" {
+		return nil, fmt.Errorf("signing algorithm is required")
+	}
 
-	if a.config.Issuer != "" {
-		options = append(options, jwt.WithIssuer(a.config.Issuer))
+	if (discoveryURL != "" || cfg.JWKSURL != "") && cfg.SigningKey != "" {
+		return nil, fmt.Errorf("signing key cannot be configured together with JWKS URL or discovery")
 	}
 
-	if a.config.Audience != "" {
-		options = append(options, jwt.WithAudience(a.config.Audience))
+	v := &issuerVerifier{config: cfg}
+	var err error
+
+	switch {
+	case discoveryURL != "":
+		if cfg.JWKSURL != "" {
+			return nil, fmt.Errorf("JWKS URL cannot be configured together with discovery")
+		}
+
+		v.discovery, err = newOIDCDiscovery(ctx, discoveryURL, cfg.Issuer, algs,
+			time.Duration(cfg.DiscoveryIntervalInSeconds)*time.Second,
+			time.Duration(cfg.JWKSRefreshIntervalInSeconds)*time.Second,
+			time.Duration(cfg.JWKSMinRefreshIntervalInSeconds)*time.Second)
+
+		if err == nil {
+			v.keyProvider = v.discovery.keyProvider
+		}
+	case cfg.JWKSURL != "":
+		v.keyProvider, err = newJWKSKeyProvider(ctx, cfg.JWKSURL, algs,
+			time.Duration(cfg.JWKSRefreshIntervalInSeconds)*time.Second,
+			time.Duration(cfg.JWKSMinRefreshIntervalInSeconds)*time.Second)
+	default:
+		v.keyProvider, err = newKeySetProvider([]byte(cfg.SigningKey), algs)
 	}
 
-	if a.config.ClockSkewInSeconds != 0 {
-		options = append(options, jwt.WithAcceptableSkew(time.Duration(a.config.ClockSkewInSeconds)*time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Authenticate implements token authentication, the token is looked up in the configured token sources.
+// The returned issuer is the trusted issuer that the token was validated against,
+// empty if it was validated by an issuer configured without a name.
+func (a AuthenticatorImpl) Authenticate(_ context.Context, request *models.RequestContext) (map[string]any, string, error) {
+	token, err := a.tokens.extract(request)
+	if err != nil {
+		return nil, "", err
 	}
 
-	payload := splitToken[1]
+	payload := []byte(token)
 
-	token, err := jwt.Parse(
-		[]byte(payload),
-		jwt.WithKey(a.alg, a.key))
+	verifier, err := a.selectIssuer(payload)
+	if err != nil {
+		return nil, "", err
+	}
 
+	verified, err := verifier.verify(payload)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tokenClaims(verified), verifier.issuer(), nil
+}
+
+// ReadsForm reports if one of the token sources is a form field
+func (a AuthenticatorImpl) ReadsForm() bool {
+	return a.tokens.readsForm()
+}
+
+// tokenClaims returns the private claims of the token together with its registered claims.
+// Registered claims are normalized to the types they would have in a plain JSON document:
+// strings, an array of audiences and numeric dates as seconds since epoch.
+func tokenClaims(token jwt.Token) map[string]any {
+	private := token.PrivateClaims()
+	claims := make(map[string]any, len(private)+7)
+
+	for k, v := range private {
+		claims[k] = v
+	}
+
+	for k, v := range map[string]string{
+		jwt.IssuerKey:  token.Issuer(),
+		jwt.SubjectKey: token.Subject(),
+		jwt.JwtIDKey:   token.JwtID(),
+	} {
+		if v != "" {
+			claims[k] = v
+		}
+	}
+
+	if audiences := token.Audience(); len(audiences) != 0 {
+		aud := make([]any, len(audiences))
+		for i, a := range audiences {
+			aud[i] = a
+		}
+		claims[jwt.AudienceKey] = aud
+	}
+
+	for k, v := range map[string]time.Time{
+		jwt.ExpirationKey: token.Expiration(),
+		jwt.IssuedAtKey:   token.IssuedAt(),
+		jwt.NotBeforeKey:  token.NotBefore(),
+	} {
+		if !v.IsZero() {
+			claims[k] = float64(v.Unix())
+		}
+	}
+
+	return claims
+}
+
+// selectIssuer picks the trusted issuer to validate the token with by the token's unverified "iss" claim.
+// An issuer configured without a name accepts the tokens that are not claimed by any named issuer.
+func (a AuthenticatorImpl) selectIssuer(payload []byte) (*issuerVerifier, error) {
+	unverified, err := jwt.Parse(payload, jwt.WithVerify(false), jwt.WithValidate(false))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %v", err)
 	}
 
-	err = jwt.Validate(token, options...)
+	var fallback *issuerVerifier
+	for _, v := range a.issuers {
+		issuer := v.issuer()
+
+		if issuer == "" {
+			if fallback == nil {
+				fallback = v
+			}
+			continue
+		}
+
+		if issuer == unverified.Issuer() {
+			return v, nil
+		}
+	}
+
+	if fallback == nil {
+		return nil, fmt.Errorf("untrusted issuer: %q", unverified.Issuer())
+	}
+
+	return fallback, nil
+}
+
+// issuer returns the configured or discovered issuer name
+func (v issuerVerifier) issuer() string {
+	if v.discovery != nil {
+		return v.discovery.Issuer()
+	}
+
+	return v.config.Issuer
+}
+
+// verify checks the token signature and validates its claims against the issuer's settings
+func (v issuerVerifier) verify(payload []byte) (jwt.Token, error) {
+	options := []jwt.ParseOption{jwt.WithKeyProvider(v.keyProvider)}
+
+	if issuer := v.issuer(); issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+
+	if v.config.Audience != "" {
+		options = append(options, jwt.WithAudience(v.config.Audience))
+	}
+
+	if v.config.ClockSkewInSeconds != 0 {
+		options = append(options, jwt.WithAcceptableSkew(time.Duration(v.config.ClockSkewInSeconds)*time.Second))
+	}
+
+	token, err := jwt.Parse(payload, options...)
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %v", err)
 	}
 
-	return token.PrivateClaims(), nil
+	return token, nil
 }
@@ -1,8 +1,26 @@
 package services_test
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/kaancfidan/bouncer/models"
 	"github.com/kaancfidan/bouncer/services"
@@ -100,7 +118,9 @@ func TestAuthenticatorImpl_Authenticate(t *testing.T) {
 			signingKey: []byte("TestKey"),
 			signingAlg: "HS256",
 			cfg: models.AuthenticationConfig{
-				Issuer: "http://url/to/some/issuer",
+				IssuerConfig: models.IssuerConfig{
+					Issuer: "http://url/to/some/issuer",
+				},
 			},
 			authHeader: "Bearer eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
 				"eyJ0ZXN0IjoidmFsaWQifQ." +
@@ -118,6 +138,7 @@ func TestAuthenticatorImpl_Authenticate(t *testing.T) {
 				"-SdBeoR7nVevkZIhKh-QlAl64k5ZzKQoV71f3Q-Djcs",
 			want: map[string]any{
 				"test": "valid",
+				"iss":  "http://url/to/some/issuer",
 			},
 			wantErr: false,
 		},
@@ -126,13 +147,16 @@ func TestAuthenticatorImpl_Authenticate(t *testing.T) {
 			signingKey: []byte("TestKey"),
 			signingAlg: "HS256",
 			cfg: models.AuthenticationConfig{
-				Issuer: "http://url/to/some/issuer",
+				IssuerConfig: models.IssuerConfig{
+					Issuer: "http://url/to/some/issuer",
+				},
 			},
 			authHeader: "Bearer eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
 				"eyJ0ZXN0IjoidmFsaWQiLCJpc3MiOiJodHRwOi8vdXJsL3RvL3NvbWUvaXNzdWVyIn0." +
 				"-SdBeoR7nVevkZIhKh-QlAl64k5ZzKQoV71f3Q-Djcs",
 			want: map[string]any{
 				"test": "valid",
+				"iss":  "http://url/to/some/issuer",
 			},
 			wantErr: false,
 		},
@@ -141,7 +165,9 @@ func TestAuthenticatorImpl_Authenticate(t *testing.T) {
 			signingKey: []byte("TestKey"),
 			signingAlg: "HS256",
 			cfg: models.AuthenticationConfig{
-				Audience: "http://url/to/some/audience",
+				IssuerConfig: models.IssuerConfig{
+					Audience: "http://url/to/some/audience",
+				},
 			},
 			authHeader: "Bearer eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
 				"eyJ0ZXN0IjoidmFsaWQifQ." +
@@ -159,6 +185,7 @@ func TestAuthenticatorImpl_Authenticate(t *testing.T) {
 				"QslmtoVNaP9OSeKRvkxeR_UBMTdXL6098xLtbJpx114",
 			want: map[string]any{
 				"test": "valid",
+				"aud":  []any{"http://url/to/some/audience"},
 			},
 			wantErr: false,
 		},
@@ -167,13 +194,16 @@ func TestAuthenticatorImpl_Authenticate(t *testing.T) {
 			signingKey: []byte("TestKey"),
 			signingAlg: "HS256",
 			cfg: models.AuthenticationConfig{
-				Audience: "http://url/to/some/audience",
+				IssuerConfig: models.IssuerConfig{
+					Audience: "http://url/to/some/audience",
+				},
 			},
 			authHeader: "Bearer eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
 				"eyJ0ZXN0IjoidmFsaWQiLCJhdWQiOiJodHRwOi8vdXJsL3RvL3NvbWUvYXVkaWVuY2UifQ." +
 				"QslmtoVNaP9OSeKRvkxeR_UBMTdXL6098xLtbJpx114",
 			want: map[string]any{
 				"test": "valid",
+				"aud":  []any{"http://url/to/some/audience"},
 			},
 			wantErr: false,
 		},
@@ -233,6 +263,7 @@ func TestAuthenticatorImpl_Authenticate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			a, err := services.NewAuthenticator(
+				context.Background(),
 				tt.signingKey,
 				tt.signingAlg,
 				tt.cfg)
@@ -242,7 +273,7 @@ func TestAuthenticatorImpl_Authenticate(t *testing.T) {
 				return
 			}
 
-			got, err := a.Authenticate(tt.authHeader)
+			got, _, err := a.Authenticate(context.Background(), authorizationRequest(tt.authHeader))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -306,13 +337,73 @@ func TestNewAuthenticator(t *testing.T) {
 				"MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAESQPkk+EQIbNiOsa5W1dQsBgr98Jl\n" +
 				"f3WzR1k8rcW0jCc3Bf0V/wqMdTcTL8yyyRjnMS6bABW1zHPnvjk/pV2+UQ==\n" +
 				"-----END PUBLIC KEY-----"),
-			signingAlg: "ES512",
+			signingAlg: "ES256",
 			wantErr:    false,
 		},
+		{
+			name: "ecdsa curve mismatch",
+			signingKey: []byte("-----BEGIN PUBLIC KEY-----\n" +
+				"MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAESQPkk+EQIbNiOsa5W1dQsBgr98Jl\n" +
+				"f3WzR1k8rcW0jCc3Bf0V/wqMdTcTL8yyyRjnMS6bABW1zHPnvjk/pV2+UQ==\n" +
+				"-----END PUBLIC KEY-----"),
+			signingAlg: "ES512",
+			wantErr:    true,
+		},
+		{
+			name: "rsa key with hmac algorithm",
+			signingKey: []byte("-----BEGIN PUBLIC KEY-----\n" +
+				"MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA3JaY7+LV0MHtD2+LsLus\n" +
+				"/N6965JYFSc138UpaAeG9HK13LEhR8xqFMSgX0S7nrumDDERP3+/VXW+AOat8DZ/\n" +
+				"HocrTuh1rQgQJgGFho/U0T9riTgm3eakFZi1Q2VjAYWIZizJ+wb+pttbGY1teLsW\n" +
+				"1BDheuRmPiII/78bOb2ERD3KyWUEbyL+zjVdemq6RbTg4v/0L27yPS+WLceaUlbL\n" +
+				"dBoJNjIKWF0odwQwqyp7KRN2KGR/SD9uWPL77KhWqNyhSHz7Ad9dYggnXbZg3d8O\n" +
+				"B2qNUYi+Z+hAXs20noxYC3y4dQY0c7NmFirIKTMPRnfOGMCumKbhQ6Dlp5zrCC50\n" +
+				"MwIDAQAB\n" +
+				"-----END PUBLIC KEY-----"),
+			signingAlg: "HS256",
+			wantErr:    true,
+		},
+		{
+			name:       "hmac key with rsa algorithm",
+			signingKey: []byte("TestKey"),
+			signingAlg: "RS256",
+			wantErr:    true,
+		},
+		{
+			name:       "invalid pem",
+			signingKey: []byte("-----BEGIN PUBLIC KEY-----\nnot base64\n-----END PUBLIC KEY-----"),
+			signingAlg: "RS256",
+			wantErr:    true,
+		},
+		{
+			name:       "invalid jwk",
+			signingKey: []byte(`{"kty":"RSA"`),
+			signingAlg: "RS256",
+			wantErr:    true,
+		},
+		{
+			name:       "jwk algorithm mismatch",
+			signingKey: []byte(`{"kty":"oct","k":"VGVzdEtleQ","alg":"HS512"}`),
+			signingAlg: "HS256",
+			wantErr:    true,
+		},
+		{
+			name:       "jwk for encryption",
+			signingKey: []byte(`{"kty":"oct","k":"VGVzdEtleQ","use":"enc"}`),
+			signingAlg: "HS256",
+			wantErr:    true,
+		},
+		{
+			name:       "none algorithm",
+			signingKey: []byte("TestKey"),
+			signingAlg: "none",
+			wantErr:    true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_, err := services.NewAuthenticator(
+				context.Background(),
 				tt.signingKey,
 				tt.signingAlg,
 				tt.cfg)
@@ -324,3 +415,458 @@ func TestNewAuthenticator(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthenticatorImpl_Authenticate_AsymmetricKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name       string
+		signingKey []byte
+		signingAlg string
+		token      string
+		wantErr    bool
+	}{
+		{
+			name:       "rsa public key pem",
+			signingKey: publicKeyPEM(t, &rsaKey.PublicKey),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, ""),
+		},
+		{
+			name:       "rsa-pss public key pem",
+			signingKey: publicKeyPEM(t, &rsaKey.PublicKey),
+			signingAlg: "PS384",
+			token:      signTestToken(t, jwa.PS384, rsaKey, ""),
+		},
+		{
+			name:       "rsa pkcs1 public key pem",
+			signingKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)}),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, ""),
+		},
+		{
+			name:       "rsa certificate pem",
+			signingKey: certificatePEM(t, rsaKey),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, ""),
+		},
+		{
+			name:       "rsa jwk",
+			signingKey: publicKeyJSON(t, &rsaKey.PublicKey, ""),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, ""),
+		},
+		{
+			name: "rsa jwk set picks key by kid",
+			signingKey: []byte(`{"keys":[` +
+				string(publicKeyJSON(t, &otherRSAKey.PublicKey, "other")) + "," +
+				string(publicKeyJSON(t, &rsaKey.PublicKey, "mine")) + `]}`),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, "mine"),
+		},
+		{
+			name: "rsa jwk set with unknown kid",
+			signingKey: []byte(`{"keys":[` +
+				string(publicKeyJSON(t, &otherRSAKey.PublicKey, "other")) + "," +
+				string(publicKeyJSON(t, &rsaKey.PublicKey, "mine")) + `]}`),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, "unknown"),
+			wantErr:    true,
+		},
+		{
+			name: "rsa jwk set without kid in token",
+			signingKey: []byte(`{"keys":[` +
+				string(publicKeyJSON(t, &otherRSAKey.PublicKey, "other")) + "," +
+				string(publicKeyJSON(t, &rsaKey.PublicKey, "mine")) + `]}`),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, ""),
+		},
+		{
+			name:       "rsa wrong key",
+			signingKey: publicKeyPEM(t, &otherRSAKey.PublicKey),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, ""),
+			wantErr:    true,
+		},
+		{
+			name:       "rsa private key pem",
+			signingKey: privateKeyPEM(t, rsaKey),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.RS256, rsaKey, ""),
+		},
+		{
+			name:       "ecdsa public key pem",
+			signingKey: publicKeyPEM(t, &ecKey.PublicKey),
+			signingAlg: "ES256",
+			token:      signTestToken(t, jwa.ES256, ecKey, ""),
+		},
+		{
+			name:       "ecdsa jwk",
+			signingKey: publicKeyJSON(t, &ecKey.PublicKey, ""),
+			signingAlg: "ES256",
+			token:      signTestToken(t, jwa.ES256, ecKey, ""),
+		},
+		{
+			name:       "eddsa public key pem",
+			signingKey: publicKeyPEM(t, edPublicKey),
+			signingAlg: "EdDSA",
+			token:      signTestToken(t, jwa.EdDSA, edKey, ""),
+		},
+		{
+			name:       "token signed with a different algorithm",
+			signingKey: publicKeyPEM(t, &rsaKey.PublicKey),
+			signingAlg: "RS256",
+			token:      signTestToken(t, jwa.PS256, rsaKey, ""),
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthenticator(
+				context.Background(),
+				tt.signingKey,
+				tt.signingAlg,
+				models.AuthenticationConfig{})
+
+			if err != nil {
+				t.Errorf("could not create authenticator: %v", err)
+				return
+			}
+
+			got, _, err := a.Authenticate(context.Background(), authorizationRequest("Bearer "+tt.token))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, map[string]any{"test": "valid"}) {
+				t.Errorf("Authenticate() got = %v", got)
+			}
+		})
+	}
+}
+
+func signTestToken(t *testing.T, alg jwa.SignatureAlgorithm, key any, kid string) string {
+	return signTestTokenWithClaims(t, alg, key, kid, map[string]any{"test": "valid"})
+}
+
+func signTestTokenWithClaims(t *testing.T, alg jwa.SignatureAlgorithm, key any, kid string, claims map[string]any) string {
+	token := jwt.New()
+	for k, v := range claims {
+		assert.Nil(t, token.Set(k, v))
+	}
+
+	signingKey, err := jwk.FromRaw(key)
+	assert.Nil(t, err)
+
+	if kid != "" {
+		assert.Nil(t, signingKey.Set(jwk.KeyIDKey, kid))
+	}
+
+	signed, err := jwt.Sign(token, jwt.WithKey(alg, signingKey))
+	assert.Nil(t, err)
+
+	return string(signed)
+}
+
+func publicKeyPEM(t *testing.T, key any) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	assert.Nil(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func privateKeyPEM(t *testing.T, key any) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicKeyJSON(t *testing.T, key any, kid string) []byte {
+	jwkKey, err := jwk.FromRaw(key)
+	assert.Nil(t, err)
+
+	if kid != "" {
+		assert.Nil(t, jwkKey.Set(jwk.KeyIDKey, kid))
+	}
+
+	buf, err := json.Marshal(jwkKey)
+	assert.Nil(t, err)
+
+	return buf
+}
+
+func certificatePEM(t *testing.T, key *rsa.PrivateKey) []byte {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "bouncer test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestAuthenticatorImpl_Authenticate_MultipleIssuers(t *testing.T) {
+	customerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	internalKey := []byte("InternalServiceKey")
+	fallbackKey := []byte("FallbackKey")
+	now := time.Now().Unix()
+
+	cfg := models.AuthenticationConfig{
+		IssuerConfig: models.IssuerConfig{
+			Issuer: "https://internal",
+		},
+		Issuers: []models.IssuerConfig{
+			{
+				Issuer:     "https://customers",
+				Audience:   "gateway",
+				SigningKey: string(publicKeyPEM(t, &customerKey.PublicKey)),
+				Algorithms: []string{"RS256", "PS256"},
+			},
+			{
+				SigningKey: string(fallbackKey),
+				Algorithms: []string{"HS512"},
+			},
+			{
+				Issuer:             "https://lenient",
+				SigningKey:         string(internalKey),
+				Algorithms:         []string{"HS256"},
+				ClockSkewInSeconds: 3600,
+			},
+		},
+	}
+
+	a, err := services.NewAuthenticator(context.Background(), internalKey, "HS256", cfg)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantIssuer string
+		wantErr    bool
+	}{
+		{
+			name: "default issuer",
+			token: signTestTokenWithClaims(t, jwa.HS256, internalKey, "",
+				map[string]any{"iss": "https://internal"}),
+			wantIssuer: "https://internal",
+		},
+		{
+			name: "listed issuer",
+			token: signTestTokenWithClaims(t, jwa.PS256, customerKey, "",
+				map[string]any{"iss": "https://customers", "aud": "gateway"}),
+			wantIssuer: "https://customers",
+		},
+		{
+			name: "listed issuer with another issuer's key",
+			token: signTestTokenWithClaims(t, jwa.HS256, internalKey, "",
+				map[string]any{"iss": "https://customers", "aud": "gateway"}),
+			wantErr: true,
+		},
+		{
+			name: "listed issuer audience mismatch",
+			token: signTestTokenWithClaims(t, jwa.RS256, customerKey, "",
+				map[string]any{"iss": "https://customers", "aud": "billing"}),
+			wantErr: true,
+		},
+		{
+			name: "issuer specific clock skew",
+			token: signTestTokenWithClaims(t, jwa.HS256, internalKey, "",
+				map[string]any{"iss": "https://lenient", "exp": now - 60}),
+			wantIssuer: "https://lenient",
+		},
+		{
+			name: "clock skew is not shared",
+			token: signTestTokenWithClaims(t, jwa.HS256, internalKey, "",
+				map[string]any{"iss": "https://internal", "exp": now - 60}),
+			wantErr: true,
+		},
+		{
+			name: "unknown issuer falls back to unnamed issuer",
+			token: signTestTokenWithClaims(t, jwa.HS512, fallbackKey, "",
+				map[string]any{"iss": "https://unknown"}),
+			wantIssuer: "",
+		},
+		{
+			name: "unknown issuer rejected by unnamed issuer",
+			token: signTestTokenWithClaims(t, jwa.HS256, internalKey, "",
+				map[string]any{"iss": "https://unknown"}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, issuer, err := a.Authenticate(context.Background(), authorizationRequest("Bearer "+tt.token))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if issuer != tt.wantIssuer {
+				t.Errorf("Authenticate() issuer = %v, want %v", issuer, tt.wantIssuer)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticator_MultipleIssuers(t *testing.T) {
+	tests := []struct {
+		name       string
+		signingKey []byte
+		signingAlg string
+		cfg        models.AuthenticationConfig
+		wantErr    bool
+	}{
+		{
+			name: "listed issuers only",
+			cfg: models.AuthenticationConfig{
+				Issuers: []models.IssuerConfig{
+					{Issuer: "https://internal", SigningKey: "TestKey", Algorithms: []string{"HS256"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "default issuer key from config",
+			cfg: models.AuthenticationConfig{
+				IssuerConfig: models.IssuerConfig{SigningKey: "TestKey", Algorithms: []string{"HS256"}},
+				Issuers: []models.IssuerConfig{
+					{Issuer: "https://internal", SigningKey: "TestKey", Algorithms: []string{"HS256"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "listed issuer without algorithm",
+			cfg: models.AuthenticationConfig{
+				Issuers: []models.IssuerConfig{
+					{Issuer: "https://internal", SigningKey: "TestKey"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listed issuer with invalid key",
+			cfg: models.AuthenticationConfig{
+				Issuers: []models.IssuerConfig{
+					{Issuer: "https://internal", SigningKey: "TestKey", Algorithms: []string{"RS256"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listed issuer without key",
+			cfg: models.AuthenticationConfig{
+				Issuers: []models.IssuerConfig{
+					{Issuer: "https://internal", Algorithms: []string{"HS256"}},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := services.NewAuthenticator(context.Background(), tt.signingKey, tt.signingAlg, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAuthenticator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorImpl_Authenticate_RegisteredClaims(t *testing.T) {
+	key := []byte("ThisIsSupposedToBeALongStringOfBytesLikeSixtyFourCharactersLong.")
+
+	a, err := services.NewAuthenticator(context.Background(), key, "HS256", models.AuthenticationConfig{})
+	assert.Nil(t, err)
+
+	issuedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	expiration := issuedAt.Add(time.Hour)
+
+	token := signTestTokenWithClaims(t, jwa.HS256, key, "", map[string]any{
+		"iss":   "https://idp",
+		"sub":   "admin-service",
+		"aud":   "billing-api",
+		"jti":   "token-id",
+		"azp":   "client",
+		"iat":   issuedAt,
+		"nbf":   issuedAt,
+		"exp":   expiration,
+		"roles": []any{"admin"},
+	})
+
+	got, _, err := a.Authenticate(context.Background(), authorizationRequest("Bearer "+token))
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]any{
+		"iss":   "https://idp",
+		"sub":   "admin-service",
+		"aud":   []any{"billing-api"},
+		"jti":   "token-id",
+		"azp":   "client",
+		"iat":   float64(issuedAt.Unix()),
+		"nbf":   float64(issuedAt.Unix()),
+		"exp":   float64(expiration.Unix()),
+		"roles": []any{"admin"},
+	}, got)
+}
+
+func authorizationRequest(authHeader string) *models.RequestContext {
+	header := http.Header{}
+	header.Set("Authorization", authHeader)
+
+	return &models.RequestContext{Header: header}
+}
+
+func TestAuthenticatorImpl_ReadsForm(t *testing.T) {
+	tests := []struct {
+		name         string
+		tokenSources []models.TokenSource
+		want         bool
+	}{
+		{
+			name: "default token source",
+			want: false,
+		},
+		{
+			name:         "header and cookie token sources",
+			tokenSources: []models.TokenSource{{Header: "X-Token"}, {Cookie: "session"}},
+			want:         false,
+		},
+		{
+			name:         "form token source",
+			tokenSources: []models.TokenSource{{Header: "Authorization", Scheme: "Bearer"}, {Form: "access_token"}},
+			want:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthenticator(context.Background(), []byte("secret"), "HS256",
+				models.AuthenticationConfig{TokenSources: tt.tokenSources})
+			if err != nil {
+				t.Fatalf("NewAuthenticator() error = %v", err)
+			}
+
+			if got := a.ReadsForm(); got != tt.want {
+				t.Errorf("ReadsForm() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
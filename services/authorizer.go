@@ -1,100 +1,265 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
 
 	"github.com/kaancfidan/bouncer/models"
 )
 
 // Authorizer is the claims-based authorization interface
 type Authorizer interface {
-	Authorize(policyNames []string, claims map[string]any) (failedPolicy string, err error)
+	Authorize(
+		ctx context.Context,
+		matchedPolicies []models.RoutePolicy,
+		request *models.RequestContext,
+		claims map[string]any) (models.Decision, error)
 	IsAnonymousAllowed(matchedPolicies []models.RoutePolicy, method string) bool
+	CheckDenyPolicies(
+		matchedPolicies []models.RoutePolicy,
+		request *models.RequestContext,
+		claims map[string]any) (deniedBy string, err error)
+	CheckTokenRequirements(
+		matchedPolicies []models.RoutePolicy,
+		issuer string,
+		claims map[string]any) (failedRequirement string)
 }
 
 // AuthorizerImpl implements claims base authorization
 type AuthorizerImpl struct {
 	claimPolicies map[string][]models.ClaimRequirement
+	regexps       map[string]*regexp.Regexp
+	claimPaths    map[string]claimPath
+	networks      map[string]*net.IPNet
+	expressions   map[string]cel.Program
+	roles         *roleExpander
+	// redactedClaims are the claims whose values are left out of decisions
+	redactedClaims []string
 }
 
-// NewAuthorizer creates a new AuthorizerImpl instance
-func NewAuthorizer(claimPolicies map[string][]models.ClaimRequirement) *AuthorizerImpl {
-	return &AuthorizerImpl{claimPolicies: claimPolicies}
-}
+// NewAuthorizer creates a new AuthorizerImpl instance.
+// Regular expressions, claim paths, cidrs and expressions of the claim policies are compiled here, the first one that
+// does not compile is returned as an error.
+func NewAuthorizer(
+	claimPolicies map[string][]models.ClaimRequirement,
+	roles models.RolesConfig,
+	redactedClaims []string) (*AuthorizerImpl, error) {
+
+	regexps, err := compileRegexps(claimPolicies)
+	if err != nil {
+		return nil, err
+	}
 
-// Authorize checks claim values and returns the first failed claim
-func (a AuthorizerImpl) Authorize(policyNames []string, claims map[string]any) (failedClaim string, err error) {
-	claimPolicies, err := a.getClaimPolicies(policyNames)
+	claimPaths, err := compileClaimPaths(claimPolicies)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	for _, cp := range claimPolicies {
-		claim, exists := claims[cp.Claim]
+	networks, err := compileNetworks(claimPolicies)
+	if err != nil {
+		return nil, err
+	}
 
-		if !exists {
-			failedClaim = cp.Claim
-			break
+	expressions, err := compileExpressions(claimPolicies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthorizerImpl{
+		claimPolicies:  claimPolicies,
+		regexps:        regexps,
+		claimPaths:     claimPaths,
+		networks:       networks,
+		expressions:    expressions,
+		roles:          newRoleExpander(roles),
+		redactedClaims: redactedClaims,
+	}, nil
+}
+
+// route policy modes, deciding if all or any of the named claim policies must pass
+const (
+	policyModeRequireAll = "requireAll"
+	policyModeRequireAny = "requireAny"
+)
+
+// Authorize checks the claim policies of the matched route policies and decides if the request is allowed.
+//
+// Claim policies of all matched routes must pass, unless a more specific route overrides them.
+// Within a route, all named claim policies must pass, or any of them in requireAny mode.
+// Every requirement is evaluated, so that the decision lists all failed requirements, not only the first one.
+//
+// Header, query, host and client IP requirements are read from the request.
+// Path parameters referred by valueFrom, and those of expressions, are the ones captured by the route policy
+// that names the claim policy, never those of another matched route.
+// Expressions are evaluated with the claims, the request and its path parameters.
+//
+// If roles are defined, the effective permissions of the token's roles are added to the permissions claim first.
+//
+// This function expects the matchedPolicies to be sorted by decreasing specificity.
+func (a AuthorizerImpl) Authorize(
+	_ context.Context,
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) (models.Decision, error) {
+
+	start := time.Now()
+
+	claims = a.roles.expand(claims)
+	checked := make(map[string]bool)
+
+	decision := models.Decision{Allowed: true}
+
+	policies := effectivePolicies(matchedPolicies)
+	if len(policies) != 0 {
+		decision.RoutePolicy = policies[0].Path
+	}
+
+	for _, rp := range policies {
+		results, err := a.checkRoutePolicy(rp, request, claims, checked)
+		if err != nil {
+			return models.Decision{}, err
 		}
 
-		// if no value specified, policy passes just by existing
-		if cp.Values == nil {
+		decision.Record(results...)
+	}
+
+	decision.Duration = time.Since(start)
+
+	return decision, nil
+}
+
+// CheckDenyPolicies returns the path of the first matched deny route policy whose claim policies pass.
+// Deny route policies without claim policies deny unconditionally.
+//
+// All matched deny route policies are checked regardless of their specificity, overriding routes do not drop them.
+// Anonymous requests are checked with nil claims. Roles are expanded into permissions as in Authorize.
+func (a AuthorizerImpl) CheckDenyPolicies(
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) (deniedBy string, err error) {
+
+	claims = a.roles.expand(claims)
+
+	for _, rp := range matchedPolicies {
+		if !rp.Deny {
 			continue
 		}
 
-		// if the matching claim in the token is an array
-		// check if the array contains the expected value
-		if arr, ok := claim.([]any); ok {
-			found := false
-			for _, val := range arr {
-				for _, cfgVal := range cp.Values {
-					if claimEquals(val, cfgVal) {
-						found = true
-						break
-					}
-				}
-
-				if found {
-					break
-				}
-			}
+		results, err := a.checkRoutePolicy(rp, request, claims, make(map[string]bool))
+		if err != nil {
+			return "", err
+		}
 
-			if !found {
-				failedClaim = cp.Claim
-				break
+		decision := models.Decision{Allowed: true}
+		decision.Record(results...)
+
+		if decision.Allowed {
+			return rp.Path, nil
+		}
+	}
+
+	return "", nil
+}
+
+// checkRoutePolicy checks the claim policies named by a route policy and returns the result of each requirement.
+// Requirements are evaluated with the path parameters captured by the route policy itself.
+// Policies in the checked set are skipped in requireAll mode, and added to it after being checked.
+// A policy is only skipped if it was checked with the same path parameters.
+// In requireAny mode, the results of every named policy are returned, and the failures are waived
+// if any of the policies passed.
+func (a AuthorizerImpl) checkRoutePolicy(
+	rp models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any,
+	checked map[string]bool) ([]models.RequirementResult, error) {
+
+	names := routePolicyNames(rp)
+	request = routeRequest(rp, request)
+
+	for _, name := range names {
+		if a.claimPolicies[name] == nil {
+			return nil, fmt.Errorf("missing policy config: %s", name)
+		}
+	}
+
+	var results []models.RequirementResult
+
+	if rp.PolicyMode == policyModeRequireAny && len(names) > 1 {
+		anyPassed := false
+
+		for _, name := range names {
+			policyResults := a.policyResults(rp, name, claims, request)
+			results = append(results, policyResults...)
+
+			policyPassed := true
+			for _, r := range policyResults {
+				policyPassed = policyPassed && r.Passed
 			}
 
-			continue
+			anyPassed = anyPassed || policyPassed
 		}
 
-		// if the matching claim is not an array, check direct equality with expectation
-		found := false
-		for _, cfgVal := range cp.Values {
-			if claimEquals(claims[cp.Claim], cfgVal) {
-				found = true
-				break
+		if anyPassed {
+			for i := range results {
+				results[i].Waived = !results[i].Passed
 			}
 		}
 
-		if !found {
-			failedClaim = cp.Claim
+		return results, nil
+	}
+
+	for _, name := range names {
+		key := checkedKey(name, rp.PathParams)
+
+		// policy already checked
+		if checked[key] {
+			continue
 		}
+
+		checked[key] = true
+
+		results = append(results, a.policyResults(rp, name, claims, request)...)
+	}
+
+	return results, nil
+}
+
+// policyResults checks the requirements of a claim policy named by a route policy
+func (a AuthorizerImpl) policyResults(
+	rp models.RoutePolicy,
+	name string,
+	claims map[string]any,
+	request *models.RequestContext) []models.RequirementResult {
+
+	results := make([]models.RequirementResult, 0, len(a.claimPolicies[name]))
+	for _, cp := range a.claimPolicies[name] {
+		results = append(results, a.requirementResult(rp, name, cp, claims, request))
 	}
 
-	return failedClaim, nil
+	return results
 }
 
 // IsAnonymousAllowed allows anonymous requests if the most specific route that matches the request has AllowAnonymous
 // set to true.
 //
-// This function expects the matchedPolicies to be sorted by decreasing path length and wildcard specificity.
+// This function expects the matchedPolicies to be sorted by decreasing priority and specificity.
 //
-// If more than one route with the same path and wildcard specifity matches the request, first one that also matches
-// the method decides if allowed anonymously.
+// If more than one route with the same path, priority, host specificity and number of header and query parameter
+// conditions matches the request, first one that also matches the method decides if allowed anonymously.
 //
 // If no route policy is matched to the request, the default behavior is to authenticate.
+//
+// Deny route policies are not taken into account here, they are checked by CheckDenyPolicies and always win.
 func (a AuthorizerImpl) IsAnonymousAllowed(matchedPolicies []models.RoutePolicy, method string) bool {
+	matchedPolicies = allowPolicies(matchedPolicies)
+
 	if len(matchedPolicies) == 0 {
 		return false
 	}
@@ -117,7 +282,7 @@ func (a AuthorizerImpl) IsAnonymousAllowed(matchedPolicies []models.RoutePolicy,
 		normalizedPath := "/" + strings.Trim(matchedPolicies[i].Path, " \t\n/") + "/"
 		normalizedWinnerPath := "/" + strings.Trim(mostSpecificPolicy.Path, " \t\n/") + "/"
 
-		if normalizedPath == normalizedWinnerPath {
+		if normalizedPath == normalizedWinnerPath && compareRoutePolicies(matchedPolicies[i], mostSpecificPolicy) == 0 {
 			mostSpecificPolicy = matchedPolicies[i]
 		}
 	}
@@ -125,29 +290,145 @@ func (a AuthorizerImpl) IsAnonymousAllowed(matchedPolicies []models.RoutePolicy,
 	return mostSpecificPolicy.AllowAnonymous
 }
 
-func (a AuthorizerImpl) getClaimPolicies(policyNames []string) ([]models.ClaimRequirement, error) {
-	keys := make(map[string]bool)
-	var claimPolicies []models.ClaimRequirement
+// CheckTokenRequirements checks the issuer, audience and scope requirements of all matched route policies
+// and returns the first failed requirement. Requirements of routes overridden by a more specific route are skipped.
+//
+// The token must be issued by one of the listed issuers, and must have at least one of the listed audiences.
+// All listed scopes must be granted in the space delimited "scope" or "scp" claims, "scp" can also be an array.
+func (a AuthorizerImpl) CheckTokenRequirements(
+	matchedPolicies []models.RoutePolicy,
+	issuer string,
+	claims map[string]any) (failedRequirement string) {
+
+	audiences := claimStrings(claims["aud"], false)
+	scopes := append(claimStrings(claims["scope"], true), claimStrings(claims["scp"], true)...)
 
-	for _, policyName := range policyNames {
-		// policy already added
-		if _, value := keys[policyName]; value {
-			continue
+	for _, p := range effectivePolicies(matchedPolicies) {
+		if p.Issuers != nil && !containsAny(p.Issuers, []string{issuer}) {
+			return fmt.Sprintf("issuer %q", issuer)
+		}
+
+		if p.Audiences != nil && !containsAny(p.Audiences, audiences) {
+			return fmt.Sprintf("audience %v", p.Audiences)
+		}
+
+		for _, scope := range p.Scopes {
+			if !containsAny(scopes, []string{scope}) {
+				return fmt.Sprintf("scope %q", scope)
+			}
+		}
+	}
+
+	return ""
+}
+
+// claimStrings returns the string values of a claim that is either a string or an array of strings.
+// If split is set, string values are split into space delimited parts.
+func claimStrings(claim any, split bool) []string {
+	var values []string
+
+	switch v := claim.(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+	case []string:
+		values = v
+	}
+
+	if !split {
+		return values
+	}
+
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, strings.Fields(v)...)
+	}
+
+	return parts
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, v := range values {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
 		}
+	}
 
-		policy := a.claimPolicies[policyName]
+	return false
+}
 
-		if policy == nil {
-			return nil, fmt.Errorf("missing policy config: %s", policyName)
+// routePolicyNames returns the claim policy names of a route policy
+func routePolicyNames(rp models.RoutePolicy) []string {
+	if rp.PolicyName != "" {
+		return []string{rp.PolicyName}
+	}
+
+	return rp.PolicyNames
+}
+
+// routeRequest returns a copy of the request with the path parameters captured by the route policy
+func routeRequest(rp models.RoutePolicy, request *models.RequestContext) *models.RequestContext {
+	if request == nil {
+		return nil
+	}
+
+	scoped := *request
+	scoped.PathParams = rp.PathParams
+
+	return &scoped
+}
+
+// checkedKey identifies a claim policy checked with the given path parameters
+func checkedKey(name string, params map[string]string) string {
+	if len(params) == 0 {
+		return name
+	}
+
+	values := make(url.Values, len(params))
+	for k, v := range params {
+		values.Set(k, v)
+	}
+
+	return name + "?" + values.Encode()
+}
+
+// effectivePolicies drops deny route policies, and the route policies that are less specific than the first
+// overriding route policy, or of lower priority. Route policies of the same priority and specificity as the
+// overriding one are kept.
+func effectivePolicies(matchedPolicies []models.RoutePolicy) []models.RoutePolicy {
+	allowPolicies := allowPolicies(matchedPolicies)
+
+	for i, rp := range allowPolicies {
+		if !rp.Override {
+			continue
+		}
+
+		end := i + 1
+		for end < len(allowPolicies) && compareRoutePolicies(allowPolicies[end], rp) == 0 {
+			end++
 		}
 
-		keys[policyName] = true
-		claimPolicies = append(claimPolicies, policy...)
+		return allowPolicies[:end]
 	}
 
-	return claimPolicies, nil
+	return allowPolicies
 }
 
-func claimEquals(claim any, expectation string) bool {
-	return fmt.Sprintf("%v", claim) == expectation
+// allowPolicies returns the route policies that are not deny route policies
+func allowPolicies(matchedPolicies []models.RoutePolicy) []models.RoutePolicy {
+	policies := make([]models.RoutePolicy, 0, len(matchedPolicies))
+	for _, rp := range matchedPolicies {
+		if !rp.Deny {
+			policies = append(policies, rp)
+		}
+	}
+
+	return policies
 }
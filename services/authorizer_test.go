@@ -1,6 +1,11 @@
 package services_test
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
 	"testing"
 
 	"github.com/kaancfidan/bouncer/models"
@@ -269,6 +274,30 @@ func TestAuthorizerImpl_Authorize(t *testing.T) {
 			wantFailedClaim: "",
 			wantErr:         false,
 		},
+		{
+			name: "registered claim matches",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"BillingAdmin": {
+					models.ClaimRequirement{
+						Claim:  "aud",
+						Values: []string{"billing-api"},
+					},
+					models.ClaimRequirement{
+						Claim:  "sub",
+						Values: []string{"admin-service"},
+					},
+				},
+			},
+			args: args{
+				policyNames: []string{"BillingAdmin"},
+				claims: map[string]any{
+					"aud": []any{"billing-api", "orders-api"},
+					"sub": "admin-service",
+				},
+			},
+			wantFailedClaim: "",
+			wantErr:         false,
+		},
 		{
 			name: "array claim match to array",
 			claimPolicies: map[string][]models.ClaimRequirement{
@@ -279,29 +308,1415 @@ func TestAuthorizerImpl_Authorize(t *testing.T) {
 					},
 				},
 			},
-			args: args{
-				policyNames: []string{"CanAddOrDelete"},
-				claims: map[string]any{
-					"permission": []any{"Test", "Add"},
-				},
+			args: args{
+				policyNames: []string{"CanAddOrDelete"},
+				claims: map[string]any{
+					"permission": []any{"Test", "Add"},
+				},
+			},
+			wantFailedClaim: "",
+			wantErr:         false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(tt.claimPolicies, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyNames: tt.args.policyNames}}, nil, tt.args.claims)
+			gotFailedPolicy := decision.FailedRequirement()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if gotFailedPolicy != tt.wantFailedClaim {
+				t.Errorf("Authorize() = %v, want %v", gotFailedPolicy, tt.wantFailedClaim)
+			}
+		})
+	}
+}
+
+func TestAuthorizerImpl_Authorize_Operators(t *testing.T) {
+	tests := []struct {
+		name        string
+		requirement models.ClaimRequirement
+		claims      map[string]any
+		want        bool
+	}{
+		{
+			name:        "equals - number formats",
+			requirement: models.ClaimRequirement{Claim: "level", Operator: "equals", Values: []string{"1.0"}},
+			claims:      map[string]any{"level": float64(1)},
+			want:        true,
+		},
+		{
+			name:        "equals - bool is not a string",
+			requirement: models.ClaimRequirement{Claim: "admin", Operator: "equals", Values: []string{"true"}},
+			claims:      map[string]any{"admin": "yes"},
+			want:        false,
+		},
+		{
+			name:        "equals - bool",
+			requirement: models.ClaimRequirement{Claim: "admin", Operator: "equals", Values: []string{"TRUE"}},
+			claims:      map[string]any{"admin": true},
+			want:        true,
+		},
+		{
+			name:        "equals - case sensitive",
+			requirement: models.ClaimRequirement{Claim: "name", Operator: "equals", Values: []string{"john"}},
+			claims:      map[string]any{"name": "John"},
+			want:        false,
+		},
+		{
+			name: "equals - case insensitive",
+			requirement: models.ClaimRequirement{
+				Claim: "name", Operator: "equals", Values: []string{"john"}, CaseInsensitive: true,
+			},
+			claims: map[string]any{"name": "John"},
+			want:   true,
+		},
+		{
+			name:        "notEquals - different",
+			requirement: models.ClaimRequirement{Claim: "suspended", Operator: "notEquals", Values: []string{"true"}},
+			claims:      map[string]any{"suspended": false},
+			want:        true,
+		},
+		{
+			name:        "notEquals - equal",
+			requirement: models.ClaimRequirement{Claim: "suspended", Operator: "notEquals", Values: []string{"true"}},
+			claims:      map[string]any{"suspended": true},
+			want:        false,
+		},
+		{
+			name:        "notEquals - missing claim",
+			requirement: models.ClaimRequirement{Claim: "suspended", Operator: "notEquals", Values: []string{"true"}},
+			claims:      map[string]any{},
+			want:        true,
+		},
+		{
+			name:        "in",
+			requirement: models.ClaimRequirement{Claim: "dept", Operator: "in", Values: []string{"HR", "IT"}},
+			claims:      map[string]any{"dept": "IT"},
+			want:        true,
+		},
+		{
+			name:        "in - missing claim",
+			requirement: models.ClaimRequirement{Claim: "dept", Operator: "in", Values: []string{"HR", "IT"}},
+			claims:      map[string]any{},
+			want:        false,
+		},
+		{
+			name:        "notIn - array contains one",
+			requirement: models.ClaimRequirement{Claim: "roles", Operator: "notIn", Values: []string{"guest", "banned"}},
+			claims:      map[string]any{"roles": []any{"user", "banned"}},
+			want:        false,
+		},
+		{
+			name:        "notIn - array contains none",
+			requirement: models.ClaimRequirement{Claim: "roles", Operator: "notIn", Values: []string{"guest", "banned"}},
+			claims:      map[string]any{"roles": []any{"user", "admin"}},
+			want:        true,
+		},
+		{
+			name:        "regex",
+			requirement: models.ClaimRequirement{Claim: "email", Operator: "regex", Values: []string{`@example\.com$`}},
+			claims:      map[string]any{"email": "john@example.com"},
+			want:        true,
+		},
+		{
+			name:        "regex - no match",
+			requirement: models.ClaimRequirement{Claim: "email", Operator: "regex", Values: []string{`@example\.com$`}},
+			claims:      map[string]any{"email": "john@example.com.evil"},
+			want:        false,
+		},
+		{
+			name: "regex - case insensitive",
+			requirement: models.ClaimRequirement{
+				Claim: "email", Operator: "regex", Values: []string{`@example\.com$`}, CaseInsensitive: true,
+			},
+			claims: map[string]any{"email": "JOHN@EXAMPLE.COM"},
+			want:   true,
+		},
+		{
+			name:        "prefix",
+			requirement: models.ClaimRequirement{Claim: "sub", Operator: "prefix", Values: []string{"service:"}},
+			claims:      map[string]any{"sub": "service:billing"},
+			want:        true,
+		},
+		{
+			name:        "suffix - array",
+			requirement: models.ClaimRequirement{Claim: "groups", Operator: "suffix", Values: []string{"-admins"}},
+			claims:      map[string]any{"groups": []any{"users", "billing-admins"}},
+			want:        true,
+		},
+		{
+			name:        "gt - number",
+			requirement: models.ClaimRequirement{Claim: "level", Operator: "gt", Values: []string{"3"}},
+			claims:      map[string]any{"level": float64(4)},
+			want:        true,
+		},
+		{
+			name:        "gte - equal",
+			requirement: models.ClaimRequirement{Claim: "level", Operator: "gte", Values: []string{"4"}},
+			claims:      map[string]any{"level": 4},
+			want:        true,
+		},
+		{
+			name:        "lt - not less",
+			requirement: models.ClaimRequirement{Claim: "level", Operator: "lt", Values: []string{"4"}},
+			claims:      map[string]any{"level": 4},
+			want:        false,
+		},
+		{
+			name:        "lte - not a number",
+			requirement: models.ClaimRequirement{Claim: "level", Operator: "lte", Values: []string{"4"}},
+			claims:      map[string]any{"level": "high"},
+			want:        false,
+		},
+		{
+			name:        "gt - epoch claim against RFC3339 time",
+			requirement: models.ClaimRequirement{Claim: "iat", Operator: "gt", Values: []string{"2020-01-01T00:00:00Z"}},
+			claims:      map[string]any{"iat": float64(1590850325)},
+			want:        true,
+		},
+		{
+			name:        "lt - RFC3339 claim against epoch",
+			requirement: models.ClaimRequirement{Claim: "hired_at", Operator: "lt", Values: []string{"1577836800"}},
+			claims:      map[string]any{"hired_at": "2019-06-01T09:00:00+02:00"},
+			want:        true,
+		},
+		{
+			name: "containsAll",
+			requirement: models.ClaimRequirement{
+				Claim: "permissions", Operator: "containsAll", Values: []string{"read", "write"},
+			},
+			claims: map[string]any{"permissions": []any{"read", "write", "delete"}},
+			want:   true,
+		},
+		{
+			name: "containsAll - one missing",
+			requirement: models.ClaimRequirement{
+				Claim: "permissions", Operator: "containsAll", Values: []string{"read", "write"},
+			},
+			claims: map[string]any{"permissions": []any{"read"}},
+			want:   false,
+		},
+		{
+			name: "containsAny",
+			requirement: models.ClaimRequirement{
+				Claim: "permissions", Operator: "containsAny", Values: []string{"write", "delete"},
+			},
+			claims: map[string]any{"permissions": []any{"read", "delete"}},
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+				"Policy": {tt.requirement},
+			}, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyName: "Policy"}}, nil, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if got := failedClaim == ""; got != tt.want {
+				t.Errorf("Authorize() passed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizerImpl_Authorize_ClaimPaths(t *testing.T) {
+	claims := map[string]any{
+		"realm_access": map[string]any{
+			"roles": []any{"offline_access", "admin"},
+		},
+		"resource_access": map[string]any{
+			"billing-api": map[string]any{
+				"roles": []any{"invoices:read"},
+			},
+		},
+		"https://example.com/roles": []any{"editor"},
+		"https://example.com/app_metadata": map[string]any{
+			"plan": "enterprise",
+		},
+		"groups": []any{
+			map[string]any{"name": "staff", "roles": []any{"reader"}},
+			map[string]any{"name": "marketing", "roles": []any{"writer", "reviewer"}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		requirement models.ClaimRequirement
+		want        bool
+	}{
+		{
+			name:        "nested map",
+			requirement: models.ClaimRequirement{Claim: "realm_access.roles", Values: []string{"admin"}},
+			want:        true,
+		},
+		{
+			name:        "nested map - value missing",
+			requirement: models.ClaimRequirement{Claim: "realm_access.roles", Values: []string{"superuser"}},
+			want:        false,
+		},
+		{
+			name:        "key with dash",
+			requirement: models.ClaimRequirement{Claim: "resource_access.billing-api.roles", Values: []string{"invoices:read"}},
+			want:        true,
+		},
+		{
+			name:        "missing path",
+			requirement: models.ClaimRequirement{Claim: "resource_access.orders-api.roles"},
+			want:        false,
+		},
+		{
+			name:        "path through a scalar",
+			requirement: models.ClaimRequirement{Claim: "realm_access.roles.name"},
+			want:        false,
+		},
+		{
+			name:        "exact top level claim name with dots",
+			requirement: models.ClaimRequirement{Claim: "https://example.com/roles", Values: []string{"editor"}},
+			want:        true,
+		},
+		{
+			name: "escaped dots",
+			requirement: models.ClaimRequirement{
+				Claim: `https://example\.com/app_metadata.plan`, Values: []string{"enterprise"},
+			},
+			want: true,
+		},
+		{
+			name:        "array index",
+			requirement: models.ClaimRequirement{Claim: "groups[1].name", Values: []string{"marketing"}},
+			want:        true,
+		},
+		{
+			name:        "array index out of range",
+			requirement: models.ClaimRequirement{Claim: "groups[2].name"},
+			want:        false,
+		},
+		{
+			name:        "array projection",
+			requirement: models.ClaimRequirement{Claim: "groups[*].name", Values: []string{"marketing"}},
+			want:        true,
+		},
+		{
+			name: "array projection flattens arrays",
+			requirement: models.ClaimRequirement{
+				Claim: "groups[*].roles", Operator: "containsAll", Values: []string{"reader", "reviewer"},
+			},
+			want: true,
+		},
+		{
+			name:        "array projection without matches",
+			requirement: models.ClaimRequirement{Claim: "groups[*].department"},
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+				"Policy": {tt.requirement},
+			}, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyName: "Policy"}}, nil, claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if got := failedClaim == ""; got != tt.want {
+				t.Errorf("Authorize() passed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizerImpl_Authorize_Composition(t *testing.T) {
+	claimPolicies := map[string][]models.ClaimRequirement{
+		"Admin": {
+			{Claim: "role", Values: []string{"admin"}},
+		},
+		"MarketingEditor": {
+			{
+				AllOf: []models.ClaimRequirement{
+					{Claim: "role", Values: []string{"editor"}},
+					{Claim: "department", Values: []string{"marketing"}},
+				},
+			},
+		},
+		"CanPublish": {
+			{
+				AnyOf: []models.ClaimRequirement{
+					{Policy: "Admin"},
+					{Policy: "MarketingEditor"},
+				},
+			},
+			{
+				Not: &models.ClaimRequirement{Claim: "suspended", Values: []string{"true"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		claims          map[string]any
+		wantFailedClaim string
+	}{
+		{
+			name:            "first branch of anyOf",
+			claims:          map[string]any{"role": "admin"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "second branch of anyOf",
+			claims:          map[string]any{"role": "editor", "department": "marketing"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "no branch of anyOf",
+			claims:          map[string]any{"role": "editor", "department": "sales"},
+			wantFailedClaim: "anyOf(Admin > role | MarketingEditor > allOf[1] > department)",
+		},
+		{
+			name:            "not",
+			claims:          map[string]any{"role": "admin", "suspended": true},
+			wantFailedClaim: "not(suspended)",
+		},
+		{
+			name:            "not with missing claim",
+			claims:          map[string]any{"role": "admin"},
+			wantFailedClaim: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(claimPolicies, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyName: "CanPublish"}}, nil, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if failedClaim != tt.wantFailedClaim {
+				t.Errorf("Authorize() failedClaim = %v, want %v", failedClaim, tt.wantFailedClaim)
+			}
+		})
+	}
+}
+
+func TestAuthorizerImpl_Authorize_ReferenceCycle(t *testing.T) {
+	a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+		"A": {{Policy: "B"}},
+		"B": {{Policy: "A"}},
+	}, models.RolesConfig{}, nil)
+	if err != nil {
+		t.Fatalf("NewAuthorizer() error = %v", err)
+	}
+
+	decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyName: "A"}}, nil, map[string]any{})
+	failedClaim := decision.FailedRequirement()
+	if err != nil {
+		t.Errorf("Authorize() error = %v", err)
+		return
+	}
+
+	if failedClaim == "" {
+		t.Errorf("Authorize() passed a policy reference cycle")
+	}
+}
+
+func TestAuthorizerImpl_Authorize_RoutePolicies(t *testing.T) {
+	claimPolicies := map[string][]models.ClaimRequirement{
+		"Employee": {
+			{Claim: "employee_id"},
+		},
+		"Admin": {
+			{Claim: "role", Values: []string{"admin"}},
+		},
+		"Auditor": {
+			{Claim: "role", Values: []string{"auditor"}},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		matchedPolicies []models.RoutePolicy
+		claims          map[string]any
+		wantFailedClaim string
+	}{
+		{
+			name: "require all",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/reports", PolicyNames: []string{"Employee", "Admin"}},
+			},
+			claims:          map[string]any{"role": "admin"},
+			wantFailedClaim: "employee_id",
+		},
+		{
+			name: "require any",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/reports", PolicyNames: []string{"Admin", "Auditor"}, PolicyMode: "requireAny"},
+			},
+			claims:          map[string]any{"role": "auditor"},
+			wantFailedClaim: "",
+		},
+		{
+			name: "require any - none passes",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/reports", PolicyNames: []string{"Admin", "Auditor"}, PolicyMode: "requireAny"},
+			},
+			claims:          map[string]any{"role": "guest"},
+			wantFailedClaim: "role",
+		},
+		{
+			name: "less specific routes inherited",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/reports/public", PolicyName: "Employee"},
+				{Path: "/reports/**", PolicyName: "Admin"},
+			},
+			claims:          map[string]any{"employee_id": "42"},
+			wantFailedClaim: "role",
+		},
+		{
+			name: "less specific routes overridden",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/reports/public", PolicyName: "Employee", Override: true},
+				{Path: "/reports/**", PolicyName: "Admin"},
+			},
+			claims:          map[string]any{"employee_id": "42"},
+			wantFailedClaim: "",
+		},
+		{
+			name: "routes of the same specificity not overridden",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/reports/public", PolicyName: "Employee", Override: true},
+				{Path: "/reports/public", PolicyName: "Admin"},
+				{Path: "/reports/**", PolicyName: "Auditor"},
+			},
+			claims:          map[string]any{"employee_id": "42"},
+			wantFailedClaim: "role",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(claimPolicies, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), tt.matchedPolicies, nil, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if failedClaim != tt.wantFailedClaim {
+				t.Errorf("Authorize() failedClaim = %v, want %v", failedClaim, tt.wantFailedClaim)
+			}
+		})
+	}
+}
+
+func TestAuthorizerImpl_Authorize_ValueFrom(t *testing.T) {
+	tests := []struct {
+		name        string
+		requirement models.ClaimRequirement
+		pathParams  map[string]string
+		claims      map[string]any
+		want        bool
+	}{
+		{
+			name:        "claim equals path parameter",
+			requirement: models.ClaimRequirement{Claim: "sub", ValueFrom: "path.id"},
+			pathParams:  map[string]string{"id": "42"},
+			claims:      map[string]any{"sub": "42"},
+			want:        true,
+		},
+		{
+			name:        "claim differs from path parameter",
+			requirement: models.ClaimRequirement{Claim: "sub", ValueFrom: "path.id"},
+			pathParams:  map[string]string{"id": "43"},
+			claims:      map[string]any{"sub": "42"},
+			want:        false,
+		},
+		{
+			name:        "numeric claim",
+			requirement: models.ClaimRequirement{Claim: "tenant_id", ValueFrom: "path.tenant"},
+			pathParams:  map[string]string{"tenant": "7"},
+			claims:      map[string]any{"tenant_id": 7.0},
+			want:        true,
+		},
+		{
+			name:        "array claim contains path parameter",
+			requirement: models.ClaimRequirement{Claim: "tenants", ValueFrom: "path.tenant"},
+			pathParams:  map[string]string{"tenant": "acme"},
+			claims:      map[string]any{"tenants": []any{"globex", "acme"}},
+			want:        true,
+		},
+		{
+			name: "case insensitive",
+			requirement: models.ClaimRequirement{
+				Claim: "tenant", ValueFrom: "path.tenant", Operator: "equals", CaseInsensitive: true,
+			},
+			pathParams: map[string]string{"tenant": "ACME"},
+			claims:     map[string]any{"tenant": "acme"},
+			want:       true,
+		},
+		{
+			name:        "path parameter not captured",
+			requirement: models.ClaimRequirement{Claim: "sub", ValueFrom: "path.id"},
+			pathParams:  map[string]string{},
+			claims:      map[string]any{"sub": "42"},
+			want:        false,
+		},
+		{
+			name:        "claim equals another claim",
+			requirement: models.ClaimRequirement{Claim: "owner", ValueFrom: "claim.sub"},
+			claims:      map[string]any{"sub": "42", "owner": "42"},
+			want:        true,
+		},
+		{
+			name:        "value from missing claim",
+			requirement: models.ClaimRequirement{Claim: "owner", ValueFrom: "claim.sub"},
+			claims:      map[string]any{"owner": "42"},
+			want:        false,
+		},
+		{
+			name:        "negated operator with path parameter not captured",
+			requirement: models.ClaimRequirement{Claim: "sub", ValueFrom: "path.id", Operator: "notEquals"},
+			pathParams:  nil,
+			claims:      map[string]any{"sub": "42"},
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+				"Policy": {tt.requirement},
+			}, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyName: "Policy", PathParams: tt.pathParams}},
+				&models.RequestContext{}, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if got := failedClaim == ""; got != tt.want {
+				t.Errorf("Authorize() passed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizerImpl_Authorize_ValueFrom_OverlappingRoutes(t *testing.T) {
+	claimPolicies := map[string][]models.ClaimRequirement{
+		"SameUser":   {{Claim: "sub", ValueFrom: "path.id"}},
+		"SameTenant": {{Claim: "tenant", ValueFrom: "path.id"}},
+		"Tenant":     {{Claim: "tenant", ValueFrom: "path.tid"}},
+	}
+
+	routePolicies := []models.RoutePolicy{
+		{Path: "/tenants/{tid}/users/{id}", PolicyNames: []string{"SameUser", "Tenant"}},
+		{Path: "/tenants/{id}/**", PolicyName: "SameTenant"},
+		{Path: "/shared/{id}/users/*", PolicyName: "SameUser"},
+		{Path: "/shared/*/users/{id}", PolicyName: "SameUser"},
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		claims map[string]any
+		want   bool
+	}{
+		{
+			name:   "own user of own tenant",
+			path:   "/tenants/acme/users/42",
+			claims: map[string]any{"sub": "42", "tenant": "acme"},
+			want:   true,
+		},
+		{
+			name:   "user id does not satisfy the tenant check of the broader route",
+			path:   "/tenants/victim/users/mine",
+			claims: map[string]any{"sub": "mine", "tenant": "mine"},
+			want:   false,
+		},
+		{
+			name:   "own user of another tenant",
+			path:   "/tenants/victim/users/mine",
+			claims: map[string]any{"sub": "mine", "tenant": "attacker"},
+			want:   false,
+		},
+		{
+			name:   "same policy checked with the captures of each route",
+			path:   "/shared/mine/users/victim",
+			claims: map[string]any{"sub": "mine"},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := services.NewRouteMatcher(routePolicies, models.PathConfig{})
+			a, err := services.NewAuthorizer(claimPolicies, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			matched, err := rm.MatchRoutePolicies(tt.path, &models.RequestContext{Method: "GET"})
+			if err != nil {
+				t.Errorf("MatchRoutePolicies() error = %v", err)
+				return
+			}
+
+			decision, err := a.Authorize(context.Background(), matched, &models.RequestContext{Method: "GET", Path: tt.path}, tt.claims)
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if decision.Allowed != tt.want {
+				t.Errorf("Authorize() allowed = %v, want %v, decision %v", decision.Allowed, tt.want, decision)
+			}
+		})
+	}
+}
+
+func TestAuthorizerImpl_Authorize_RequestRequirements(t *testing.T) {
+	claimPolicies := map[string][]models.ClaimRequirement{
+		"InternalOrAdmin": {
+			{
+				AnyOf: []models.ClaimRequirement{
+					{CIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}},
+					{Claim: "role", Values: []string{"admin"}},
+				},
+			},
+		},
+		"TenantHeader": {
+			{Header: "X-Tenant", ValueFrom: "claim.tenant"},
+		},
+		"NoDebug": {
+			{
+				AnyOf: []models.ClaimRequirement{
+					{Not: &models.ClaimRequirement{Query: "debug", Values: []string{"true"}}},
+					{Claim: "developer"},
+				},
+			},
+		},
+		"ApiHost": {
+			{Hosts: []string{"api.example.com", "*.api.example.com"}},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		policyName      string
+		request         *models.RequestContext
+		claims          map[string]any
+		wantFailedClaim string
+	}{
+		{
+			name:            "client ip in range",
+			policyName:      "InternalOrAdmin",
+			request:         &models.RequestContext{ClientIP: net.ParseIP("10.1.2.3")},
+			claims:          map[string]any{},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "client ipv6 in range",
+			policyName:      "InternalOrAdmin",
+			request:         &models.RequestContext{ClientIP: net.ParseIP("2001:db8::1")},
+			claims:          map[string]any{},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "client ip out of range with admin role",
+			policyName:      "InternalOrAdmin",
+			request:         &models.RequestContext{ClientIP: net.ParseIP("192.168.1.1")},
+			claims:          map[string]any{"role": "admin"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "client ip out of range",
+			policyName:      "InternalOrAdmin",
+			request:         &models.RequestContext{ClientIP: net.ParseIP("192.168.1.1")},
+			claims:          map[string]any{"role": "user"},
+			wantFailedClaim: "anyOf(client ip | role)",
+		},
+		{
+			name:            "header equals claim",
+			policyName:      "TenantHeader",
+			request:         &models.RequestContext{Header: http.Header{"X-Tenant": {"acme"}}},
+			claims:          map[string]any{"tenant": "acme"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "header differs from claim",
+			policyName:      "TenantHeader",
+			request:         &models.RequestContext{Header: http.Header{"X-Tenant": {"globex"}}},
+			claims:          map[string]any{"tenant": "acme"},
+			wantFailedClaim: "header X-Tenant",
+		},
+		{
+			name:            "header missing",
+			policyName:      "TenantHeader",
+			request:         &models.RequestContext{Header: http.Header{}},
+			claims:          map[string]any{"tenant": "acme"},
+			wantFailedClaim: "header X-Tenant",
+		},
+		{
+			name:            "no debug query",
+			policyName:      "NoDebug",
+			request:         &models.RequestContext{Query: url.Values{}},
+			claims:          map[string]any{},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "debug query without developer claim",
+			policyName:      "NoDebug",
+			request:         &models.RequestContext{Query: url.Values{"debug": {"true"}}},
+			claims:          map[string]any{},
+			wantFailedClaim: "anyOf(not(query debug) | developer)",
+		},
+		{
+			name:            "debug query with developer claim",
+			policyName:      "NoDebug",
+			request:         &models.RequestContext{Query: url.Values{"debug": {"true"}}},
+			claims:          map[string]any{"developer": true},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "host with port",
+			policyName:      "ApiHost",
+			request:         &models.RequestContext{Host: "API.example.com:8080"},
+			claims:          map[string]any{},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "subdomain host",
+			policyName:      "ApiHost",
+			request:         &models.RequestContext{Host: "eu.api.example.com"},
+			claims:          map[string]any{},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "other host",
+			policyName:      "ApiHost",
+			request:         &models.RequestContext{Host: "www.example.com"},
+			claims:          map[string]any{},
+			wantFailedClaim: "host",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(claimPolicies, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyName: tt.policyName}}, tt.request, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if failedClaim != tt.wantFailedClaim {
+				t.Errorf("Authorize() failedClaim = %v, want %v", failedClaim, tt.wantFailedClaim)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizer(t *testing.T) {
+	tests := []struct {
+		name          string
+		claimPolicies map[string][]models.ClaimRequirement
+		wantErr       bool
+	}{
+		{
+			name: "expressions",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {
+					{Expression: "claims.exp - claims.iat < 3600.0 && request.method in ['GET', 'HEAD']"},
+					{Not: &models.ClaimRequirement{Expression: "claims.sub == route.params.id"}},
+				},
+				"Other": {{AnyOf: []models.ClaimRequirement{{Expression: "claims.sub == route.params.id"}}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "expression syntax error",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{Expression: "claims.role =="}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expression with undeclared variable",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{Expression: "token.role == 'admin'"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "non-bool expression",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{Expression: "request.method + '!'"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expression with misspelled request field",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{Not: &models.ClaimRequirement{Expression: "request.methd == 'DELETE'"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "nested invalid expression",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{Not: &models.ClaimRequirement{Expression: "claims.role =="}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid regex",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{Claim: "email", Operator: "regex", Values: []string{"[a-z"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid claim path",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{Claim: "realm_access..roles", Operator: "notIn", Values: []string{"banned"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid claim path in valueFrom",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{Claim: "sub", Operator: "notEquals", ValueFrom: "claim.groups[x]"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid cidr",
+			claimPolicies: map[string][]models.ClaimRequirement{
+				"Test": {{CIDRs: []string{"10.0.0.0/33"}}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := services.NewAuthorizer(tt.claimPolicies, models.RolesConfig{}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAuthorizer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizerImpl_Authorize_Expressions(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		request    *models.RequestContext
+		pathParams map[string]string
+		claims     map[string]any
+		want       bool
+	}{
+		{
+			name:       "short lived token reading",
+			expression: "claims.exp - claims.iat < 3600 && request.method in ['GET', 'HEAD']",
+			request:    &models.RequestContext{Method: "GET"},
+			claims:     map[string]any{"iat": float64(1600000000), "exp": float64(1600001800)},
+			want:       true,
+		},
+		{
+			name:       "long lived token reading",
+			expression: "claims.exp - claims.iat < 3600 && request.method in ['GET', 'HEAD']",
+			request:    &models.RequestContext{Method: "GET"},
+			claims:     map[string]any{"iat": float64(1600000000), "exp": float64(1600086400)},
+			want:       false,
+		},
+		{
+			name:       "claim equals path parameter",
+			expression: "claims.sub == route.params.id",
+			request:    &models.RequestContext{},
+			pathParams: map[string]string{"id": "42"},
+			claims:     map[string]any{"sub": "42"},
+			want:       true,
+		},
+		{
+			name:       "lowercase header",
+			expression: "request.headers['x-tenant'] in claims.tenants",
+			request:    &models.RequestContext{Header: http.Header{"X-Tenant": {"acme"}}},
+			claims:     map[string]any{"tenants": []any{"acme", "globex"}},
+			want:       true,
+		},
+		{
+			name:       "query parameter",
+			expression: "!('debug' in request.query) || request.query.debug == 'false'",
+			request:    &models.RequestContext{Query: url.Values{"debug": {"true"}}},
+			claims:     map[string]any{},
+			want:       false,
+		},
+		{
+			name:       "client ip and host",
+			expression: "request.ip.startsWith('10.') && request.host == 'api.example.com'",
+			request:    &models.RequestContext{ClientIP: net.ParseIP("10.1.2.3"), Host: "api.example.com"},
+			claims:     map[string]any{},
+			want:       true,
+		},
+		{
+			name:       "missing claim fails",
+			expression: "claims.role == 'admin'",
+			request:    &models.RequestContext{},
+			claims:     map[string]any{},
+			want:       false,
+		},
+		{
+			name:       "nil claims",
+			expression: "!has(claims.sub)",
+			request:    nil,
+			claims:     nil,
+			want:       true,
+		},
+		{
+			name:       "non-bool result fails",
+			expression: "claims.role",
+			request:    &models.RequestContext{},
+			claims:     map[string]any{"role": "admin"},
+			want:       false,
+		},
+		{
+			name:       "cost limit exceeded",
+			expression: "claims.items.all(a, claims.items.all(b, claims.items.all(c, a + b + c >= 0.0)))",
+			request:    &models.RequestContext{},
+			claims:     map[string]any{"items": expressionItems(100)},
+			want:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+				"Policy": {{Expression: tt.expression}},
+			}, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyName: "Policy", PathParams: tt.pathParams}},
+				tt.request, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if got := failedClaim == ""; got != tt.want {
+				t.Errorf("Authorize() passed = %v, want %v", got, tt.want)
+			}
+
+			if !tt.want && failedClaim != "expression "+tt.expression {
+				t.Errorf("Authorize() failedClaim = %v, want expression %v", failedClaim, tt.expression)
+			}
+		})
+	}
+}
+
+func expressionItems(n int) []any {
+	items := make([]any, n)
+	for i := range items {
+		items[i] = float64(i)
+	}
+
+	return items
+}
+
+func TestAuthorizerImpl_CheckDenyPolicies(t *testing.T) {
+	claimPolicies := map[string][]models.ClaimRequirement{
+		"Locked": {
+			{Claim: "account_status", Values: []string{"locked"}},
+		},
+		"Admin": {
+			{Claim: "role", Values: []string{"admin"}},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		matchedPolicies []models.RoutePolicy
+		claims          map[string]any
+		wantDeniedBy    string
+	}{
+		{
+			name: "no deny policy",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/admin/**", PolicyName: "Admin"},
+			},
+			claims:       map[string]any{"role": "admin"},
+			wantDeniedBy: "",
+		},
+		{
+			name: "unconditional deny",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/admin/**", Deny: true},
 			},
-			wantFailedClaim: "",
-			wantErr:         false,
+			claims:       map[string]any{"role": "admin"},
+			wantDeniedBy: "/admin/**",
+		},
+		{
+			name: "unconditional deny - anonymous",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/admin/**", Deny: true},
+			},
+			claims:       nil,
+			wantDeniedBy: "/admin/**",
+		},
+		{
+			name: "conditional deny - condition met",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/**", Deny: true, PolicyName: "Locked"},
+			},
+			claims:       map[string]any{"account_status": "locked"},
+			wantDeniedBy: "/**",
+		},
+		{
+			name: "conditional deny - condition not met",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/**", Deny: true, PolicyName: "Locked"},
+			},
+			claims:       map[string]any{"account_status": "active"},
+			wantDeniedBy: "",
+		},
+		{
+			name: "conditional deny - anonymous",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/**", Deny: true, PolicyName: "Locked"},
+			},
+			claims:       nil,
+			wantDeniedBy: "",
+		},
+		{
+			name: "less specific deny wins over more specific allow",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/admin/users", PolicyName: "Admin", Override: true},
+				{Path: "/**", Deny: true, PolicyName: "Locked"},
+			},
+			claims:       map[string]any{"role": "admin", "account_status": "locked"},
+			wantDeniedBy: "/**",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			a := services.NewAuthorizer(tt.claimPolicies)
+			a, err := services.NewAuthorizer(claimPolicies, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			deniedBy, err := a.CheckDenyPolicies(tt.matchedPolicies, &models.RequestContext{}, tt.claims)
+			if err != nil {
+				t.Errorf("CheckDenyPolicies() error = %v", err)
+				return
+			}
 
-			gotFailedPolicy, err := a.Authorize(tt.args.policyNames, tt.args.claims)
+			if deniedBy != tt.wantDeniedBy {
+				t.Errorf("CheckDenyPolicies() = %v, want %v", deniedBy, tt.wantDeniedBy)
+			}
+		})
+	}
+}
 
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+func TestAuthorizerImpl_Authorize_IgnoresDenyPolicies(t *testing.T) {
+	a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+		"Locked": {{Claim: "account_status", Values: []string{"locked"}}},
+	}, models.RolesConfig{}, nil)
+	if err != nil {
+		t.Fatalf("NewAuthorizer() error = %v", err)
+	}
+
+	decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{Path: "/**", Deny: true, PolicyName: "Locked"}},
+		&models.RequestContext{}, map[string]any{"account_status": "active"})
+	failedClaim := decision.FailedRequirement()
+	if err != nil {
+		t.Errorf("Authorize() error = %v", err)
+		return
+	}
+
+	if failedClaim != "" {
+		t.Errorf("Authorize() checked the condition of a deny policy, failed claim: %v", failedClaim)
+	}
+}
+
+func TestAuthorizerImpl_Authorize_Decision(t *testing.T) {
+	a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+		"Admin": {
+			{Claim: "role", Values: []string{"admin"}},
+			{Claim: "profile.ssn", Values: []string{"000-00-0000"}},
+		},
+		"Partner": {
+			{Header: "Authorization", Values: []string{"Bearer partner"}},
+			{Claim: "scope", Operator: "in", Values: []string{"read", "write"}},
+		},
+	}, models.RolesConfig{}, []string{"profile"})
+	if err != nil {
+		t.Fatalf("NewAuthorizer() error = %v", err)
+	}
+
+	matchedPolicies := []models.RoutePolicy{
+		{Path: "/admin/users", PolicyName: "Partner", Override: true},
+		{Path: "/admin/**", PolicyName: "Admin"},
+	}
+
+	request := &models.RequestContext{Header: http.Header{"Authorization": {"Bearer token"}}}
+	claims := map[string]any{
+		"role":    "user",
+		"scope":   []any{"read"},
+		"profile": map[string]any{"ssn": "123-45-6789"},
+	}
+
+	decision, err := a.Authorize(context.Background(), matchedPolicies[:1], request, claims)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	decision.Duration = 0
+
+	want := models.Decision{
+		Allowed:     false,
+		RoutePolicy: "/admin/users",
+		Requirements: []models.RequirementResult{
+			{
+				RoutePolicy: "/admin/users",
+				Policy:      "Partner",
+				Requirement: "header Authorization",
+				Actual:      []string{models.Redacted},
+				Expected:    []string{"Bearer partner"},
+			},
+			{
+				RoutePolicy: "/admin/users",
+				Policy:      "Partner",
+				Requirement: "scope",
+				Passed:      true,
+				Operator:    "in",
+				Actual:      []string{"read"},
+				Expected:    []string{"read", "write"},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(decision, want) {
+		t.Errorf("Authorize() decision = %+v, want %+v", decision, want)
+	}
+
+	decision, err = a.Authorize(context.Background(), matchedPolicies[1:], request, claims)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	failed := decision.Failed()
+	if len(failed) != 2 {
+		t.Fatalf("Authorize() failed requirements = %+v, want 2", failed)
+	}
+
+	if !reflect.DeepEqual(failed[1].Actual, []string{models.Redacted}) {
+		t.Errorf("Authorize() actual value of a redacted claim = %v", failed[1].Actual)
+	}
+
+	if got := decision.String(); got != "deny route=/admin/** failed=[Admin: role, Admin: profile.ssn]" {
+		t.Errorf("Decision.String() = %v", got)
+	}
+}
+
+func TestAuthorizerImpl_Authorize_Decision_RequireAny(t *testing.T) {
+	a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+		"Admin":   {{Claim: "role", Values: []string{"admin"}}},
+		"Auditor": {{Claim: "department", Values: []string{"audit"}}},
+	}, models.RolesConfig{}, nil)
+	if err != nil {
+		t.Fatalf("NewAuthorizer() error = %v", err)
+	}
+
+	matchedPolicies := []models.RoutePolicy{
+		{Path: "/reports", PolicyNames: []string{"Admin", "Auditor"}, PolicyMode: "requireAny"},
+	}
+
+	decision, err := a.Authorize(context.Background(), matchedPolicies, &models.RequestContext{},
+		map[string]any{"role": "user", "department": "audit"})
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	decision.Duration = 0
+
+	want := models.Decision{
+		Allowed:     true,
+		RoutePolicy: "/reports",
+		Requirements: []models.RequirementResult{
+			{
+				RoutePolicy: "/reports",
+				Policy:      "Admin",
+				Requirement: "role",
+				Actual:      []string{"user"},
+				Expected:    []string{"admin"},
+				Waived:      true,
+			},
+			{
+				RoutePolicy: "/reports",
+				Policy:      "Auditor",
+				Requirement: "department",
+				Passed:      true,
+				Actual:      []string{"audit"},
+				Expected:    []string{"audit"},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(decision, want) {
+		t.Errorf("Authorize() decision = %+v, want %+v", decision, want)
+	}
+
+	decision, err = a.Authorize(context.Background(), matchedPolicies, &models.RequestContext{}, map[string]any{"role": "user"})
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	if got := decision.String(); got != "deny route=/reports failed=[Admin: role, Auditor: department]" {
+		t.Errorf("Decision.String() = %v", got)
+	}
+}
+
+func TestAuthorizerImpl_Authorize_Roles(t *testing.T) {
+	roles := models.RolesConfig{
+		Definitions: map[string]models.RoleDefinition{
+			"admin":   {Inherits: []string{"support"}, Permissions: []string{"users:delete"}},
+			"support": {Inherits: []string{"viewer"}, Permissions: []string{"tickets:write"}},
+			"viewer":  {Permissions: []string{"tickets:read"}},
+		},
+	}
+
+	nestedRoles := models.RolesConfig{
+		Claim:            "realm_access.roles",
+		PermissionsClaim: "perms",
+		Definitions:      roles.Definitions,
+	}
+
+	tests := []struct {
+		name        string
+		roles       models.RolesConfig
+		requirement models.ClaimRequirement
+		claims      map[string]any
+		want        bool
+	}{
+		{
+			name:        "own permission",
+			roles:       roles,
+			requirement: models.ClaimRequirement{Claim: "permissions", Values: []string{"tickets:read"}},
+			claims:      map[string]any{"roles": []any{"viewer"}},
+			want:        true,
+		},
+		{
+			name:        "inherited permission",
+			roles:       roles,
+			requirement: models.ClaimRequirement{Claim: "permissions", Values: []string{"tickets:read"}},
+			claims:      map[string]any{"roles": []any{"admin"}},
+			want:        true,
+		},
+		{
+			name:        "permission of a more privileged role",
+			roles:       roles,
+			requirement: models.ClaimRequirement{Claim: "permissions", Values: []string{"users:delete"}},
+			claims:      map[string]any{"roles": []any{"support"}},
+			want:        false,
+		},
+		{
+			name:  "all permissions of multiple roles",
+			roles: roles,
+			requirement: models.ClaimRequirement{
+				Claim: "permissions", Operator: "containsAll", Values: []string{"tickets:write", "reports:export"},
+			},
+			claims: map[string]any{"roles": "support", "permissions": []any{"reports:export"}},
+			want:   true,
+		},
+		{
+			name:        "unknown role",
+			roles:       roles,
+			requirement: models.ClaimRequirement{Claim: "permissions"},
+			claims:      map[string]any{"roles": []any{"guest"}},
+			want:        true,
+		},
+		{
+			name:        "no roles claim",
+			roles:       roles,
+			requirement: models.ClaimRequirement{Claim: "permissions"},
+			claims:      map[string]any{},
+			want:        false,
+		},
+		{
+			name:        "nested roles claim",
+			roles:       nestedRoles,
+			requirement: models.ClaimRequirement{Claim: "perms", Values: []string{"tickets:write"}},
+			claims:      map[string]any{"realm_access": map[string]any{"roles": []any{"admin"}}},
+			want:        true,
+		},
+		{
+			name:        "roles not configured",
+			roles:       models.RolesConfig{},
+			requirement: models.ClaimRequirement{Claim: "permissions", Values: []string{"tickets:read"}},
+			claims:      map[string]any{"roles": []any{"viewer"}},
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthorizer(map[string][]models.ClaimRequirement{
+				"Policy": {tt.requirement},
+			}, tt.roles, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), []models.RoutePolicy{{PolicyName: "Policy"}}, nil, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
 				return
 			}
 
-			if gotFailedPolicy != tt.wantFailedClaim {
-				t.Errorf("Authorize() = %v, want %v", gotFailedPolicy, tt.wantFailedClaim)
+			if got := failedClaim == ""; got != tt.want {
+				t.Errorf("Authorize() passed = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -336,6 +1751,21 @@ func TestAuthorizerImpl_IsAnonymousAllowed(t *testing.T) {
 			method: "GET",
 			want:   true,
 		},
+		{
+			name: "more specific deny policy does not decide",
+			matchedPolicies: []models.RoutePolicy{
+				{
+					Path: "/test",
+					Deny: true,
+				},
+				{
+					Path:           "/**",
+					AllowAnonymous: true,
+				},
+			},
+			method: "GET",
+			want:   true,
+		},
 		{
 			name: "multiple matching policy - different specifity",
 			matchedPolicies: []models.RoutePolicy{
@@ -385,6 +1815,22 @@ func TestAuthorizerImpl_IsAnonymousAllowed(t *testing.T) {
 			method: "GET",
 			want:   true,
 		},
+		{
+			name: "multiple matching policy - same path - host specific route decides",
+			matchedPolicies: []models.RoutePolicy{
+				{
+					Path:           "/test",
+					Hosts:          []string{"public.example.com"},
+					AllowAnonymous: true,
+				},
+				{
+					Path:           "/test",
+					AllowAnonymous: false,
+				},
+			},
+			method: "GET",
+			want:   true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -395,3 +1841,165 @@ func TestAuthorizerImpl_IsAnonymousAllowed(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthorizerImpl_CheckTokenRequirements(t *testing.T) {
+	tests := []struct {
+		name            string
+		matchedPolicies []models.RoutePolicy
+		issuer          string
+		claims          map[string]any
+		wantFailed      string
+	}{
+		{
+			name:            "no matching policies",
+			matchedPolicies: []models.RoutePolicy{},
+			issuer:          "https://internal",
+			claims:          map[string]any{},
+			wantFailed:      "",
+		},
+		{
+			name: "policy without requirements",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/test"},
+			},
+			issuer:     "https://internal",
+			claims:     map[string]any{},
+			wantFailed: "",
+		},
+		{
+			name: "issuer listed",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/test", Issuers: []string{"https://customers", "https://internal"}},
+			},
+			issuer:     "https://internal",
+			claims:     map[string]any{},
+			wantFailed: "",
+		},
+		{
+			name: "issuer not listed",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/test", Issuers: []string{"https://customers"}},
+			},
+			issuer:     "https://internal",
+			claims:     map[string]any{},
+			wantFailed: `issuer "https://internal"`,
+		},
+		{
+			name: "unnamed issuer",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/test", Issuers: []string{"https://customers"}},
+			},
+			issuer:     "",
+			claims:     map[string]any{},
+			wantFailed: `issuer ""`,
+		},
+		{
+			name: "all matching policies must allow the issuer",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/test", Issuers: []string{"https://internal"}},
+				{Path: "/**", Issuers: []string{"https://customers"}},
+			},
+			issuer:     "https://internal",
+			claims:     map[string]any{},
+			wantFailed: `issuer "https://internal"`,
+		},
+		{
+			name: "audience array matches",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Audiences: []string{"billing-api"}},
+			},
+			claims:     map[string]any{"aud": []any{"orders-api", "billing-api"}},
+			wantFailed: "",
+		},
+		{
+			name: "audience string matches",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Audiences: []string{"billing-api", "billing"}},
+			},
+			claims:     map[string]any{"aud": "billing"},
+			wantFailed: "",
+		},
+		{
+			name: "token of another api",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Audiences: []string{"billing-api"}},
+			},
+			claims:     map[string]any{"aud": []any{"orders-api"}},
+			wantFailed: "audience [billing-api]",
+		},
+		{
+			name: "audience missing",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Audiences: []string{"billing-api"}},
+			},
+			claims:     map[string]any{},
+			wantFailed: "audience [billing-api]",
+		},
+		{
+			name: "space delimited scopes granted",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Scopes: []string{"billing:read", "billing:write"}},
+			},
+			claims:     map[string]any{"scope": "openid billing:read  billing:write"},
+			wantFailed: "",
+		},
+		{
+			name: "scope is not a substring match",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Scopes: []string{"billing"}},
+			},
+			claims:     map[string]any{"scope": "billing:read"},
+			wantFailed: `scope "billing"`,
+		},
+		{
+			name: "one scope missing",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Scopes: []string{"billing:read", "billing:write"}},
+			},
+			claims:     map[string]any{"scope": "billing:read"},
+			wantFailed: `scope "billing:write"`,
+		},
+		{
+			name: "scp array",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Scopes: []string{"billing:read"}},
+			},
+			claims:     map[string]any{"scp": []any{"billing:read"}},
+			wantFailed: "",
+		},
+		{
+			name: "scp string",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/**", Scopes: []string{"billing:write"}},
+			},
+			claims:     map[string]any{"scp": "billing:read billing:write"},
+			wantFailed: "",
+		},
+		{
+			name: "scopes of all matching policies required",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/invoices", Scopes: []string{"invoices"}},
+				{Path: "/billing/**", Scopes: []string{"billing"}},
+			},
+			claims:     map[string]any{"scope": "invoices"},
+			wantFailed: `scope "billing"`,
+		},
+		{
+			name: "overridden scopes not required",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/billing/invoices", Scopes: []string{"invoices"}, Override: true},
+				{Path: "/billing/**", Scopes: []string{"billing"}},
+			},
+			claims:     map[string]any{"scope": "invoices"},
+			wantFailed: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := services.AuthorizerImpl{}
+			if got := a.CheckTokenRequirements(tt.matchedPolicies, tt.issuer, tt.claims); got != tt.wantFailed {
+				t.Errorf("CheckTokenRequirements() = %v, want %v", got, tt.wantFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// claimPath is a parsed path into nested claims, e.g. resource_access.my-client.roles or groups[*].name
+type claimPath []pathSegment
+
+// pathSegment is either a map key, an array index or an array projection
+type pathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseClaimPath parses a dot separated claim path.
+//
+// Dots and brackets that are part of a key are escaped with a backslash, e.g. https://example\.com/roles.
+// Arrays are indexed with [n], and projected with [*] to look up the rest of the path in every element.
+func parseClaimPath(path string) (claimPath, error) {
+	var segments claimPath
+	var key strings.Builder
+
+	// afterIndex is set right after an index, where a key is not expected before the next dot or index
+	afterIndex := false
+
+	endKey := func() error {
+		if key.Len() == 0 {
+			if afterIndex {
+				return nil
+			}
+			return fmt.Errorf("empty key in claim path: %s", path)
+		}
+
+		segments = append(segments, pathSegment{key: key.String()})
+		key.Reset()
+		return nil
+	}
+
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 == len(path) {
+				return nil, fmt.Errorf("dangling escape character in claim path: %s", path)
+			}
+
+			i++
+			key.WriteByte(path[i])
+		case '.':
+			err := endKey()
+			if err != nil {
+				return nil, err
+			}
+
+			afterIndex = false
+		case '[':
+			err := endKey()
+			if err != nil {
+				return nil, err
+			}
+
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in claim path: %s", path)
+			}
+
+			segment, err := parseIndex(path[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("%w in claim path: %s", err, path)
+			}
+
+			segments = append(segments, segment)
+			i += end
+			afterIndex = true
+		case ']':
+			return nil, fmt.Errorf("unexpected ] in claim path: %s", path)
+		default:
+			if afterIndex {
+				return nil, fmt.Errorf("unexpected character after index in claim path: %s", path)
+			}
+
+			key.WriteByte(c)
+		}
+	}
+
+	err := endKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+func parseIndex(index string) (pathSegment, error) {
+	if index == "*" {
+		return pathSegment{wildcard: true}, nil
+	}
+
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 0 {
+		return pathSegment{}, fmt.Errorf("invalid index [%s]", index)
+	}
+
+	return pathSegment{index: n, isIndex: true}, nil
+}
+
+// lookup evaluates the path against nested maps and arrays.
+// Projections collect the values found in all elements into an array, flattening arrays found in the elements.
+func (p claimPath) lookup(value any) (any, bool) {
+	if len(p) == 0 {
+		return value, true
+	}
+
+	segment, rest := p[0], p[1:]
+
+	switch {
+	case segment.wildcard:
+		arr, ok := value.([]any)
+		if !ok {
+			return nil, false
+		}
+
+		var projected []any
+		for _, element := range arr {
+			v, found := rest.lookup(element)
+			if !found {
+				continue
+			}
+
+			if inner, ok := v.([]any); ok {
+				projected = append(projected, inner...)
+			} else {
+				projected = append(projected, v)
+			}
+		}
+
+		return projected, projected != nil
+	case segment.isIndex:
+		arr, ok := value.([]any)
+		if !ok || segment.index >= len(arr) {
+			return nil, false
+		}
+
+		return rest.lookup(arr[segment.index])
+	default:
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		v, found := m[segment.key]
+		if !found {
+			return nil, false
+		}
+
+		return rest.lookup(v)
+	}
+}
+
+// compileClaimPaths parses the claim paths of all requirements and valueFrom claims, keyed by claim,
+// and returns the parse error of the first claim that is not a valid path
+func compileClaimPaths(claimPolicies map[string][]models.ClaimRequirement) (map[string]claimPath, error) {
+	paths := make(map[string]claimPath)
+
+	err := walkPolicyRequirements(claimPolicies, func(cp models.ClaimRequirement) error {
+		names := []string{cp.Claim}
+		if name := strings.TrimPrefix(cp.ValueFrom, valueFromClaimPrefix); name != cp.ValueFrom {
+			names = append(names, name)
+		}
+
+		for _, name := range names {
+			if name == "" {
+				continue
+			}
+
+			path, err := parseClaimPath(name)
+			if err != nil {
+				return err
+			}
+
+			paths[name] = path
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// lookupClaim finds a claim by name, or by path if no top level claim has the exact name
+func (a AuthorizerImpl) lookupClaim(claims map[string]any, name string) (any, bool) {
+	if claim, exists := claims[name]; exists {
+		return claim, true
+	}
+
+	path, ok := a.claimPaths[name]
+	if !ok {
+		return nil, false
+	}
+
+	return path.lookup(claims)
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// maxPolicyDepth bounds the nesting of policy references, so that unvalidated reference cycles cannot loop forever
+const maxPolicyDepth = 32
+
+// walkRequirements calls fn for every requirement, including nested ones
+func walkRequirements(requirements []models.ClaimRequirement, fn func(models.ClaimRequirement)) {
+	for _, cp := range requirements {
+		fn(cp)
+
+		walkRequirements(cp.AllOf, fn)
+		walkRequirements(cp.AnyOf, fn)
+
+		if cp.Not != nil {
+			walkRequirements([]models.ClaimRequirement{*cp.Not}, fn)
+		}
+	}
+}
+
+// walkPolicyRequirements calls fn for every requirement of the claim policies, including nested ones,
+// in the order of policy names. The first error returned by fn stops the walk and is returned.
+func walkPolicyRequirements(
+	claimPolicies map[string][]models.ClaimRequirement,
+	fn func(models.ClaimRequirement) error) error {
+
+	names := make([]string, 0, len(claimPolicies))
+	for name := range claimPolicies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var err error
+	for _, name := range names {
+		walkRequirements(claimPolicies[name], func(cp models.ClaimRequirement) {
+			if err == nil {
+				err = fn(cp)
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateRequirementNode checks that a requirement is exactly one of a claim, header, query, hosts, cidrs,
+// expression, allOf, anyOf, not or policy reference, then validates the nested requirements.
+func validateRequirementNode(cp models.ClaimRequirement, claimPolicies models.ClaimPolicyConfig) error {
+	kinds := 0
+	for _, set := range []bool{
+		cp.Claim != "", cp.Header != "", cp.Query != "", cp.Hosts != nil, cp.CIDRs != nil, cp.Expression != "",
+		cp.AllOf != nil, cp.AnyOf != nil, cp.Not != nil, cp.Policy != "",
+	} {
+		if set {
+			kinds++
+		}
+	}
+
+	if kinds != 1 {
+		return fmt.Errorf("exactly one of claim, header, query, hosts, cidrs, expression, allOf, anyOf, not " +
+			"or policy must be configured")
+	}
+
+	compared := cp.Claim != "" || cp.Header != "" || cp.Query != ""
+	if !compared && (cp.Values != nil || cp.Operator != "" || cp.CaseInsensitive || cp.ValueFrom != "") {
+		return fmt.Errorf("values, valueFrom, operator and caseInsensitive can only be configured with a claim, " +
+			"header or query")
+	}
+
+	switch {
+	case cp.Claim != "":
+		_, err := parseClaimPath(cp.Claim)
+		if err != nil {
+			return err
+		}
+
+		err = validateClaimRequirement(cp)
+		if err != nil {
+			return fmt.Errorf("invalid requirement for claim %s: %w", cp.Claim, err)
+		}
+	case cp.Header != "" || cp.Query != "":
+		err := validateClaimRequirement(cp)
+		if err != nil {
+			return fmt.Errorf("invalid requirement for %s: %w", describeRequirement(cp), err)
+		}
+	case cp.Hosts != nil:
+		return validateHosts(cp.Hosts)
+	case cp.CIDRs != nil:
+		return validateCIDRs(cp.CIDRs)
+	case cp.Expression != "":
+		_, err := compileExpression(cp.Expression)
+		return err
+	case cp.Policy != "":
+		if _, exists := claimPolicies[cp.Policy]; !exists {
+			return fmt.Errorf("reference to non-existing policy: %s", cp.Policy)
+		}
+	case cp.Not != nil:
+		return validateRequirementNode(*cp.Not, claimPolicies)
+	default:
+		nested := cp.AllOf
+		if cp.AnyOf != nil {
+			nested = cp.AnyOf
+		}
+
+		if len(nested) == 0 {
+			return fmt.Errorf("allOf and anyOf cannot be empty")
+		}
+
+		for _, n := range nested {
+			err := validateRequirementNode(n, claimPolicies)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// findPolicyCycle returns a policy reference cycle as a list of policy names, or nil if there is none
+func findPolicyCycle(claimPolicies models.ClaimPolicyConfig) []string {
+	names := make([]string, 0, len(claimPolicies))
+	for name := range claimPolicies {
+		names = append(names, name)
+	}
+
+	return findCycle(names, func(name string) []string {
+		var references []string
+		walkRequirements(claimPolicies[name], func(cp models.ClaimRequirement) {
+			if cp.Policy != "" {
+				references = append(references, cp.Policy)
+			}
+		})
+
+		return references
+	})
+}
+
+// findCycle returns a cycle in the graph of names and their edges as a list of names, or nil if there is none
+func findCycle(names []string, edges func(name string) []string) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int)
+	var stack []string
+	var cycle []string
+
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		switch state[name] {
+		case visiting:
+			for i, n := range stack {
+				if n == name {
+					cycle = append(append([]string{}, stack[i:]...), name)
+				}
+			}
+			return true
+		case visited:
+			return false
+		}
+
+		state[name] = visiting
+		stack = append(stack, name)
+
+		for _, next := range edges(name) {
+			if visit(next) {
+				return true
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+
+		return false
+	}
+
+	for _, name := range names {
+		if visit(name) {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// evaluate checks a requirement against the claims.
+// If the requirement fails, the returned description tells which branch of the requirement failed.
+func (a AuthorizerImpl) evaluate(
+	cp models.ClaimRequirement,
+	claims map[string]any,
+	request *models.RequestContext,
+	depth int) (bool, string) {
+
+	switch {
+	case cp.Policy != "":
+		policy, exists := a.claimPolicies[cp.Policy]
+		if !exists || depth >= maxPolicyDepth {
+			return false, cp.Policy
+		}
+
+		for _, nested := range policy {
+			if ok, failed := a.evaluate(nested, claims, request, depth+1); !ok {
+				return false, cp.Policy + " > " + failed
+			}
+		}
+
+		return true, ""
+	case cp.AllOf != nil:
+		for i, nested := range cp.AllOf {
+			if ok, failed := a.evaluate(nested, claims, request, depth); !ok {
+				return false, fmt.Sprintf("allOf[%d] > %s", i, failed)
+			}
+		}
+
+		return true, ""
+	case cp.AnyOf != nil:
+		var failures []string
+		for _, nested := range cp.AnyOf {
+			ok, failed := a.evaluate(nested, claims, request, depth)
+			if ok {
+				return true, ""
+			}
+
+			failures = append(failures, failed)
+		}
+
+		return false, "anyOf(" + strings.Join(failures, " | ") + ")"
+	case cp.Not != nil:
+		if ok, _ := a.evaluate(*cp.Not, claims, request, depth); ok {
+			return false, "not(" + describeRequirement(*cp.Not) + ")"
+		}
+
+		return true, ""
+	case cp.Hosts != nil:
+		if request == nil || !hostMatches(request.Host, cp.Hosts) {
+			return false, describeRequirement(cp)
+		}
+
+		return true, ""
+	case cp.CIDRs != nil:
+		if request == nil || !a.inNetworks(request.ClientIP, cp.CIDRs) {
+			return false, describeRequirement(cp)
+		}
+
+		return true, ""
+	case cp.Expression != "":
+		if !a.expressionMet(cp.Expression, claims, request) {
+			return false, describeRequirement(cp)
+		}
+
+		return true, ""
+	}
+
+	if !a.requirementMet(cp, claims, request) {
+		return false, describeRequirement(cp)
+	}
+
+	return true, ""
+}
+
+// describeRequirement names a requirement in failure descriptions
+func describeRequirement(cp models.ClaimRequirement) string {
+	switch {
+	case cp.Policy != "":
+		return cp.Policy
+	case cp.AllOf != nil:
+		return "allOf"
+	case cp.AnyOf != nil:
+		return "anyOf"
+	case cp.Not != nil:
+		return "not(" + describeRequirement(*cp.Not) + ")"
+	case cp.Header != "":
+		return "header " + cp.Header
+	case cp.Query != "":
+		return "query " + cp.Query
+	case cp.Hosts != nil:
+		return "host"
+	case cp.CIDRs != nil:
+		return "client ip"
+	case cp.Expression != "":
+		return "expression " + cp.Expression
+	}
+
+	return cp.Claim
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// claim requirement operators, an empty operator checks existence without values and works as "in" with values
+const (
+	operatorEquals             = "equals"
+	operatorNotEquals          = "notEquals"
+	operatorIn                 = "in"
+	operatorNotIn              = "notIn"
+	operatorRegex              = "regex"
+	operatorPrefix             = "prefix"
+	operatorSuffix             = "suffix"
+	operatorGreaterThan        = "gt"
+	operatorGreaterThanOrEqual = "gte"
+	operatorLessThan           = "lt"
+	operatorLessThanOrEqual    = "lte"
+	operatorContainsAll        = "containsAll"
+	operatorContainsAny        = "containsAny"
+)
+
+// valueFrom sources, referring to path parameters and claims
+const (
+	valueFromPathPrefix  = "path."
+	valueFromClaimPrefix = "claim."
+)
+
+// validateClaimRequirement checks if the operator is known and the values fit the operator
+func validateClaimRequirement(cp models.ClaimRequirement) error {
+	if cp.ValueFrom != "" {
+		return validateValueFrom(cp)
+	}
+
+	switch cp.Operator {
+	case "":
+		return nil
+	case operatorEquals, operatorNotEquals:
+		if len(cp.Values) != 1 {
+			return fmt.Errorf("operator %s expects exactly one value", cp.Operator)
+		}
+	case operatorIn, operatorNotIn, operatorPrefix, operatorSuffix, operatorContainsAll, operatorContainsAny:
+		if len(cp.Values) == 0 {
+			return fmt.Errorf("operator %s expects at least one value", cp.Operator)
+		}
+	case operatorRegex:
+		if len(cp.Values) == 0 {
+			return fmt.Errorf("operator %s expects at least one value", cp.Operator)
+		}
+
+		for _, v := range cp.Values {
+			_, err := regexp.Compile(regexPattern(v, cp.CaseInsensitive))
+			if err != nil {
+				return fmt.Errorf("invalid regex %q: %w", v, err)
+			}
+		}
+	case operatorGreaterThan, operatorGreaterThanOrEqual, operatorLessThan, operatorLessThanOrEqual:
+		if len(cp.Values) != 1 {
+			return fmt.Errorf("operator %s expects exactly one value", cp.Operator)
+		}
+
+		if _, ok := comparableValue(cp.Values[0]); !ok {
+			return fmt.Errorf("operator %s expects a number or an RFC3339 time, found: %s", cp.Operator, cp.Values[0])
+		}
+
+		if cp.CaseInsensitive {
+			return fmt.Errorf("operator %s cannot be case insensitive", cp.Operator)
+		}
+	default:
+		return fmt.Errorf("unknown operator: %s", cp.Operator)
+	}
+
+	return nil
+}
+
+// validateValueFrom checks that the value source is a path parameter or a claim,
+// and the operator compares to a value
+func validateValueFrom(cp models.ClaimRequirement) error {
+	switch {
+	case strings.HasPrefix(cp.ValueFrom, valueFromPathPrefix):
+		name := strings.TrimPrefix(cp.ValueFrom, valueFromPathPrefix)
+		if !pathParamRegexp.MatchString("{" + name + "}") {
+			return fmt.Errorf("invalid path parameter in valueFrom: %s", cp.ValueFrom)
+		}
+	case strings.HasPrefix(cp.ValueFrom, valueFromClaimPrefix):
+		_, err := parseClaimPath(strings.TrimPrefix(cp.ValueFrom, valueFromClaimPrefix))
+		if err != nil {
+			return fmt.Errorf("invalid claim in valueFrom: %w", err)
+		}
+	default:
+		return fmt.Errorf("valueFrom must refer to a path parameter like path.id or a claim like claim.sub, found: %s",
+			cp.ValueFrom)
+	}
+
+	if cp.Values != nil {
+		return fmt.Errorf("values cannot be configured together with valueFrom")
+	}
+
+	switch cp.Operator {
+	case "", operatorEquals, operatorNotEquals, operatorIn, operatorNotIn, operatorPrefix, operatorSuffix,
+		operatorContainsAny, operatorContainsAll:
+		return nil
+	case operatorGreaterThan, operatorGreaterThanOrEqual, operatorLessThan, operatorLessThanOrEqual:
+		if cp.CaseInsensitive {
+			return fmt.Errorf("operator %s cannot be case insensitive", cp.Operator)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("operator %s cannot be used with valueFrom", cp.Operator)
+}
+
+// compileRegexps compiles the patterns of all regex requirements, keyed by pattern with the case-insensitive flag
+// applied. An invalid pattern is reported with the value it was configured as.
+func compileRegexps(claimPolicies map[string][]models.ClaimRequirement) (map[string]*regexp.Regexp, error) {
+	regexps := make(map[string]*regexp.Regexp)
+
+	err := walkPolicyRequirements(claimPolicies, func(cp models.ClaimRequirement) error {
+		if cp.Operator != operatorRegex {
+			return nil
+		}
+
+		for _, v := range cp.Values {
+			pattern := regexPattern(v, cp.CaseInsensitive)
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid regex %q: %w", v, err)
+			}
+
+			regexps[pattern] = re
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return regexps, nil
+}
+
+func regexPattern(value string, caseInsensitive bool) string {
+	if caseInsensitive {
+		return "(?i)" + value
+	}
+
+	return value
+}
+
+// requirementMet evaluates a claim, header or query parameter requirement.
+//
+// For array claims and repeated headers or query parameters, most operators pass if any of the elements passes.
+// Negated operators pass if none of the elements match, or if the claim does not exist at all.
+//
+// Requirements with valueFrom fail if the path parameter was not captured or the claim does not exist.
+func (a AuthorizerImpl) requirementMet(
+	cp models.ClaimRequirement,
+	claims map[string]any,
+	request *models.RequestContext) bool {
+
+	values := cp.Values
+	if cp.ValueFrom != "" {
+		var found bool
+		values, found = a.valueFrom(cp.ValueFrom, claims, request)
+		if !found {
+			return false
+		}
+	}
+
+	var elements []any
+	exists := false
+
+	if cp.Claim != "" {
+		var claim any
+		claim, exists = a.lookupClaim(claims, cp.Claim)
+		elements = claimElements(claim)
+	} else {
+		for _, v := range requestValues(cp, request) {
+			elements = append(elements, v)
+		}
+		exists = elements != nil
+	}
+
+	equals := func(e any, v string) bool { return valueEquals(e, v, cp.CaseInsensitive) }
+
+	switch cp.Operator {
+	case operatorNotEquals, operatorNotIn:
+		return !exists || !anyMatch(elements, values, equals)
+	}
+
+	if !exists {
+		return false
+	}
+
+	// if no value specified, requirement passes just by existing
+	if cp.Operator == "" && values == nil {
+		return true
+	}
+
+	switch cp.Operator {
+	case "", operatorEquals, operatorIn, operatorContainsAny:
+		return anyMatch(elements, values, equals)
+	case operatorContainsAll:
+		for _, v := range values {
+			if !anyMatch(elements, []string{v}, equals) {
+				return false
+			}
+		}
+		return true
+	case operatorRegex:
+		return anyMatch(elements, values, func(e any, v string) bool {
+			re := a.regexps[regexPattern(v, cp.CaseInsensitive)]
+			s, ok := claimString(e)
+			return ok && re != nil && re.MatchString(s)
+		})
+	case operatorPrefix, operatorSuffix:
+		return anyMatch(elements, values, func(e any, v string) bool {
+			s, ok := claimString(e)
+			if !ok {
+				return false
+			}
+
+			if cp.CaseInsensitive {
+				s, v = strings.ToLower(s), strings.ToLower(v)
+			}
+
+			if cp.Operator == operatorPrefix {
+				return strings.HasPrefix(s, v)
+			}
+			return strings.HasSuffix(s, v)
+		})
+	case operatorGreaterThan, operatorGreaterThanOrEqual, operatorLessThan, operatorLessThanOrEqual:
+		return anyMatch(elements, values, func(e any, v string) bool {
+			return compareValues(e, v, cp.Operator)
+		})
+	}
+
+	return false
+}
+
+// valueFrom returns the values of a path parameter or a claim to compare to
+func (a AuthorizerImpl) valueFrom(
+	source string,
+	claims map[string]any,
+	request *models.RequestContext) ([]string, bool) {
+
+	if name := strings.TrimPrefix(source, valueFromPathPrefix); name != source {
+		if request == nil {
+			return nil, false
+		}
+
+		param, captured := request.PathParams[name]
+		return []string{param}, captured
+	}
+
+	claim, exists := a.lookupClaim(claims, strings.TrimPrefix(source, valueFromClaimPrefix))
+	if !exists {
+		return nil, false
+	}
+
+	var values []string
+	for _, e := range claimElements(claim) {
+		if v, ok := claimString(e); ok {
+			values = append(values, v)
+		}
+	}
+
+	return values, values != nil
+}
+
+// claimElements returns the elements of an array claim, or the claim itself as a single element
+func claimElements(claim any) []any {
+	if arr, ok := claim.([]any); ok {
+		return arr
+	}
+
+	return []any{claim}
+}
+
+func anyMatch(elements []any, values []string, match func(any, string) bool) bool {
+	for _, e := range elements {
+		for _, v := range values {
+			if match(e, v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// valueEquals compares a claim value to a configured value by the type of the claim value,
+// so that numbers are compared numerically and booleans logically
+func valueEquals(claim any, value string, caseInsensitive bool) bool {
+	switch c := claim.(type) {
+	case string:
+		if caseInsensitive {
+			return strings.EqualFold(c, value)
+		}
+		return c == value
+	case bool:
+		b, err := strconv.ParseBool(value)
+		return err == nil && b == c
+	}
+
+	if n, ok := claimNumber(claim); ok {
+		f, err := strconv.ParseFloat(value, 64)
+		return err == nil && f == n
+	}
+
+	return false
+}
+
+// claimString returns the string form of scalar claim values
+func claimString(claim any) (string, bool) {
+	switch c := claim.(type) {
+	case string:
+		return c, true
+	case bool:
+		return strconv.FormatBool(c), true
+	}
+
+	if n, ok := claimNumber(claim); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	}
+
+	return "", false
+}
+
+func claimNumber(claim any) (float64, bool) {
+	switch c := claim.(type) {
+	case float64:
+		return c, true
+	case float32:
+		return float64(c), true
+	case int:
+		return float64(c), true
+	case int32:
+		return float64(c), true
+	case int64:
+		return float64(c), true
+	case uint:
+		return float64(c), true
+	case uint32:
+		return float64(c), true
+	case uint64:
+		return float64(c), true
+	case json.Number:
+		f, err := c.Float64()
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// comparableValue converts numbers and RFC3339 times to numbers, times as seconds since epoch
+func comparableValue(value string) (float64, bool) {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f, true
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return float64(t.UnixNano()) / float64(time.Second), true
+	}
+
+	return 0, false
+}
+
+func compareValues(claim any, value string, operator string) bool {
+	c, ok := claimNumber(claim)
+	if !ok {
+		s, isString := claim.(string)
+		if !isString {
+			return false
+		}
+
+		c, ok = comparableValue(s)
+		if !ok {
+			return false
+		}
+	}
+
+	v, ok := comparableValue(value)
+	if !ok {
+		return false
+	}
+
+	switch operator {
+	case operatorGreaterThan:
+		return c > v
+	case operatorGreaterThanOrEqual:
+		return c >= v
+	case operatorLessThan:
+		return c < v
+	case operatorLessThanOrEqual:
+		return c <= v
+	}
+
+	return false
+}
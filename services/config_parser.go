@@ -3,6 +3,8 @@ package services
 import (
 	"fmt"
 	"io"
+	"log"
+	"net/http"
 	"net/url"
 	"sort"
 	"strings"
@@ -37,29 +39,22 @@ func (YamlConfigParser) ParseConfig(reader io.Reader) (*models.Config, error) {
 		}
 	}
 
-	// sort route specifications with decreasing specifity
+	// sort route specifications with decreasing priority and specifity, then by path
 	// this order is used to decide if anonymous requests should be allowed
 	sort.SliceStable(cfg.RoutePolicies, func(i, j int) bool {
-		p1 := strings.Trim(cfg.RoutePolicies[i].Path, "/ \t\n")
-		p2 := strings.Trim(cfg.RoutePolicies[j].Path, "/ \t\n")
-
-		pl1 := strings.Count(p1, "/")
-		pl2 := strings.Count(p2, "/")
-
-		// sort by increasing path lengths
-		if pl1 > pl2 {
-			return true
-		} else if pl1 == pl2 {
-			wc1 := strings.Count(p1, "*")
-			wc2 := strings.Count(p2, "*")
-
-			if wc1 < wc2 { // then by decreasing number of wildcards
-				return true
-			}
+		rp1, rp2 := cfg.RoutePolicies[i], cfg.RoutePolicies[j]
+		if c := compareRoutePolicies(rp1, rp2); c != 0 {
+			return c > 0
 		}
-		return false
+
+		return strings.Trim(rp1.Path, " \t\n/") < strings.Trim(rp2.Path, " \t\n/")
 	})
 
+	for _, pair := range ambiguousRoutePolicies(cfg.RoutePolicies) {
+		log.Printf("Warning: route policies %s %v and %s %v have the same priority and specificity, "+
+			"set priority to order them", pair[0].Path, pair[0].Methods, pair[1].Path, pair[1].Methods)
+	}
+
 	return &cfg, nil
 }
 
@@ -67,19 +62,78 @@ func (YamlConfigParser) ParseConfig(reader io.Reader) (*models.Config, error) {
 //
 // - Both claim policies and route policies must not be nil. Empty map/slices are allowed.
 //
-// - All ClaimRequirement instances must have a claim named.
+// - All ClaimRequirement instances must have a valid claim path, a known operator and values that fit the operator.
+// Regular expressions must compile, and comparison values must be numbers or RFC3339 times.
+//
+// - Each ClaimRequirement must be exactly one of a claim, allOf, anyOf, not or a policy reference. AllOf and anyOf
+// cannot be empty, and referenced claim policies must exist without forming a reference cycle.
 //
 // - All RoutePolicy instances must have a path configured.
 //
 // - If a RoutePolicy is flagged with AllowAnonymous, it cannot name any claim policies
 //
 // - If a RoutePolicy has a claim policy named, that claim policy should be defined in the ClaimPolicies section.
+//
+// - A RoutePolicy cannot have both policyName and policyNames, and its policy mode must be requireAll or requireAny.
+//
+// - A deny RoutePolicy cannot allow anonymous requests, override other routes or have token requirements.
+// The anonymous deny status can only be 401 or 403.
+//
+// - Path parameters of a RoutePolicy must have unique names, and cannot be placed between two ** wildcards.
+// Claim requirements can take values from path parameters only with operators that compare to a value.
+//
+// - Roles can only inherit defined roles without forming a cycle, and the roles claim must be a valid claim path
+// different from the permissions claim.
+//
+// - Trusted proxies and cidrs requirements must be valid CIDR ranges or IP addresses, and hosts requirements can only
+// have a wildcard as the leftmost label.
+//
+// - Encoded slashes in paths can only be rejected or decoded. Hosts of route policies follow the same rules as hosts
+// requirements. Header and query parameter conditions of route policies must have exactly one of a value, a regex or
+// presence, and their regexes must compile.
+//
+// - Route policies can only have a Rego query if a Rego bundle is configured, the query must be a reference under data.
+// Anonymous and deny route policies cannot have a Rego query.
+//
+// - Relationship schemas can only refer to defined object types and relations, and relation rewrites cannot form a
+// cycle. The admin API requires an existing admin claim policy. Relation requirements of route policies must refer to
+// defined relations and to path parameters of the route. Anonymous and deny route policies cannot have them.
+//
+// - Expressions must compile against the claims, request and route variables, and evaluate to a bool. Request and
+// route fields are typed, so misspelled fields and comparisons with values of another type are rejected.
+//
+// - JWKS and OpenID Connect discovery URLs, if configured, must be http(s) URLs.
+//
+// - JWKS URL cannot be configured together with discovery, and discovery from the well-known location requires an
+// issuer.
+//
+// - JWKS refresh and discovery intervals cannot be negative.
+//
+// - Listed issuers must have a key source, unique issuer names and at most one of them can be unnamed.
+//
+// - If a RoutePolicy is flagged with AllowAnonymous, it cannot list any issuers, audiences or scopes.
+//
+// - Token sources must name exactly one of a header, cookie, query parameter or form field, and only headers can
+// have a scheme.
+//
+// - Token introspection cannot be configured together with issuers, its URL must be an http(s) URL and its cache
+// TTLs cannot be negative.
 func ValidateConfig(cfg *models.Config) error {
 	err := validateServer(cfg.Server)
 	if err != nil {
 		return fmt.Errorf("invalid server section: %w", err)
 	}
 
+	err = validateAuthentication(cfg.Authentication)
+	if err != nil {
+		return fmt.Errorf("invalid authentication section: %w", err)
+	}
+
+	err = validateRoles(cfg.Roles)
+	if err != nil {
+		return fmt.Errorf("invalid roles section: %w", err)
+	}
+
 	err = validateClaimPolicies(cfg.ClaimPolicies)
 	if err != nil {
 		return fmt.Errorf("invalid claimPolicies section: %w", err)
@@ -90,6 +144,27 @@ func ValidateConfig(cfg *models.Config) error {
 		return fmt.Errorf("invalid routePolicies section: %w", err)
 	}
 
+	err = validateRego(cfg.Rego, cfg.RoutePolicies)
+	if err != nil {
+		return fmt.Errorf("invalid rego section: %w", err)
+	}
+
+	err = validateRelationships(cfg.Relationships, cfg.ClaimPolicies)
+	if err != nil {
+		return fmt.Errorf("invalid relationships section: %w", err)
+	}
+
+	for _, rp := range cfg.RoutePolicies {
+		if rp.Relation == nil {
+			continue
+		}
+
+		err = validateRelationRequirement(cfg.Relationships, rp)
+		if err != nil {
+			return fmt.Errorf("invalid routePolicies section: route policy %s: %w", rp.Path, err)
+		}
+	}
+
 	return nil
 }
 
@@ -98,20 +173,178 @@ func validateServer(cfg models.ServerConfig) error {
 		return fmt.Errorf("upstream url scheme must be http or https")
 	}
 
+	switch cfg.AnonymousDenyStatus {
+	case 0, http.StatusUnauthorized, http.StatusForbidden:
+	default:
+		return fmt.Errorf("anonymous deny status must be 401 or 403")
+	}
+
+	for _, cidr := range cfg.TrustedProxies {
+		_, err := parseNetwork(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+	}
+
+	err := validatePathConfig(cfg.Paths)
+	if err != nil {
+		return fmt.Errorf("invalid paths config: %w", err)
+	}
+
+	return nil
+}
+
+func validateAuthentication(cfg models.AuthenticationConfig) error {
+	for i, source := range cfg.TokenSources {
+		err := validateTokenSource(source)
+		if err != nil {
+			return fmt.Errorf("invalid token source #%d: %w", i+1, err)
+		}
+	}
+
+	if cfg.Introspection != nil {
+		if hasKeySource(cfg.IssuerConfig) || cfg.Issuers != nil {
+			return fmt.Errorf("introspection cannot be configured together with token issuers")
+		}
+
+		err := validateIntrospection(*cfg.Introspection)
+		if err != nil {
+			return fmt.Errorf("invalid introspection: %w", err)
+		}
+	}
+
+	err := validateIssuer(cfg.IssuerConfig)
+	if err != nil {
+		return err
+	}
+
+	issuers := make(map[string]bool)
+	if cfg.Issuer != "" {
+		issuers[cfg.Issuer] = true
+	}
+
+	unnamed := 0
+	for i, issuer := range cfg.Issuers {
+		err = validateIssuer(issuer)
+		if err != nil {
+			return fmt.Errorf("invalid issuer #%d (%s): %w", i+1, issuer.Issuer, err)
+		}
+
+		if !hasKeySource(issuer) {
+			return fmt.Errorf("issuer #%d (%s) has no signing key, jwks url or discovery configured",
+				i+1, issuer.Issuer)
+		}
+
+		// issuers discovered from a discovery url are named at runtime
+		if issuer.Issuer == "" && issuer.OIDCDiscoveryURL == "" {
+			unnamed++
+		}
+
+		if issuer.Issuer != "" && issuers[issuer.Issuer] {
+			return fmt.Errorf("duplicate issuer: %s", issuer.Issuer)
+		}
+
+		issuers[issuer.Issuer] = true
+	}
+
+	if unnamed > 1 {
+		return fmt.Errorf("found %d issuers without a name, at most one is allowed", unnamed)
+	}
+
+	return nil
+}
+
+func validateTokenSource(source models.TokenSource) error {
+	count := 0
+	for _, name := range []string{source.Header, source.Cookie, source.Query, source.Form} {
+		if name != "" {
+			count++
+		}
+	}
+
+	if count != 1 {
+		return fmt.Errorf("exactly one of header, cookie, query or form must be configured")
+	}
+
+	if source.Scheme != "" && source.Header == "" {
+		return fmt.Errorf("scheme can only be configured for headers")
+	}
+
+	return nil
+}
+
+func validateIntrospection(cfg models.IntrospectionConfig) error {
+	parsed, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("url could not be parsed: %w", err)
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("url scheme must be http or https")
+	}
+
+	if cfg.CacheTTLInSeconds < 0 || cfg.NegativeCacheTTLInSeconds < 0 {
+		return fmt.Errorf("cache ttls cannot be negative")
+	}
+
+	return nil
+}
+
+func validateIssuer(cfg models.IssuerConfig) error {
+	for name, u := range map[string]string{"jwks": cfg.JWKSURL, "oidc discovery": cfg.OIDCDiscoveryURL} {
+		if u == "" {
+			continue
+		}
+
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("%s url could not be parsed: %w", name, err)
+		}
+
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("%s url scheme must be http or https", name)
+		}
+	}
+
+	if cfg.JWKSRefreshIntervalInSeconds < 0 || cfg.JWKSMinRefreshIntervalInSeconds < 0 {
+		return fmt.Errorf("jwks refresh intervals cannot be negative")
+	}
+
+	discovering := cfg.OIDCDiscoveryURL != "" || cfg.Discover
+
+	if cfg.Discover && cfg.OIDCDiscoveryURL == "" && cfg.Issuer == "" {
+		return fmt.Errorf("issuer is required for discovery")
+	}
+
+	if discovering && cfg.JWKSURL != "" {
+		return fmt.Errorf("jwks url cannot be configured together with discovery")
+	}
+
+	if (discovering || cfg.JWKSURL != "") && cfg.SigningKey != "" {
+		return fmt.Errorf("signing key cannot be configured together with jwks url or discovery")
+	}
+
+	if cfg.DiscoveryIntervalInSeconds < 0 {
+		return fmt.Errorf("discovery interval cannot be negative")
+	}
+
 	return nil
 }
 
 func validateClaimPolicies(cfg models.ClaimPolicyConfig) error {
 	for policyName, policy := range cfg {
 		for _, requirement := range policy {
-			// Claim field is mandatory
-			if requirement.Claim == "" {
-				return fmt.Errorf("found claim policy (%s) with unnamed claim requirement: %v",
-					policyName, policy)
+			err := validateRequirementNode(requirement, cfg)
+			if err != nil {
+				return fmt.Errorf("found claim policy (%s) with invalid requirement: %w", policyName, err)
 			}
 		}
 	}
 
+	if cycle := findPolicyCycle(cfg); cycle != nil {
+		return fmt.Errorf("found claim policy reference cycle: %s", strings.Join(cycle, " > "))
+	}
+
 	return nil
 }
 
@@ -128,14 +361,55 @@ func validateRoutePolicies(claimPolicies models.ClaimPolicyConfig, routePolicies
 			return fmt.Errorf("found route policy without a path denition: %v", p)
 		}
 
-		// anonymous routes cannot name claim policies
-		if p.AllowAnonymous && (p.PolicyName != "") {
+		// anonymous routes cannot name claim policies or token requirements
+		if p.AllowAnonymous && (p.PolicyName != "" || p.PolicyNames != nil || p.RegoQuery != "" || p.Relation != nil ||
+			p.Issuers != nil || p.Audiences != nil || p.Scopes != nil) {
 			return fmt.Errorf("found route policy with ambiguous claim policy config: %v", p)
 		}
 
+		err := validatePathParams(p.Path)
+		if err != nil {
+			return fmt.Errorf("found route policy with invalid path (%s): %w", p.Path, err)
+		}
+
+		err = validateRouteGlob(p.Path)
+		if err != nil {
+			return fmt.Errorf("found route policy with invalid glob (%s): %w", p.Path, err)
+		}
+
+		if p.Hosts != nil {
+			err = validateHosts(p.Hosts)
+			if err != nil {
+				return fmt.Errorf("found route policy with invalid hosts (%s): %w", p.Path, err)
+			}
+		}
+
+		err = validateRouteConditions(p)
+		if err != nil {
+			return fmt.Errorf("found route policy with invalid conditions (%s): %w", p.Path, err)
+		}
+
+		// deny routes cannot allow anything
+		if p.Deny && (p.AllowAnonymous || p.Override || p.RegoQuery != "" || p.Relation != nil ||
+			p.Issuers != nil || p.Audiences != nil || p.Scopes != nil) {
+			return fmt.Errorf("found deny route policy with allow config: %v", p)
+		}
+
+		if p.PolicyName != "" && p.PolicyNames != nil {
+			return fmt.Errorf("found route policy with both policyName and policyNames: %v", p)
+		}
+
+		switch p.PolicyMode {
+		case "", policyModeRequireAll, policyModeRequireAny:
+		default:
+			return fmt.Errorf("found route policy with unknown policy mode: %v", p)
+		}
+
 		// non-existing policy check (~foreign key constraint)
-		if p.PolicyName != "" && !existingPolicies[p.PolicyName] {
-			return fmt.Errorf("non-existing policy name found in route policy: %v", p)
+		for _, name := range routePolicyNames(p) {
+			if !existingPolicies[name] {
+				return fmt.Errorf("non-existing policy name found in route policy: %v", p)
+			}
 		}
 	}
 
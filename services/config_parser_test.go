@@ -60,6 +60,59 @@ func TestYamlConfigParser_ParseConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "authentication config deserialize",
+			yaml: "authentication:\n" +
+				" issuer: https://internal\n" +
+				" audience: gateway\n" +
+				" issuers:\n" +
+				"  - issuer: https://customers\n" +
+				"    jwksUrl: https://customers/jwks\n" +
+				"    algorithms: [RS256, ES256]\n" +
+				"routePolicies:\n" +
+				" - path: /internal/**\n" +
+				"   issuers: [https://internal]",
+			want: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						Issuer:   "https://internal",
+						Audience: "gateway",
+					},
+					Issuers: []models.IssuerConfig{
+						{
+							Issuer:     "https://customers",
+							JWKSURL:    "https://customers/jwks",
+							Algorithms: []string{"RS256", "ES256"},
+						},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/internal/**", Issuers: []string{"https://internal"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "roles config deserialize",
+			yaml: "roles:\n" +
+				" claim: realm_access.roles\n" +
+				" definitions:\n" +
+				"  admin:\n" +
+				"   inherits: [viewer]\n" +
+				"   permissions: [users:delete]\n" +
+				"  viewer:\n" +
+				"   permissions: [users:read]\n",
+			want: &models.Config{
+				Roles: models.RolesConfig{
+					Claim: "realm_access.roles",
+					Definitions: map[string]models.RoleDefinition{
+						"admin":  {Inherits: []string{"viewer"}, Permissions: []string{"users:delete"}},
+						"viewer": {Permissions: []string{"users:read"}},
+					},
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "server config deserialize",
 			yaml: "server:\n" +
@@ -103,9 +156,9 @@ func TestYamlConfigParser_ParseConfig(t *testing.T) {
 			want: &models.Config{
 				RoutePolicies: []models.RoutePolicy{
 					{Path: "/test/this/and/that"},
-					{Path: "/test/**/that"},
 					{Path: "/test/this"},
 					{Path: "/test/*/"},
+					{Path: "/test/**/that"},
 					{Path: "/test/**"},
 					{Path: "/test"},
 					{Path: "/**"},
@@ -113,6 +166,99 @@ func TestYamlConfigParser_ParseConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "sorts route policies by literal characters of wildcard segments",
+			yaml: "routePolicies:\n" +
+				" - path: /files/*\n" +
+				" - path: /files/{name}.json\n" +
+				" - path: /files/*.json",
+			want: &models.Config{
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/files/*.json"},
+					{Path: "/files/{name}.json"},
+					{Path: "/files/*"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "sorts route policies by priority",
+			yaml: "routePolicies:\n" +
+				" - path: /test/this\n" +
+				" - path: /test/**\n" +
+				"   priority: 1\n" +
+				" - path: /**\n" +
+				"   priority: -1\n" +
+				" - path: /other",
+			want: &models.Config{
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/test/**", Priority: 1},
+					{Path: "/test/this"},
+					{Path: "/other"},
+					{Path: "/**", Priority: -1},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "sorts route policies of the same specificity by path",
+			yaml: "routePolicies:\n" +
+				" - path: /b/{id}\n" +
+				" - path: /b/*\n" +
+				" - path: /a/*",
+			want: &models.Config{
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/a/*"},
+					{Path: "/b/*"},
+					{Path: "/b/{id}"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "sorts route policies of the same path by host specificity",
+			yaml: "routePolicies:\n" +
+				" - path: /users/**\n" +
+				" - path: /users/**\n" +
+				"   hosts: ['*.tenant.example.com']\n" +
+				" - path: /users/**\n" +
+				"   hosts: [admin.example.com]\n" +
+				" - path: /users/{id}",
+			want: &models.Config{
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/users/{id}"},
+					{Path: "/users/**", Hosts: []string{"admin.example.com"}},
+					{Path: "/users/**", Hosts: []string{"*.tenant.example.com"}},
+					{Path: "/users/**"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "sorts route policies of the same path and hosts by conditions",
+			yaml: "routePolicies:\n" +
+				" - path: /orders/**\n" +
+				" - path: /orders/**\n" +
+				"   headers:\n" +
+				"    Accept-Version: {value: '2'}\n" +
+				" - path: /orders/**\n" +
+				"   headers:\n" +
+				"    Accept-Version: {value: '2'}\n" +
+				"   query:\n" +
+				"    debug: {present: false}",
+			want: &models.Config{
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:    "/orders/**",
+						Headers: map[string]models.RequestMatch{"Accept-Version": {Value: "2"}},
+						Query:   map[string]models.RequestMatch{"debug": {Present: new(bool)}},
+					},
+					{Path: "/orders/**", Headers: map[string]models.RequestMatch{"Accept-Version": {Value: "2"}}},
+					{Path: "/orders/**"},
+				},
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -134,6 +280,122 @@ func TestYamlConfigParser_ParseConfig(t *testing.T) {
 	}
 }
 
+func TestAmbiguousRoutePolicies(t *testing.T) {
+	tests := []struct {
+		name          string
+		routePolicies []models.RoutePolicy
+		wantAmbiguous bool
+	}{
+		{
+			name:          "wildcard and parameter of the same position",
+			routePolicies: []models.RoutePolicy{{Path: "/users/*"}, {Path: "/users/{id}"}},
+			wantAmbiguous: true,
+		},
+		{
+			name:          "different literals",
+			routePolicies: []models.RoutePolicy{{Path: "/users/*"}, {Path: "/orders/*"}},
+			wantAmbiguous: false,
+		},
+		{
+			name:          "wildcards of different literal prefixes",
+			routePolicies: []models.RoutePolicy{{Path: "/files/a*"}, {Path: "/files/b*"}},
+			wantAmbiguous: false,
+		},
+		{
+			name:          "wildcards of different literal suffixes",
+			routePolicies: []models.RoutePolicy{{Path: "/files/*.gif"}, {Path: "/files/*.png"}},
+			wantAmbiguous: false,
+		},
+		{
+			name: "different methods",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/users/*", Methods: []string{"GET"}},
+				{Path: "/users/{id}", Methods: []string{"POST"}},
+			},
+			wantAmbiguous: false,
+		},
+		{
+			name: "all methods and a method",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/users/*"},
+				{Path: "/users/{id}", Methods: []string{"POST"}},
+			},
+			wantAmbiguous: true,
+		},
+		{
+			name: "same path of all methods and a method",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/users/*"},
+				{Path: "/users/*", Methods: []string{"POST"}},
+			},
+			wantAmbiguous: false,
+		},
+		{
+			name:          "same path",
+			routePolicies: []models.RoutePolicy{{Path: "/users/*"}, {Path: "/users/*"}},
+			wantAmbiguous: true,
+		},
+		{
+			name: "different hosts",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/users/*", Hosts: []string{"a.example.com", "*.b.example.com"}},
+				{Path: "/users/{id}", Hosts: []string{"b.example.com"}},
+			},
+			wantAmbiguous: false,
+		},
+		{
+			name: "overlapping hosts",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/users/*", Hosts: []string{"*.example.com"}},
+				{Path: "/users/{id}", Hosts: []string{"*.b.example.com"}},
+			},
+			wantAmbiguous: true,
+		},
+		{
+			name: "different header values",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/orders/*", Headers: map[string]models.RequestMatch{"Accept-Version": {Value: "1"}}},
+				{Path: "/orders/{id}", Headers: map[string]models.RequestMatch{"accept-version": {Value: "2"}}},
+			},
+			wantAmbiguous: false,
+		},
+		{
+			name: "query parameter absent and matched",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/orders/*", Query: map[string]models.RequestMatch{"debug": {Present: new(bool)}}},
+				{Path: "/orders/{id}", Query: map[string]models.RequestMatch{"debug": {Regex: "^1$"}}},
+			},
+			wantAmbiguous: false,
+		},
+		{
+			name: "conditions of different headers",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/orders/*", Headers: map[string]models.RequestMatch{"Accept-Version": {Value: "1"}}},
+				{Path: "/orders/{id}", Headers: map[string]models.RequestMatch{"X-Debug": {Value: "1"}}},
+			},
+			wantAmbiguous: true,
+		},
+		{
+			name:          "different priorities",
+			routePolicies: []models.RoutePolicy{{Path: "/users/*", Priority: 1}, {Path: "/users/{id}"}},
+			wantAmbiguous: false,
+		},
+		{
+			name:          "different specificity",
+			routePolicies: []models.RoutePolicy{{Path: "/users/{id}"}, {Path: "/users/**"}},
+			wantAmbiguous: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ambiguousRoutePolicies(tt.routePolicies)
+			if (len(got) != 0) != tt.wantAmbiguous {
+				t.Errorf("ambiguousRoutePolicies() = %v, wantAmbiguous %v", got, tt.wantAmbiguous)
+			}
+		})
+	}
+}
+
 func TestValidateConfig(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -156,87 +418,1541 @@ func TestValidateConfig(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name: "route policy without path",
+			name: "valid claim requirement operators",
 			config: &models.Config{
-				ClaimPolicies: map[string][]models.ClaimRequirement{},
-				RoutePolicies: []models.RoutePolicy{
-					{AllowAnonymous: true},
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "email", Operator: "regex", Values: []string{`@example\.com$`}},
+						{Claim: "iat", Operator: "gt", Values: []string{"2020-01-01T00:00:00Z"}},
+						{Claim: "level", Operator: "lte", Values: []string{"3"}},
+						{Claim: "roles", Operator: "containsAll", Values: []string{"a", "b"}, CaseInsensitive: true},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid claim paths",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "resource_access.billing-api.roles"},
+						{Claim: `https://example\.com/roles`},
+						{Claim: "groups[*].name"},
+						{Claim: "groups[0][1]"},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid claim path realm_access..roles",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: `realm_access..roles`},
+					},
 				},
+				RoutePolicies: []models.RoutePolicy{},
 			},
 			wantErr: true,
 		},
 		{
-			name: "route policy both allow anon and policy named",
+			name: "invalid claim path roles.",
 			config: &models.Config{
 				ClaimPolicies: map[string][]models.ClaimRequirement{
-					"TestPolicy": {
-						models.ClaimRequirement{Claim: "test"},
+					"Test": {
+						{Claim: `roles.`},
 					},
 				},
-				RoutePolicies: []models.RoutePolicy{
-					{
-						Path:           "/",
-						AllowAnonymous: true,
-						PolicyName:     "TestPolicy",
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid claim path [0].name",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: `[0].name`},
 					},
 				},
+				RoutePolicies: []models.RoutePolicy{},
 			},
 			wantErr: true,
 		},
 		{
-			name: "route policy allow anon false but policy not named",
+			name: "invalid claim path groups[x]",
 			config: &models.Config{
-				ClaimPolicies: map[string][]models.ClaimRequirement{},
-				RoutePolicies: []models.RoutePolicy{
-					{
-						Path:           "/",
-						AllowAnonymous: false,
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: `groups[x]`},
 					},
 				},
+				RoutePolicies: []models.RoutePolicy{},
 			},
-			wantErr: false,
+			wantErr: true,
 		},
 		{
-			name: "route policy names non-existing claim policy",
+			name: "invalid claim path groups[0]name",
 			config: &models.Config{
-				ClaimPolicies: map[string][]models.ClaimRequirement{},
-				RoutePolicies: []models.RoutePolicy{
-					{
-						Path:       "/",
-						PolicyName: "NonExistingClaimPolicy",
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: `groups[0]name`},
 					},
 				},
+				RoutePolicies: []models.RoutePolicy{},
 			},
 			wantErr: true,
 		},
 		{
-			name: "valid allow anon",
+			name: "invalid claim path groups[0",
 			config: &models.Config{
-				ClaimPolicies: map[string][]models.ClaimRequirement{},
-				RoutePolicies: []models.RoutePolicy{
-					{
-						Path:           "/",
-						AllowAnonymous: true,
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: `groups[0`},
 					},
 				},
+				RoutePolicies: []models.RoutePolicy{},
 			},
-			wantErr: false,
+			wantErr: true,
 		},
 		{
-			name: "valid route with policy",
+			name: "invalid claim path with dangling escape",
 			config: &models.Config{
 				ClaimPolicies: map[string][]models.ClaimRequirement{
-					"TestPolicy": {models.ClaimRequirement{Claim: "test"}},
+					"Test": {
+						{Claim: `roles\`},
+					},
 				},
-				RoutePolicies: []models.RoutePolicy{
-					{
-						Path:       "/",
-						PolicyName: "TestPolicy",
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "policy composition",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Admin": {
+						{Claim: "role", Values: []string{"admin"}},
+					},
+					"Test": {
+						{
+							AnyOf: []models.ClaimRequirement{
+								{Policy: "Admin"},
+								{AllOf: []models.ClaimRequirement{
+									{Claim: "role", Values: []string{"editor"}},
+									{Claim: "department", Values: []string{"marketing"}},
+								}},
+							},
+						},
+						{Not: &models.ClaimRequirement{Claim: "suspended", Values: []string{"true"}}},
 					},
 				},
+				RoutePolicies: []models.RoutePolicy{},
 			},
 			wantErr: false,
 		},
+		{
+			name: "reference to non-existing policy",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Policy: "Admin"},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "policy reference cycle",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"A": {
+						{AnyOf: []models.ClaimRequirement{{Claim: "role"}, {Policy: "B"}}},
+					},
+					"B": {
+						{Not: &models.ClaimRequirement{Policy: "A"}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ambiguous requirement",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "role", AnyOf: []models.ClaimRequirement{{Claim: "department"}}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "values without claim",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Not: &models.ClaimRequirement{Claim: "role"}, Values: []string{"admin"}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty anyOf",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{AnyOf: []models.ClaimRequirement{}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid nested requirement",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{AllOf: []models.ClaimRequirement{{Claim: "age", Operator: "gt", Values: []string{"old"}}}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown operator",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "name", Operator: "like", Values: []string{"John"}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid regex",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "name", Operator: "regex", Values: []string{"(John"}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "equals with multiple values",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "name", Operator: "equals", Values: []string{"John", "Jane"}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "in without values",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "name", Operator: "in"},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "comparison with non comparable value",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "level", Operator: "gt", Values: []string{"high"}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "case insensitive comparison",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Claim: "level", Operator: "gt", Values: []string{"3"}, CaseInsensitive: true},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy without path",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{AllowAnonymous: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy both allow anon and policy named",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"TestPolicy": {
+						models.ClaimRequirement{Claim: "test"},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:           "/",
+						AllowAnonymous: true,
+						PolicyName:     "TestPolicy",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy with policy names",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Admin":   {{Claim: "role", Values: []string{"admin"}}},
+					"Auditor": {{Claim: "role", Values: []string{"auditor"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/reports", PolicyNames: []string{"Admin", "Auditor"}, PolicyMode: "requireAny", Override: true},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "route policy names non-existing claim policy in policy names",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Admin": {{Claim: "role", Values: []string{"admin"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/reports", PolicyNames: []string{"Admin", "Auditor"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy with both policy name and policy names",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Admin": {{Claim: "role", Values: []string{"admin"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/reports", PolicyName: "Admin", PolicyNames: []string{"Admin"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy with unknown policy mode",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Admin": {{Claim: "role", Values: []string{"admin"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/reports", PolicyNames: []string{"Admin"}, PolicyMode: "requireSome"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy both allow anon and policy names",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Admin": {{Claim: "role", Values: []string{"admin"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/reports", AllowAnonymous: true, PolicyNames: []string{"Admin"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy with path parameters",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Owner": {{Claim: "sub", ValueFrom: "path.id"}},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/tenants/{tenant}/**/users/{id}", PolicyName: "Owner"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "route policy with duplicate path parameters",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/users/{id}/friends/{id}"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy with path parameter between super wildcards",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/**/users/{id}/**"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy with invalid glob",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/a/[b"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy with invalid path parameter",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/users/user-{id}"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "value from unknown source",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Owner": {{Claim: "sub", ValueFrom: "body.id"}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "value from together with values",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Owner": {{Claim: "sub", ValueFrom: "path.id", Values: []string{"42"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "value from with regex operator",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Owner": {{Claim: "sub", ValueFrom: "path.id", Operator: "regex"}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "request requirements",
+			config: &models.Config{
+				Server: models.ServerConfig{TrustedProxies: []string{"192.0.2.0/24", "2001:db8::1"}},
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Header: "X-Tenant", ValueFrom: "claim.tenant"},
+						{Query: "debug", Operator: "notEquals", Values: []string{"true"}},
+						{Hosts: []string{"api.example.com", "*.example.org"}},
+						{CIDRs: []string{"10.0.0.0/8", "127.0.0.1"}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid trusted proxy",
+			config: &models.Config{
+				Server:        models.ServerConfig{TrustedProxies: []string{"192.0.2.0/33"}},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid cidr",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{CIDRs: []string{"10.0.0.0/8", "internal"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty hosts",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Hosts: []string{}}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "host with inner wildcard",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Hosts: []string{"api.*.example.com"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "header and claim together",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Header: "X-Tenant", Claim: "tenant"}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cidrs with values",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{CIDRs: []string{"10.0.0.0/8"}, Values: []string{"admin"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "value from invalid claim path",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Header: "X-Tenant", ValueFrom: "claim.tenants["}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expression",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {
+						{Expression: "claims.exp - claims.iat < 3600.0 && request.method in ['GET', 'HEAD']"},
+						{Not: &models.ClaimRequirement{Expression: "claims.sub == route.params.id"}},
+					},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "expression syntax error",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Expression: "claims.role =="}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expression with undeclared variable",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Expression: "token.role == 'admin'"}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expression with misspelled request field",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Not: &models.ClaimRequirement{Expression: "request.methd == 'DELETE'"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expression with misspelled route field",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Expression: "claims.sub == route.param.id"}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expression comparing request field to another type",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Expression: "request.method == 1"}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "non-bool expression",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Expression: "request.method + '!'"}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expression with values",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Test": {{Expression: "true", Values: []string{"admin"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "deny route policies",
+			config: &models.Config{
+				Server: models.ServerConfig{AnonymousDenyStatus: 403},
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"Locked": {{Claim: "account_status", Values: []string{"locked"}}},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/internal/**", Deny: true},
+					{Path: "/**", Deny: true, PolicyName: "Locked"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "rego route policies",
+			config: &models.Config{
+				Rego:          &models.RegoConfig{Bundle: "/etc/bouncer/policies"},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"Admin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/orders/**", RegoQuery: "data.orders.allow"},
+					{Path: "/orders/{id}", RegoQuery: `data.orders["allow_item"]`, PolicyName: "Admin"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "rego query without bundle",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{Path: "/orders/**", RegoQuery: "data.orders.allow"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty rego bundle",
+			config: &models.Config{
+				Rego:          &models.RegoConfig{},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative rego timeout",
+			config: &models.Config{
+				Rego:          &models.RegoConfig{Bundle: "/etc/bouncer/policies", TimeoutInMilliseconds: -1},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid rego query",
+			config: &models.Config{
+				Rego:          &models.RegoConfig{Bundle: "/etc/bouncer/policies"},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{Path: "/orders/**", RegoQuery: "data.orders.allow =="}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rego query outside data",
+			config: &models.Config{
+				Rego:          &models.RegoConfig{Bundle: "/etc/bouncer/policies"},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{Path: "/orders/**", RegoQuery: "input.allow"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "anonymous rego route policy",
+			config: &models.Config{
+				Rego:          &models.RegoConfig{Bundle: "/etc/bouncer/policies"},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/orders/**", RegoQuery: "data.orders.allow", AllowAnonymous: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "deny rego route policy",
+			config: &models.Config{
+				Rego:          &models.RegoConfig{Bundle: "/etc/bouncer/policies"},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{Path: "/orders/**", RegoQuery: "data.orders.deny", Deny: true}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relationships",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+					AdminListenAddress: "127.0.0.1:3513",
+					AdminPolicyName:    "TupleAdmin",
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/documents/{docId}/**", Relation: &models.RelationRequirement{Check: "document#editor@{path.docId}"}},
+					{Path: "/projects/**", Relation: &models.RelationRequirement{Check: "folder#owner@projects"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "relation requirement without relationships",
+			config: &models.Config{
+				Relationships: nil,
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/documents/{docId}/**", Relation: &models.RelationRequirement{Check: "document#editor@{path.docId}"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relationships without store",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin API without admin policy",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+					AdminListenAddress: "127.0.0.1:3513",
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "non-existing admin policy",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+					AdminPolicyName: "Admin",
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "undefined subject type",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+					SubjectType: "account",
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "undefined relation subject type",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":     {},
+						"document": {"owner": {Types: []string{"group#member"}}},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "undefined union relation",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":     {},
+						"document": {"editor": {Union: []string{"owner"}}},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "union relation undefined on related type",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":     {},
+						"folder":   {"owner": {Types: []string{"user"}}},
+						"document": {"parent": {Types: []string{"folder"}}, "editor": {Union: []string{"parent->editor"}}},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relation rewrite cycle",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user": {},
+						"document": {
+							"editor": {Types: []string{"user"}, Union: []string{"viewer"}},
+							"viewer": {Types: []string{"user"}, Union: []string{"editor"}},
+						},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relation check of undefined relation",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/documents/{docId}", Relation: &models.RelationRequirement{Check: "document#viewer@{path.docId}"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relation check of uncaptured path parameter",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/documents/{id}", Relation: &models.RelationRequirement{Check: "document#editor@{path.docId}"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid relation check",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/documents/{docId}", Relation: &models.RelationRequirement{Check: "document:editor"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "anonymous relation route policy",
+			config: &models.Config{
+				Relationships: &models.RelationshipsConfig{
+					Store: "/var/lib/bouncer/tuples.json",
+					Schema: map[string]map[string]models.RelationDefinition{
+						"user":   {},
+						"group":  {"member": {Types: []string{"user", "group#member"}}},
+						"folder": {"owner": {Types: []string{"user", "group#member"}}},
+						"document": {
+							"parent": {Types: []string{"folder"}},
+							"owner":  {Types: []string{"user"}},
+							"editor": {Union: []string{"owner", "parent->owner"}},
+						},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{"TupleAdmin": {{Claim: "admin"}}},
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:           "/documents/{docId}",
+						AllowAnonymous: true,
+						Relation:       &models.RelationRequirement{Check: "document#editor@{path.docId}"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "deny route policy allowing anonymous",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/internal/**", Deny: true, AllowAnonymous: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "deny route policy with scopes",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/internal/**", Deny: true, Scopes: []string{"internal"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "paths config",
+			config: &models.Config{
+				Server: models.ServerConfig{Paths: models.PathConfig{
+					EncodedSlashes:    "decode",
+					CaseInsensitive:   true,
+					StripMatrixParams: true,
+				}},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "route policy hosts",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/**", Hosts: []string{"api.example.com", "*.tenant.example.com"}, AllowAnonymous: true},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid route policy host",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{Path: "/**", Hosts: []string{"api.*.example.com"}, AllowAnonymous: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty route policy hosts",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{Path: "/**", Hosts: []string{}, AllowAnonymous: true}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy conditions",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{
+					Path:           "/**",
+					Headers:        map[string]models.RequestMatch{"Accept-Version": {Value: "2"}},
+					Query:          map[string]models.RequestMatch{"api-version": {Regex: "^2"}, "debug": {Present: new(bool)}},
+					AllowAnonymous: true,
+				}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "route policy condition without a match",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{
+					Path:           "/**",
+					Headers:        map[string]models.RequestMatch{"Accept-Version": {}},
+					AllowAnonymous: true,
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy condition with value and regex",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{
+					Path:           "/**",
+					Query:          map[string]models.RequestMatch{"api-version": {Value: "2", Regex: "^2"}},
+					AllowAnonymous: true,
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy condition with invalid regex",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{
+					Path:           "/**",
+					Query:          map[string]models.RequestMatch{"api-version": {Regex: "(2"}},
+					AllowAnonymous: true,
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy condition without a name",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{{
+					Path:           "/**",
+					Query:          map[string]models.RequestMatch{"": {Value: "2"}},
+					AllowAnonymous: true,
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid encoded slashes policy",
+			config: &models.Config{
+				Server:        models.ServerConfig{Paths: models.PathConfig{EncodedSlashes: "keep"}},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid anonymous deny status",
+			config: &models.Config{
+				Server:        models.ServerConfig{AnonymousDenyStatus: 404},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "roles",
+			config: &models.Config{
+				Roles: models.RolesConfig{
+					Claim: "realm_access.roles",
+					Definitions: map[string]models.RoleDefinition{
+						"admin":  {Inherits: []string{"viewer"}, Permissions: []string{"users:delete"}},
+						"viewer": {Permissions: []string{"users:read"}},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "role inherits non-existing role",
+			config: &models.Config{
+				Roles: models.RolesConfig{
+					Definitions: map[string]models.RoleDefinition{
+						"admin": {Inherits: []string{"viewer"}},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "role inheritance cycle",
+			config: &models.Config{
+				Roles: models.RolesConfig{
+					Definitions: map[string]models.RoleDefinition{
+						"admin":   {Inherits: []string{"support"}},
+						"support": {Inherits: []string{"viewer"}},
+						"viewer":  {Inherits: []string{"admin"}},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "same roles and permissions claims",
+			config: &models.Config{
+				Roles: models.RolesConfig{
+					PermissionsClaim: "roles",
+					Definitions: map[string]models.RoleDefinition{
+						"admin": {Permissions: []string{"users:delete"}},
+					},
+				},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "roles claim without definitions",
+			config: &models.Config{
+				Roles:         models.RolesConfig{Claim: "roles"},
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy allow anon false but policy not named",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:           "/",
+						AllowAnonymous: false,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "route policy names non-existing claim policy",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:       "/",
+						PolicyName: "NonExistingClaimPolicy",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid allow anon",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:           "/",
+						AllowAnonymous: true,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid route with policy",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{
+					"TestPolicy": {models.ClaimRequirement{Claim: "test"}},
+				},
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:       "/",
+						PolicyName: "TestPolicy",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid jwks url",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						JWKSURL:                         "https://idp/.well-known/jwks.json",
+						JWKSRefreshIntervalInSeconds:    60,
+						JWKSMinRefreshIntervalInSeconds: 10,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid jwks url",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						JWKSURL: "¡http://clearly not a valid url!",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid jwks url scheme",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						JWKSURL: "file:///etc/jwks.json",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative jwks refresh interval",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						JWKSURL:                      "https://idp/.well-known/jwks.json",
+						JWKSRefreshIntervalInSeconds: -1,
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid discovery",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						Issuer:                     "https://idp",
+						Discover:                   true,
+						DiscoveryIntervalInSeconds: 600,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid discovery url scheme",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						OIDCDiscoveryURL: "ftp://idp/.well-known/openid-configuration",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "discover without issuer",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						Discover: true,
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "discovery together with jwks url",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						OIDCDiscoveryURL: "https://idp/.well-known/openid-configuration",
+						JWKSURL:          "https://idp/.well-known/jwks.json",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative discovery interval",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{
+						Issuer:                     "https://idp",
+						Discover:                   true,
+						DiscoveryIntervalInSeconds: -1,
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid issuers",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{Issuer: "https://internal"},
+					Issuers: []models.IssuerConfig{
+						{Issuer: "https://customers", JWKSURL: "https://customers/jwks"},
+						{SigningKey: "key", Algorithms: []string{"HS256"}},
+						{OIDCDiscoveryURL: "https://partners/.well-known/openid-configuration"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "duplicate issuers",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					IssuerConfig: models.IssuerConfig{Issuer: "https://internal"},
+					Issuers: []models.IssuerConfig{
+						{Issuer: "https://internal", JWKSURL: "https://internal/jwks"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "multiple unnamed issuers",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					Issuers: []models.IssuerConfig{
+						{JWKSURL: "https://internal/jwks"},
+						{JWKSURL: "https://customers/jwks"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "issuer without key source",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					Issuers: []models.IssuerConfig{
+						{Issuer: "https://internal"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid listed issuer",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					Issuers: []models.IssuerConfig{
+						{Issuer: "https://internal", SigningKey: "key", JWKSURL: "https://internal/jwks"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid token sources",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					TokenSources: []models.TokenSource{
+						{Header: "Authorization", Scheme: "Bearer"},
+						{Cookie: "session"},
+						{Query: "access_token"},
+						{Form: "access_token"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "token source without name",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					TokenSources: []models.TokenSource{{Scheme: "Bearer"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "token source with multiple names",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					TokenSources: []models.TokenSource{{Header: "Authorization", Cookie: "session"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cookie token source with scheme",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					TokenSources: []models.TokenSource{{Cookie: "session", Scheme: "Bearer"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid introspection",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					Introspection: &models.IntrospectionConfig{URL: "https://idp/introspect"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "introspection url scheme",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					Introspection: &models.IntrospectionConfig{URL: "idp/introspect"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative introspection cache ttl",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					Introspection: &models.IntrospectionConfig{
+						URL:               "https://idp/introspect",
+						CacheTTLInSeconds: -1,
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "introspection with issuers",
+			config: &models.Config{
+				Authentication: models.AuthenticationConfig{
+					Introspection: &models.IntrospectionConfig{URL: "https://idp/introspect"},
+					Issuers: []models.IssuerConfig{
+						{Issuer: "https://internal", JWKSURL: "https://internal/jwks"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy both allow anon and scopes listed",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:           "/",
+						AllowAnonymous: true,
+						Scopes:         []string{"read"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route policy both allow anon and issuers listed",
+			config: &models.Config{
+				ClaimPolicies: map[string][]models.ClaimRequirement{},
+				RoutePolicies: []models.RoutePolicy{
+					{
+						Path:           "/",
+						AllowAnonymous: true,
+						Issuers:        []string{"https://internal"},
+					},
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid url scheme",
 			config: &models.Config{
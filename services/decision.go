@@ -0,0 +1,109 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// sensitiveHeaders hold credentials, their values are always redacted in decisions
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// requirementResult evaluates a top level requirement of a claim policy into a decision result,
+// with the actual and expected values of claim, header and query requirements
+func (a AuthorizerImpl) requirementResult(
+	rp models.RoutePolicy,
+	policy string,
+	cp models.ClaimRequirement,
+	claims map[string]any,
+	request *models.RequestContext) models.RequirementResult {
+
+	ok, failed := a.evaluate(cp, claims, request, 0)
+
+	result := models.RequirementResult{
+		RoutePolicy: rp.Path,
+		Policy:      policy,
+		Requirement: failed,
+		Passed:      ok,
+	}
+
+	if ok {
+		result.Requirement = describeRequirement(cp)
+	}
+
+	if cp.Claim != "" || cp.Header != "" || cp.Query != "" {
+		result.Operator = cp.Operator
+		result.Actual, result.Expected = a.requirementValues(cp, claims, request)
+	}
+
+	return result
+}
+
+// requirementValues returns the actual and expected values of a claim, header or query parameter requirement,
+// with the values of redacted claims and sensitive headers replaced
+func (a AuthorizerImpl) requirementValues(
+	cp models.ClaimRequirement,
+	claims map[string]any,
+	request *models.RequestContext) (actual []string, expected []string) {
+
+	switch {
+	case cp.Claim != "":
+		if claim, exists := a.lookupClaim(claims, cp.Claim); exists {
+			actual = formatClaim(claim)
+			if a.isRedacted(cp.Claim) {
+				actual = []string{models.Redacted}
+			}
+		}
+	case cp.Header != "" && sensitiveHeaders[http.CanonicalHeaderKey(cp.Header)]:
+		if len(requestValues(cp, request)) != 0 {
+			actual = []string{models.Redacted}
+		}
+	default:
+		actual = requestValues(cp, request)
+	}
+
+	if cp.ValueFrom == "" {
+		return actual, cp.Values
+	}
+
+	expected, _ = a.valueFrom(cp.ValueFrom, claims, request)
+
+	if claim := strings.TrimPrefix(cp.ValueFrom, valueFromClaimPrefix); claim != cp.ValueFrom && a.isRedacted(claim) {
+		if expected != nil {
+			expected = []string{models.Redacted}
+		}
+	}
+
+	return actual, expected
+}
+
+// isRedacted checks if a claim, or the claim it is nested in, is redacted
+func (a AuthorizerImpl) isRedacted(claim string) bool {
+	for _, r := range a.redactedClaims {
+		if claim == r || strings.HasPrefix(claim, r+".") || strings.HasPrefix(claim, r+"[") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatClaim returns the elements of a claim as strings
+func formatClaim(claim any) []string {
+	var values []string
+	for _, e := range claimElements(claim) {
+		if s, ok := claimString(e); ok {
+			values = append(values, s)
+		} else {
+			values = append(values, fmt.Sprint(e))
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// expressionCostLimit bounds the work of a single expression evaluation, so that expensive expressions
+// cannot stall requests. Expressions exceeding it fail.
+const expressionCostLimit = 100000
+
+// expressionEnv is the environment shared by all expressions, created once by newExpressionEnv
+var expressionEnv = struct {
+	once sync.Once
+	env  *cel.Env
+	err  error
+}{}
+
+// compiledExpressions caches the programs of compiled expressions, keyed by expression, so that expressions
+// checked by config validation are not compiled again by NewAuthorizer
+var compiledExpressions sync.Map
+
+// newExpressionEnv declares the variables available to expressions: claims of the token, request details and route
+// path parameters. Request and route fields are declared one by one with their types, so that misspelled fields and
+// mistyped comparisons do not compile.
+func newExpressionEnv() (*cel.Env, error) {
+	expressionEnv.once.Do(func() {
+		stringMap := cel.MapType(cel.StringType, cel.StringType)
+
+		expressionEnv.env, expressionEnv.err = cel.NewEnv(
+			cel.Variable("claims", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("request.method", cel.StringType),
+			cel.Variable("request.path", cel.StringType),
+			cel.Variable("request.host", cel.StringType),
+			cel.Variable("request.ip", cel.StringType),
+			cel.Variable("request.headers", stringMap),
+			cel.Variable("request.query", stringMap),
+			cel.Variable("route.params", stringMap),
+		)
+	})
+
+	return expressionEnv.env, expressionEnv.err
+}
+
+// compileExpression parses and type-checks an expression, which must evaluate to a bool
+func compileExpression(expression string) (cel.Program, error) {
+	if program, exists := compiledExpressions.Load(expression); exists {
+		return program.(cel.Program), nil
+	}
+
+	env, err := newExpressionEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expression, issues.Err())
+	}
+
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression %q must evaluate to bool, not %s", expression, ast.OutputType())
+	}
+
+	program, err := env.Program(ast, cel.CostLimit(expressionCostLimit))
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expression, err)
+	}
+
+	compiledExpressions.Store(expression, program)
+
+	return program, nil
+}
+
+// compileExpressions compiles the expressions of all requirements, keyed by expression.
+// The first expression that does not compile is returned as an error.
+func compileExpressions(claimPolicies map[string][]models.ClaimRequirement) (map[string]cel.Program, error) {
+	programs := make(map[string]cel.Program)
+
+	err := walkPolicyRequirements(claimPolicies, func(cp models.ClaimRequirement) error {
+		if cp.Expression == "" {
+			return nil
+		}
+
+		program, err := compileExpression(cp.Expression)
+		if err != nil {
+			return err
+		}
+
+		programs[cp.Expression] = program
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return programs, nil
+}
+
+// expressionMet evaluates an expression, errors and non-bool results fail the requirement
+func (a AuthorizerImpl) expressionMet(
+	expression string,
+	claims map[string]any,
+	request *models.RequestContext) bool {
+
+	program, exists := a.expressions[expression]
+	if !exists {
+		return false
+	}
+
+	if claims == nil {
+		claims = map[string]any{}
+	}
+
+	result, _, err := program.Eval(expressionVars(claims, request))
+	if err != nil {
+		return false
+	}
+
+	return result == types.True
+}
+
+// expressionVars exposes the claims, request and route to expressions.
+// Header names are lowercase with multiple values joined by commas, only the first value of a query parameter is kept.
+func expressionVars(claims map[string]any, request *models.RequestContext) map[string]any {
+	headers := make(map[string]string)
+	query := make(map[string]string)
+	params := make(map[string]string)
+	vars := map[string]any{
+		"claims":          claims,
+		"request.method":  "",
+		"request.path":    "",
+		"request.host":    "",
+		"request.ip":      "",
+		"request.headers": headers,
+		"request.query":   query,
+		"route.params":    params,
+	}
+
+	if request == nil {
+		return vars
+	}
+
+	vars["request.method"] = request.Method
+	vars["request.path"] = request.Path
+	vars["request.host"] = request.Host
+
+	if request.ClientIP != nil {
+		vars["request.ip"] = request.ClientIP.String()
+	}
+
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+
+	for name, values := range request.Query {
+		if len(values) != 0 {
+			query[name] = values[0]
+		}
+	}
+
+	for name, value := range request.PathParams {
+		params[name] = value
+	}
+
+	return vars
+}
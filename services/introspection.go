@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+const (
+	defaultIntrospectionCacheTTL         = time.Minute
+	defaultIntrospectionNegativeCacheTTL = 10 * time.Second
+	introspectionTimeout                 = 10 * time.Second
+
+	// expired entries are swept from the cache once it grows beyond this size
+	introspectionCacheSweepSize = 10000
+)
+
+// IntrospectionAuthenticator validates opaque tokens with an OAuth2 token introspection (RFC 7662) endpoint
+type IntrospectionAuthenticator struct {
+	config      models.IntrospectionConfig
+	tokens      tokenExtractor
+	client      *http.Client
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]introspectionResult
+}
+
+// introspectionResult is a cached introspection response.
+// Inactive tokens are cached with nil claims.
+type introspectionResult struct {
+	claims    map[string]any
+	expiresAt time.Time
+}
+
+// NewIntrospectionAuthenticator creates a new IntrospectionAuthenticator instance.
+// Zero cache TTLs mean the defaults are used.
+func NewIntrospectionAuthenticator(
+	config models.IntrospectionConfig,
+	tokenSources []models.TokenSource) (*IntrospectionAuthenticator, error) {
+
+	if config.URL == "" {
+		return nil, fmt.Errorf("introspection url is required")
+	}
+
+	a := &IntrospectionAuthenticator{
+		config:      config,
+		tokens:      newTokenExtractor(tokenSources),
+		client:      &http.Client{Timeout: introspectionTimeout},
+		ttl:         time.Duration(config.CacheTTLInSeconds) * time.Second,
+		negativeTTL: time.Duration(config.NegativeCacheTTLInSeconds) * time.Second,
+		cache:       make(map[[sha256.Size]byte]introspectionResult),
+	}
+
+	if a.ttl <= 0 {
+		a.ttl = defaultIntrospectionCacheTTL
+	}
+
+	if a.negativeTTL <= 0 {
+		a.negativeTTL = defaultIntrospectionNegativeCacheTTL
+	}
+
+	return a, nil
+}
+
+// Authenticate implements token authentication by introspection.
+// The returned claims are the fields of the introspection response,
+// and the returned issuer is the "iss" field of the response if there is one.
+func (a *IntrospectionAuthenticator) Authenticate(
+	ctx context.Context,
+	request *models.RequestContext) (map[string]any, string, error) {
+
+	token, err := a.tokens.extract(request)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// tokens are cached by their hashes to keep them out of memory dumps
+	key := sha256.Sum256([]byte(token))
+
+	claims, cached := a.lookup(key)
+	if !cached {
+		claims, err = a.introspect(ctx, token)
+		if err != nil {
+			return nil, "", err
+		}
+
+		a.store(key, claims)
+	}
+
+	if claims == nil {
+		return nil, "", fmt.Errorf("token is not active")
+	}
+
+	issuer, _ := claims["iss"].(string)
+
+	return claims, issuer, nil
+}
+
+// ReadsForm reports if one of the token sources is a form field
+func (a *IntrospectionAuthenticator) ReadsForm() bool {
+	return a.tokens.readsForm()
+}
+
+func (a *IntrospectionAuthenticator) lookup(key [sha256.Size]byte) (map[string]any, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result, found := a.cache[key]
+	if !found {
+		return nil, false
+	}
+
+	if time.Now().After(result.expiresAt) {
+		delete(a.cache, key)
+		return nil, false
+	}
+
+	return result.claims, true
+}
+
+// store caches an introspection result, an active token is never cached beyond its expiration
+func (a *IntrospectionAuthenticator) store(key [sha256.Size]byte, claims map[string]any) {
+	now := time.Now()
+
+	expiresAt := now.Add(a.negativeTTL)
+	if claims != nil {
+		expiresAt = now.Add(a.ttl)
+
+		if exp, ok := claims["exp"].(float64); ok {
+			tokenExpiresAt := time.Unix(int64(exp), 0)
+			if tokenExpiresAt.Before(expiresAt) {
+				expiresAt = tokenExpiresAt
+			}
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.cache) >= introspectionCacheSweepSize {
+		for k, result := range a.cache {
+			if now.After(result.expiresAt) {
+				delete(a.cache, k)
+			}
+		}
+	}
+
+	a.cache[key] = introspectionResult{claims: claims, expiresAt: expiresAt}
+}
+
+// introspect posts the token to the introspection endpoint, and returns the response fields if the token is active
+func (a *IntrospectionAuthenticator) introspect(ctx context.Context, token string) (map[string]any, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, a.config.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create introspection request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	if a.config.ClientID != "" {
+		// client credentials are form encoded before basic authentication as defined in RFC 6749
+		request.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+	}
+
+	response, err := a.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not introspect token: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected introspection response status: %s", response.Status)
+	}
+
+	claims := map[string]any{}
+	err = json.NewDecoder(response.Body).Decode(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse introspection response: %w", err)
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		return nil, nil
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, nil
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,250 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kaancfidan/bouncer/models"
+	"github.com/kaancfidan/bouncer/services"
+)
+
+// introspectionServer is a stand-in introspection endpoint that answers with a preset response per token
+type introspectionServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	responses  map[string]map[string]any
+	statusCode int
+	calls      int
+}
+
+func newIntrospectionServer(t *testing.T, responses map[string]map[string]any) *introspectionServer {
+	s := &introspectionServer{responses: responses, statusCode: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.calls++
+
+		assert.Equal(t, http.MethodPost, r.Method)
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "bouncer" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if s.statusCode != http.StatusOK {
+			w.WriteHeader(s.statusCode)
+			return
+		}
+
+		response, found := s.responses[r.PostFormValue("token")]
+		if !found {
+			response = map[string]any{"active": false}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *introspectionServer) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls
+}
+
+func (s *introspectionServer) setStatusCode(statusCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statusCode = statusCode
+}
+
+func TestIntrospectionAuthenticator_Authenticate(t *testing.T) {
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	responses := map[string]map[string]any{
+		"active": {
+			"active":    true,
+			"iss":       "https://idp",
+			"sub":       "user",
+			"scope":     "read write",
+			"client_id": "app",
+			"exp":       exp,
+		},
+		"expired": {
+			"active": true,
+			"exp":    float64(time.Now().Add(-time.Minute).Unix()),
+		},
+		"inactive": {
+			"active": false,
+		},
+	}
+
+	tests := []struct {
+		name         string
+		clientSecret string
+		authHeader   string
+		want         map[string]any
+		wantIssuer   string
+		wantErr      bool
+	}{
+		{
+			name:         "active token",
+			clientSecret: "secret",
+			authHeader:   "Bearer active",
+			want:         responses["active"],
+			wantIssuer:   "https://idp",
+			wantErr:      false,
+		},
+		{
+			name:         "inactive token",
+			clientSecret: "secret",
+			authHeader:   "Bearer inactive",
+			wantErr:      true,
+		},
+		{
+			name:         "unknown token",
+			clientSecret: "secret",
+			authHeader:   "Bearer unknown",
+			wantErr:      true,
+		},
+		{
+			name:         "expired token",
+			clientSecret: "secret",
+			authHeader:   "Bearer expired",
+			wantErr:      true,
+		},
+		{
+			name:         "invalid auth header",
+			clientSecret: "secret",
+			authHeader:   "active",
+			wantErr:      true,
+		},
+		{
+			name:         "invalid client credentials",
+			clientSecret: "wrong",
+			authHeader:   "Bearer active",
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newIntrospectionServer(t, responses)
+
+			a, err := services.NewIntrospectionAuthenticator(models.IntrospectionConfig{
+				URL:          server.URL,
+				ClientID:     "bouncer",
+				ClientSecret: tt.clientSecret,
+			}, nil)
+			assert.Nil(t, err)
+
+			got, issuer, err := a.Authenticate(context.Background(), authorizationRequest(tt.authHeader))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantIssuer, issuer)
+		})
+	}
+}
+
+func TestIntrospectionAuthenticator_Authenticate_Cache(t *testing.T) {
+	t.Run("active tokens are cached", func(t *testing.T) {
+		server := newIntrospectionServer(t, map[string]map[string]any{
+			"token": {"active": true, "exp": float64(time.Now().Add(time.Hour).Unix())},
+		})
+
+		a := newIntrospectionAuthenticator(t, server.URL)
+
+		for i := 0; i < 3; i++ {
+			_, _, err := a.Authenticate(context.Background(), authorizationRequest("Bearer token"))
+			assert.Nil(t, err)
+		}
+
+		assert.Equal(t, 1, server.callCount())
+	})
+
+	t.Run("inactive tokens are cached", func(t *testing.T) {
+		server := newIntrospectionServer(t, map[string]map[string]any{})
+
+		a := newIntrospectionAuthenticator(t, server.URL)
+
+		for i := 0; i < 3; i++ {
+			_, _, err := a.Authenticate(context.Background(), authorizationRequest("Bearer token"))
+			assert.NotNil(t, err)
+		}
+
+		assert.Equal(t, 1, server.callCount())
+	})
+
+	t.Run("cache is capped at token expiration", func(t *testing.T) {
+		server := newIntrospectionServer(t, map[string]map[string]any{
+			"token": {"active": true, "exp": float64(time.Now().Add(time.Second).Unix())},
+		})
+
+		a := newIntrospectionAuthenticator(t, server.URL)
+
+		_, _, err := a.Authenticate(context.Background(), authorizationRequest("Bearer token"))
+		assert.Nil(t, err)
+
+		time.Sleep(1100 * time.Millisecond)
+
+		_, _, err = a.Authenticate(context.Background(), authorizationRequest("Bearer token"))
+		assert.NotNil(t, err)
+
+		assert.Equal(t, 2, server.callCount())
+	})
+
+	t.Run("endpoint errors are not cached", func(t *testing.T) {
+		server := newIntrospectionServer(t, map[string]map[string]any{
+			"token": {"active": true},
+		})
+		server.setStatusCode(http.StatusServiceUnavailable)
+
+		a := newIntrospectionAuthenticator(t, server.URL)
+
+		_, _, err := a.Authenticate(context.Background(), authorizationRequest("Bearer token"))
+		assert.NotNil(t, err)
+
+		server.setStatusCode(http.StatusOK)
+
+		_, _, err = a.Authenticate(context.Background(), authorizationRequest("Bearer token"))
+		assert.Nil(t, err)
+
+		assert.Equal(t, 2, server.callCount())
+	})
+}
+
+func TestNewIntrospectionAuthenticator(t *testing.T) {
+	_, err := services.NewIntrospectionAuthenticator(models.IntrospectionConfig{}, nil)
+	assert.NotNil(t, err)
+}
+
+func newIntrospectionAuthenticator(t *testing.T, url string) *services.IntrospectionAuthenticator {
+	a, err := services.NewIntrospectionAuthenticator(models.IntrospectionConfig{
+		URL:               url,
+		ClientID:          "bouncer",
+		ClientSecret:      "secret",
+		CacheTTLInSeconds: 3600,
+	}, nil)
+	assert.Nil(t, err)
+
+	return a
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+const (
+	defaultJWKSMinRefreshInterval = 5 * time.Minute
+	maxJWKSRefreshWindow          = 15 * time.Minute
+	jwksFetchTimeout              = 10 * time.Second
+)
+
+// jwksKeyProvider feeds the keys of a remote JWK Set to the JWS verifier.
+//
+// The set is cached and refreshed in the background. If a refresh fails, the last successfully fetched set is kept.
+// Tokens with unknown key IDs trigger an early refresh, at most once per minimum refresh interval.
+type jwksKeyProvider struct {
+	cache              *jwk.Cache
+	registerOptions    []jwk.RegisterOption
+	minRefreshInterval time.Duration
+
+	mu   sync.RWMutex
+	url  string
+	algs []jwa.SignatureAlgorithm
+
+	refreshMu   sync.Mutex
+	lastRefresh time.Time
+}
+
+// newJWKSKeyProvider registers the JWKS URL to a background refreshing cache and fetches the key set once.
+// A zero refresh interval means the interval is derived from the cache headers of the JWKS response.
+func newJWKSKeyProvider(
+	ctx context.Context,
+	url string,
+	algs []jwa.SignatureAlgorithm,
+	refreshInterval time.Duration,
+	minRefreshInterval time.Duration) (*jwksKeyProvider, error) {
+
+	if minRefreshInterval <= 0 {
+		minRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+
+	// the cache checks for due refreshes once every window, so the window cannot be longer than the intervals
+	window := maxJWKSRefreshWindow
+	if minRefreshInterval < window {
+		window = minRefreshInterval
+	}
+	if refreshInterval > 0 && refreshInterval < window {
+		window = refreshInterval
+	}
+
+	options := []jwk.RegisterOption{
+		jwk.WithMinRefreshInterval(minRefreshInterval),
+		jwk.WithHTTPClient(jwksHTTPClient{client: &http.Client{Timeout: jwksFetchTimeout}}),
+		jwk.WithPostFetcher(jwk.PostFetchFunc(func(_ string, keys jwk.Set) (jwk.Set, error) {
+			// an empty set would invalidate all tokens, keep the last one instead
+			if keys.Len() == 0 {
+				return nil, fmt.Errorf("JWKS does not contain any keys")
+			}
+			return keys, nil
+		})),
+	}
+	if refreshInterval > 0 {
+		options = append(options, jwk.WithRefreshInterval(refreshInterval))
+	}
+
+	p := &jwksKeyProvider{
+		cache: jwk.NewCache(ctx,
+			jwk.WithRefreshWindow(window),
+			jwk.WithErrSink(jwksErrSink{})),
+		registerOptions:    options,
+		minRefreshInterval: minRefreshInterval,
+	}
+
+	err := p.update(ctx, url, algs)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// update switches the provider to a new JWKS URL and algorithm allowlist.
+// A new URL is fetched before being switched to, so that a failing URL never replaces a working one.
+func (p *jwksKeyProvider) update(ctx context.Context, url string, algs []jwa.SignatureAlgorithm) error {
+	if !p.cache.IsRegistered(url) {
+		err := p.cache.Register(url, p.registerOptions...)
+		if err != nil {
+			return fmt.Errorf("could not register JWKS URL: %w", err)
+		}
+
+		_, err = p.cache.Refresh(ctx, url)
+		if err != nil {
+			_ = p.cache.Unregister(url)
+			return fmt.Errorf("could not fetch JWKS: %w", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.url != "" && p.url != url {
+		_ = p.cache.Unregister(p.url)
+	}
+
+	p.url = url
+	p.algs = algs
+
+	return nil
+}
+
+// FetchKeys implements jws.KeyProvider.
+// If the token names a key ID, only that key is provided, otherwise all keys that fit the algorithm are tried.
+func (p *jwksKeyProvider) FetchKeys(ctx context.Context, sink jws.KeySink, sig *jws.Signature, _ *jws.Message) error {
+	p.mu.RLock()
+	url, algs := p.url, p.algs
+	p.mu.RUnlock()
+
+	alg := sig.ProtectedHeaders().Algorithm()
+	if !containsAlgorithm(algs, alg) {
+		return fmt.Errorf("unexpected signing algorithm: %s", alg)
+	}
+
+	keys, err := p.cache.Get(ctx, url)
+	if err != nil {
+		return fmt.Errorf("could not get JWKS: %w", err)
+	}
+
+	kid := sig.ProtectedHeaders().KeyID()
+	if kid == "" {
+		for i := 0; i < keys.Len(); i++ {
+			key, _ := keys.Key(i)
+			if checkKeyAlgorithm(key, alg) == nil {
+				sink.Key(alg, key)
+			}
+		}
+
+		return nil
+	}
+
+	key, found := keys.LookupKeyID(kid)
+	if !found {
+		keys, err = p.refresh(ctx, url)
+		if err != nil {
+			return fmt.Errorf("no key found with key ID %s: %w", kid, err)
+		}
+
+		key, found = keys.LookupKeyID(kid)
+		if !found {
+			return fmt.Errorf("no key found with key ID: %s", kid)
+		}
+	}
+
+	err = checkKeyAlgorithm(key, alg)
+	if err != nil {
+		return fmt.Errorf("key with key ID %s cannot be used with %s: %w", kid, alg, err)
+	}
+
+	sink.Key(alg, key)
+	return nil
+}
+
+// refresh fetches the key set out of schedule, unless the last out of schedule fetch was too recent
+func (p *jwksKeyProvider) refresh(ctx context.Context, url string) (jwk.Set, error) {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	if time.Since(p.lastRefresh) < p.minRefreshInterval {
+		return nil, fmt.Errorf("JWKS was refreshed less than %v ago", p.minRefreshInterval)
+	}
+
+	p.lastRefresh = time.Now()
+
+	keys, err := p.cache.Refresh(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("could not refresh JWKS: %w", err)
+	}
+
+	return keys, nil
+}
+
+// jwksErrSink logs the errors of background JWKS refreshes
+type jwksErrSink struct{}
+
+func (jwksErrSink) Error(err error) {
+	log.Printf("JWKS refresh failed, keeping the last fetched key set: %v", err)
+}
+
+// jwksHTTPClient fails unsuccessful responses, so that error pages never replace the cached key set
+type jwksHTTPClient struct {
+	client *http.Client
+}
+
+func (c jwksHTTPClient) Get(url string) (*http.Response, error) {
+	response, err := c.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		_ = response.Body.Close()
+		return nil, fmt.Errorf("unexpected JWKS response status: %s", response.Status)
+	}
+
+	return response, nil
+}
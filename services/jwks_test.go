@@ -0,0 +1,238 @@
+package services_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kaancfidan/bouncer/models"
+	"github.com/kaancfidan/bouncer/services"
+)
+
+// jwksServer is a stand-in JWKS endpoint with a replaceable key set
+type jwksServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	jwks       string
+	statusCode int
+	fetchCount int
+}
+
+func newJWKSServer(jwks string) *jwksServer {
+	s := &jwksServer{jwks: jwks, statusCode: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.fetchCount++
+		w.WriteHeader(s.statusCode)
+		_, _ = w.Write([]byte(s.jwks))
+	}))
+
+	return s
+}
+
+func (s *jwksServer) set(jwks string, statusCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jwks = jwks
+	s.statusCode = statusCode
+}
+
+func (s *jwksServer) fetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fetchCount
+}
+
+func TestAuthenticatorImpl_Authenticate_JWKS(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	oldJWKS := `{"keys":[` + string(publicKeyJSON(t, &oldKey.PublicKey, "old")) + `]}`
+	rotatedJWKS := `{"keys":[` +
+		string(publicKeyJSON(t, &oldKey.PublicKey, "old")) + "," +
+		string(publicKeyJSON(t, &newKey.PublicKey, "new")) + `]}`
+
+	t.Run("known kid", func(t *testing.T) {
+		server := newJWKSServer(oldJWKS)
+		defer server.Close()
+
+		a := newJWKSAuthenticator(t, server.URL)
+
+		_, _, err := a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, oldKey, "old")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, server.fetches())
+	})
+
+	t.Run("token without kid", func(t *testing.T) {
+		server := newJWKSServer(rotatedJWKS)
+		defer server.Close()
+
+		a := newJWKSAuthenticator(t, server.URL)
+
+		_, _, err := a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, newKey, "")))
+		assert.Nil(t, err)
+	})
+
+	t.Run("unknown kid triggers refresh after key rotation", func(t *testing.T) {
+		server := newJWKSServer(oldJWKS)
+		defer server.Close()
+
+		a := newJWKSAuthenticator(t, server.URL)
+		server.set(rotatedJWKS, http.StatusOK)
+
+		_, _, err := a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, newKey, "new")))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, server.fetches())
+	})
+
+	t.Run("unknown kid refreshes are rate limited", func(t *testing.T) {
+		server := newJWKSServer(oldJWKS)
+		defer server.Close()
+
+		a := newJWKSAuthenticator(t, server.URL)
+
+		_, _, err := a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, newKey, "new")))
+		assert.NotNil(t, err)
+
+		_, _, err = a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, newKey, "other")))
+		assert.NotNil(t, err)
+
+		assert.Equal(t, 2, server.fetches())
+	})
+
+	t.Run("last good key set is kept while the endpoint is down", func(t *testing.T) {
+		server := newJWKSServer(oldJWKS)
+		defer server.Close()
+
+		a := newJWKSAuthenticator(t, server.URL)
+		server.set("internal error", http.StatusInternalServerError)
+
+		_, _, err := a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, newKey, "new")))
+		assert.NotNil(t, err)
+
+		_, _, err = a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, oldKey, "old")))
+		assert.Nil(t, err)
+	})
+
+	t.Run("empty key set is not accepted", func(t *testing.T) {
+		server := newJWKSServer(oldJWKS)
+		defer server.Close()
+
+		a := newJWKSAuthenticator(t, server.URL)
+		server.set(`{"keys":[]}`, http.StatusOK)
+
+		_, _, err := a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, newKey, "new")))
+		assert.NotNil(t, err)
+
+		_, _, err = a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.RS256, oldKey, "old")))
+		assert.Nil(t, err)
+	})
+
+	t.Run("unexpected algorithm", func(t *testing.T) {
+		server := newJWKSServer(oldJWKS)
+		defer server.Close()
+
+		a := newJWKSAuthenticator(t, server.URL)
+
+		_, _, err := a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestToken(t, jwa.PS256, oldKey, "old")))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNewAuthenticator_JWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	jwks := `{"keys":[` + string(publicKeyJSON(t, &key.PublicKey, "kid")) + `]}`
+
+	tests := []struct {
+		name       string
+		signingKey []byte
+		jwks       string
+		statusCode int
+		wantErr    bool
+	}{
+		{
+			name:       "happy path",
+			jwks:       jwks,
+			statusCode: http.StatusOK,
+			wantErr:    false,
+		},
+		{
+			name:       "endpoint down",
+			jwks:       jwks,
+			statusCode: http.StatusServiceUnavailable,
+			wantErr:    true,
+		},
+		{
+			name:       "invalid key set",
+			jwks:       "not a key set",
+			statusCode: http.StatusOK,
+			wantErr:    true,
+		},
+		{
+			name:       "both signing key and jwks url",
+			signingKey: publicKeyPEM(t, &key.PublicKey),
+			jwks:       jwks,
+			statusCode: http.StatusOK,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newJWKSServer(tt.jwks)
+			defer server.Close()
+			server.set(tt.jwks, tt.statusCode)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, err := services.NewAuthenticator(ctx, tt.signingKey, "RS256",
+				models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{JWKSURL: server.URL}})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAuthenticator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func newJWKSAuthenticator(t *testing.T, url string) *services.AuthenticatorImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	a, err := services.NewAuthenticator(ctx, nil, "RS256", models.AuthenticationConfig{
+		IssuerConfig: models.IssuerConfig{
+			JWKSURL:                         url,
+			JWKSMinRefreshIntervalInSeconds: 3600,
+		},
+	})
+	assert.Nil(t, err)
+
+	return a
+}
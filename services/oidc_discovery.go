@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+)
+
+const (
+	oidcWellKnownPath        = "/.well-known/openid-configuration"
+	defaultDiscoveryInterval = time.Hour
+	discoveryFetchTimeout    = 10 * time.Second
+)
+
+// oidcProviderMetadata is the subset of the OpenID Connect discovery document used for token validation
+type oidcProviderMetadata struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// oidcDiscovery keeps the issuer, JWKS URL and accepted algorithms in sync with an OpenID Connect provider.
+//
+// The discovery document is fetched periodically. If a re-discovery fails, the last discovered values are kept.
+type oidcDiscovery struct {
+	url            string
+	expectedIssuer string
+	configuredAlgs []jwa.SignatureAlgorithm
+	client         *http.Client
+
+	keyProvider *jwksKeyProvider
+
+	mu     sync.RWMutex
+	issuer string
+}
+
+// newOIDCDiscovery fetches the discovery document once and keeps re-discovering in the background until the context
+// is cancelled.
+//
+// If an issuer is expected, the discovered issuer must match it.
+// If algorithms are configured, they take precedence over the discovered ones.
+func newOIDCDiscovery(
+	ctx context.Context,
+	discoveryURL string,
+	expectedIssuer string,
+	configuredAlgs []jwa.SignatureAlgorithm,
+	interval time.Duration,
+	jwksRefreshInterval time.Duration,
+	jwksMinRefreshInterval time.Duration) (*oidcDiscovery, error) {
+
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+
+	d := &oidcDiscovery{
+		url:            discoveryURL,
+		expectedIssuer: expectedIssuer,
+		configuredAlgs: configuredAlgs,
+		client:         &http.Client{Timeout: discoveryFetchTimeout},
+	}
+
+	metadata, algs, err := d.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d.keyProvider, err = newJWKSKeyProvider(ctx, metadata.JWKSURI, algs, jwksRefreshInterval, jwksMinRefreshInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	d.issuer = metadata.Issuer
+
+	go d.run(ctx, interval)
+
+	return d, nil
+}
+
+// wellKnownURL returns the standard discovery document location of an issuer
+func wellKnownURL(issuer string) string {
+	return strings.TrimSuffix(issuer, "/") + oidcWellKnownPath
+}
+
+// Issuer returns the last discovered issuer
+func (d *oidcDiscovery) Issuer() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.issuer
+}
+
+func (d *oidcDiscovery) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := d.rediscover(ctx)
+			if err != nil {
+				log.Printf("OpenID Connect discovery failed, keeping the last discovered configuration: %v", err)
+			}
+		}
+	}
+}
+
+func (d *oidcDiscovery) rediscover(ctx context.Context) error {
+	metadata, algs, err := d.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = d.keyProvider.update(ctx, metadata.JWKSURI, algs)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.issuer = metadata.Issuer
+
+	return nil
+}
+
+// fetch downloads and validates the discovery document, and returns it with the algorithms to accept
+func (d *oidcDiscovery) fetch(ctx context.Context) (*oidcProviderMetadata, []jwa.SignatureAlgorithm, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create discovery request: %w", err)
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not fetch discovery document: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected discovery response status: %s", response.Status)
+	}
+
+	metadata := oidcProviderMetadata{}
+	err = json.NewDecoder(response.Body).Decode(&metadata)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse discovery document: %w", err)
+	}
+
+	if metadata.Issuer == "" {
+		return nil, nil, fmt.Errorf("discovery document does not name an issuer")
+	}
+
+	if d.expectedIssuer != "" && metadata.Issuer != d.expectedIssuer {
+		return nil, nil, fmt.Errorf("discovered issuer %s does not match the configured issuer %s",
+			metadata.Issuer, d.expectedIssuer)
+	}
+
+	jwksURL, err := url.Parse(metadata.JWKSURI)
+	if err != nil || (jwksURL.Scheme != "http" && jwksURL.Scheme != "https") {
+		return nil, nil, fmt.Errorf("discovery document has an invalid jwks_uri: %q", metadata.JWKSURI)
+	}
+
+	if d.configuredAlgs != nil {
+		return &metadata, d.configuredAlgs, nil
+	}
+
+	// RS256 is the default ID token signing algorithm in OpenID Connect
+	algNames := metadata.IDTokenSigningAlgValuesSupported
+	if len(algNames) == 0 {
+		algNames = []string{jwa.RS256.String()}
+	}
+
+	var algs []jwa.SignatureAlgorithm
+	for _, name := range algNames {
+		alg, err := parseSigningAlgorithm(name)
+		if err != nil {
+			// "none" and algorithms unknown to us are never accepted
+			continue
+		}
+
+		algs = append(algs, alg)
+	}
+
+	if algs == nil {
+		return nil, nil, fmt.Errorf("none of the discovered signing algorithms are supported: %v", algNames)
+	}
+
+	return &metadata, algs, nil
+}
@@ -0,0 +1,265 @@
+package services_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kaancfidan/bouncer/models"
+	"github.com/kaancfidan/bouncer/services"
+)
+
+// oidcProvider is a stand-in OpenID Connect provider serving a discovery document and two JWKS locations
+type oidcProvider struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	metadata map[string]any
+	jwks     map[string]string
+}
+
+func newOIDCProvider(t *testing.T) *oidcProvider {
+	p := &oidcProvider{jwks: map[string]string{}}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if r.URL.Path == "/.well-known/openid-configuration" {
+			if p.metadata == nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			assert.Nil(t, json.NewEncoder(w).Encode(p.metadata))
+			return
+		}
+
+		jwks, ok := p.jwks[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(jwks))
+	}))
+
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func (p *oidcProvider) set(metadata map[string]any, jwks map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metadata = metadata
+	p.jwks = jwks
+}
+
+func TestAuthenticatorImpl_Authenticate_OIDCDiscovery(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	rotatedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	provider := newOIDCProvider(t)
+	provider.set(map[string]any{
+		"issuer":                                provider.URL,
+		"jwks_uri":                              provider.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256", "none"},
+	}, map[string]string{
+		"/jwks": `{"keys":[` + string(publicKeyJSON(t, &key.PublicKey, "key")) + `]}`,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, err := services.NewAuthenticator(ctx, nil, "", models.AuthenticationConfig{
+		IssuerConfig: models.IssuerConfig{
+			Issuer:                     provider.URL,
+			Discover:                   true,
+			DiscoveryIntervalInSeconds: 1,
+		},
+	})
+	assert.Nil(t, err)
+
+	_, _, err = a.Authenticate(context.Background(),
+		authorizationRequest("Bearer "+signTestTokenWithClaims(t, jwa.RS256, key, "key",
+			map[string]any{"iss": provider.URL})))
+	assert.Nil(t, err, "discovered issuer and key")
+
+	_, _, err = a.Authenticate(context.Background(),
+		authorizationRequest("Bearer "+signTestTokenWithClaims(t, jwa.RS256, key, "key",
+			map[string]any{"iss": "https://some.other/issuer"})))
+	assert.NotNil(t, err, "issuer mismatch")
+
+	_, _, err = a.Authenticate(context.Background(),
+		authorizationRequest("Bearer "+signTestTokenWithClaims(t, jwa.PS256, key, "key",
+			map[string]any{"iss": provider.URL})))
+	assert.NotNil(t, err, "algorithm not discovered")
+
+	// move keys to a new JWKS location, which should be picked up by re-discovery
+	provider.set(map[string]any{
+		"issuer":   provider.URL,
+		"jwks_uri": provider.URL + "/rotated-jwks",
+	}, map[string]string{
+		"/rotated-jwks": `{"keys":[` + string(publicKeyJSON(t, &rotatedKey.PublicKey, "rotated")) + `]}`,
+	})
+
+	assert.Eventually(t, func() bool {
+		_, _, err := a.Authenticate(context.Background(),
+			authorizationRequest("Bearer "+signTestTokenWithClaims(t, jwa.RS256, rotatedKey, "",
+				map[string]any{"iss": provider.URL})))
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond, "re-discovered JWKS location")
+
+	// the provider going down should not affect the discovered configuration
+	provider.set(nil, map[string]string{
+		"/rotated-jwks": `{"keys":[` + string(publicKeyJSON(t, &rotatedKey.PublicKey, "rotated")) + `]}`,
+	})
+	time.Sleep(1500 * time.Millisecond)
+
+	_, _, err = a.Authenticate(context.Background(),
+		authorizationRequest("Bearer "+signTestTokenWithClaims(t, jwa.RS256, rotatedKey, "rotated",
+			map[string]any{"iss": provider.URL})))
+	assert.Nil(t, err, "last discovered configuration is kept")
+}
+
+func TestNewAuthenticator_OIDCDiscovery(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	jwks := map[string]string{
+		"/jwks": `{"keys":[` + string(publicKeyJSON(t, &key.PublicKey, "key")) + `]}`,
+	}
+
+	tests := []struct {
+		name       string
+		metadata   func(url string) map[string]any
+		signingKey []byte
+		signingAlg string
+		cfg        func(url string) models.AuthenticationConfig
+		wantErr    bool
+	}{
+		{
+			name: "discovery url",
+			metadata: func(url string) map[string]any {
+				return map[string]any{"issuer": url, "jwks_uri": url + "/jwks"}
+			},
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{OIDCDiscoveryURL: url + "/.well-known/openid-configuration"}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "configured algorithm overrides discovery",
+			metadata: func(url string) map[string]any {
+				return map[string]any{"issuer": url, "jwks_uri": url + "/jwks"}
+			},
+			signingAlg: "PS256",
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{Issuer: url, Discover: true}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "discover without issuer",
+			metadata: func(url string) map[string]any {
+				return map[string]any{"issuer": url, "jwks_uri": url + "/jwks"}
+			},
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{Discover: true}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "discovery endpoint down",
+			metadata: func(url string) map[string]any {
+				return nil
+			},
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{Issuer: url, Discover: true}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "discovered issuer mismatch",
+			metadata: func(url string) map[string]any {
+				return map[string]any{"issuer": "https://some.other/issuer", "jwks_uri": url + "/jwks"}
+			},
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{Issuer: url, Discover: true}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing jwks uri",
+			metadata: func(url string) map[string]any {
+				return map[string]any{"issuer": url}
+			},
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{Issuer: url, Discover: true}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported algorithms only",
+			metadata: func(url string) map[string]any {
+				return map[string]any{
+					"issuer":                                url,
+					"jwks_uri":                              url + "/jwks",
+					"id_token_signing_alg_values_supported": []string{"none"},
+				}
+			},
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{Issuer: url, Discover: true}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "discovery with signing key",
+			metadata: func(url string) map[string]any {
+				return map[string]any{"issuer": url, "jwks_uri": url + "/jwks"}
+			},
+			signingKey: publicKeyPEM(t, &key.PublicKey),
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{Issuer: url, Discover: true}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "discovery with jwks url",
+			metadata: func(url string) map[string]any {
+				return map[string]any{"issuer": url, "jwks_uri": url + "/jwks"}
+			},
+			cfg: func(url string) models.AuthenticationConfig {
+				return models.AuthenticationConfig{IssuerConfig: models.IssuerConfig{Issuer: url, Discover: true, JWKSURL: url + "/jwks"}}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newOIDCProvider(t)
+			provider.set(tt.metadata(provider.URL), jwks)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, err := services.NewAuthenticator(ctx, tt.signingKey, tt.signingAlg, tt.cfg(provider.URL))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAuthenticator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
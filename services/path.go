@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// encoded slash policies of path canonicalization
+const (
+	encodedSlashesReject = "reject"
+	encodedSlashesDecode = "decode"
+)
+
+// canonicalPath returns the canonical form of an escaped request path, which is authorized and forwarded upstream.
+// Matrix parameters are stripped if configured, then percent-encodings are decoded, dot segments are removed and
+// duplicate slashes are collapsed. A trailing slash is kept.
+//
+// Encoded slashes are rejected unless configured to be decoded as separators,
+// since upstreams disagree on whether they separate segments.
+func canonicalPath(escapedPath string, cfg models.PathConfig) (string, error) {
+	if cfg.StripMatrixParams {
+		escapedPath = stripMatrixParams(escapedPath)
+	}
+
+	if cfg.EncodedSlashes != encodedSlashesDecode && strings.Contains(strings.ToUpper(escapedPath), "%2F") {
+		return "", fmt.Errorf("encoded slash in path %s", escapedPath)
+	}
+
+	decoded, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return "", fmt.Errorf("could not decode path: %w", err)
+	}
+
+	if strings.ContainsRune(decoded, 0) {
+		return "", fmt.Errorf("null character in path %s", escapedPath)
+	}
+
+	canonical := path.Clean("/" + decoded)
+	if strings.HasSuffix(decoded, "/") && canonical != "/" {
+		canonical += "/"
+	}
+
+	return canonical, nil
+}
+
+// isCanonicalPath checks that the segments of an escaped path, once decoded, are those of its canonical path.
+// Percent-encodings are compared decoded, so /caf%c3%a9 and /a(1)!.txt are canonical however they are escaped,
+// while dot segments, duplicate slashes, stripped matrix parameters and decoded encoded slashes are not.
+func isCanonicalPath(escapedPath string, canonical string) bool {
+	if escapedPath == "" {
+		return canonical == "/"
+	}
+
+	segments := strings.Split(escapedPath, "/")
+	canonicalSegments := strings.Split(canonical, "/")
+
+	if len(segments) != len(canonicalSegments) {
+		return false
+	}
+
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil || decoded != canonicalSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stripMatrixParams drops the ;name=value parameters of the segments of an escaped path
+func stripMatrixParams(escapedPath string) string {
+	segments := strings.Split(escapedPath, "/")
+	for i, segment := range segments {
+		segments[i], _, _ = strings.Cut(segment, ";")
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// foldPath returns the path to match route policies against, lower case if paths are case insensitive
+func foldPath(canonical string, cfg models.PathConfig) string {
+	if cfg.CaseInsensitive {
+		return strings.ToLower(canonical)
+	}
+
+	return canonical
+}
+
+// validatePathConfig checks the encoded slash policy of path canonicalization
+func validatePathConfig(cfg models.PathConfig) error {
+	switch cfg.EncodedSlashes {
+	case "", encodedSlashesReject, encodedSlashesDecode:
+		return nil
+	}
+
+	return fmt.Errorf("encoded slashes must be %s or %s: %s", encodedSlashesReject, encodedSlashesDecode,
+		cfg.EncodedSlashes)
+}
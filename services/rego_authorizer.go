@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// defaultRegoTimeout bounds the evaluation of each query if no timeout is configured
+const defaultRegoTimeout = time.Second
+
+// RegoAuthorizer implements claims based authorization with the queries of a Rego bundle.
+// Route policies without a Rego query, and the checks that do not depend on claim policies,
+// are delegated to the next authorizer.
+type RegoAuthorizer struct {
+	next    Authorizer
+	queries map[string]rego.PreparedEvalQuery
+	timeout time.Duration
+}
+
+// NewRegoAuthorizer loads and compiles the Rego bundle once, then prepares the queries of the route policies
+// against the compiled policies and the data of the bundle
+func NewRegoAuthorizer(
+	ctx context.Context,
+	cfg models.RegoConfig,
+	routePolicies []models.RoutePolicy,
+	next Authorizer) (*RegoAuthorizer, error) {
+
+	a := &RegoAuthorizer{
+		next:    next,
+		queries: make(map[string]rego.PreparedEvalQuery),
+		timeout: time.Duration(cfg.TimeoutInMilliseconds) * time.Millisecond,
+	}
+
+	if a.timeout == 0 {
+		a.timeout = defaultRegoTimeout
+	}
+
+	b, err := loader.NewFileLoader().AsBundle(cfg.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rego bundle %s: %w", cfg.Bundle, err)
+	}
+
+	modules := make(map[string]*ast.Module, len(b.Modules))
+	for _, m := range b.Modules {
+		modules[m.Path] = m.Parsed
+	}
+
+	compiler := ast.NewCompiler()
+	if compiler.Compile(modules); compiler.Failed() {
+		return nil, fmt.Errorf("failed to compile rego bundle %s: %w", cfg.Bundle, compiler.Errors)
+	}
+
+	store := inmem.NewFromObject(b.Data)
+
+	for _, rp := range routePolicies {
+		if rp.RegoQuery == "" {
+			continue
+		}
+
+		if _, exists := a.queries[rp.RegoQuery]; exists {
+			continue
+		}
+
+		query, err := rego.New(
+			rego.Query(rp.RegoQuery),
+			rego.Compiler(compiler),
+			rego.Store(store),
+		).PrepareForEval(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare rego query %s: %w", rp.RegoQuery, err)
+		}
+
+		a.queries[rp.RegoQuery] = query
+	}
+
+	return a, nil
+}
+
+// Authorize checks the matched route policies with the next authorizer, then evaluates the Rego queries of
+// the effective route policies, and adds their results to the decision.
+//
+// Queries are evaluated with an input document of the claims, request method, path, the path parameters
+// captured by the route policy of the query and the matched route policies. A query passes only if it evaluates to true.
+// Queries that do not complete within the timeout, or before the request context is done, fail the authorization
+// with an error.
+//
+// This function expects the matchedPolicies to be sorted by decreasing specificity.
+func (a RegoAuthorizer) Authorize(
+	ctx context.Context,
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) (models.Decision, error) {
+
+	decision, err := a.next.Authorize(ctx, matchedPolicies, request, claims)
+	if err != nil {
+		return decision, err
+	}
+
+	start := time.Now()
+
+	evaluated := make(map[string]bool)
+
+	for _, rp := range effectivePolicies(matchedPolicies) {
+		key := checkedKey(rp.RegoQuery, rp.PathParams)
+		if rp.RegoQuery == "" || evaluated[key] {
+			continue
+		}
+
+		evaluated[key] = true
+
+		query, exists := a.queries[rp.RegoQuery]
+		if !exists {
+			return models.Decision{}, fmt.Errorf("missing rego query: %s", rp.RegoQuery)
+		}
+
+		input := regoInput(matchedPolicies, routeRequest(rp, request), claims)
+
+		ctx, cancel := context.WithTimeout(ctx, a.timeout)
+		results, err := query.Eval(ctx, rego.EvalInput(input))
+		cancel()
+
+		if err != nil {
+			return models.Decision{}, fmt.Errorf("failed to evaluate rego query %s: %w", rp.RegoQuery, err)
+		}
+
+		decision.Record(models.RequirementResult{
+			RoutePolicy: rp.Path,
+			Requirement: rp.RegoQuery,
+			Passed:      results.Allowed(),
+		})
+	}
+
+	decision.Duration += time.Since(start)
+
+	return decision, nil
+}
+
+// IsAnonymousAllowed delegates to the next authorizer, routes with Rego queries never allow anonymous requests
+func (a RegoAuthorizer) IsAnonymousAllowed(matchedPolicies []models.RoutePolicy, method string) bool {
+	return a.next.IsAnonymousAllowed(matchedPolicies, method)
+}
+
+// CheckDenyPolicies delegates to the next authorizer, deny route policies cannot have Rego queries
+func (a RegoAuthorizer) CheckDenyPolicies(
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) (deniedBy string, err error) {
+
+	return a.next.CheckDenyPolicies(matchedPolicies, request, claims)
+}
+
+// CheckTokenRequirements delegates to the next authorizer
+func (a RegoAuthorizer) CheckTokenRequirements(
+	matchedPolicies []models.RoutePolicy,
+	issuer string,
+	claims map[string]any) (failedRequirement string) {
+
+	return a.next.CheckTokenRequirements(matchedPolicies, issuer, claims)
+}
+
+// regoInput builds the input document of Rego queries
+func regoInput(
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) map[string]any {
+
+	if claims == nil {
+		claims = map[string]any{}
+	}
+
+	routes := make([]any, len(matchedPolicies))
+	for i, rp := range matchedPolicies {
+		methods := make([]any, len(rp.Methods))
+		for j, m := range rp.Methods {
+			methods[j] = m
+		}
+
+		names := routePolicyNames(rp)
+		policyNames := make([]any, len(names))
+		for j, n := range names {
+			policyNames[j] = n
+		}
+
+		routes[i] = map[string]any{
+			"path":        rp.Path,
+			"methods":     methods,
+			"policyNames": policyNames,
+			"regoQuery":   rp.RegoQuery,
+		}
+	}
+
+	input := map[string]any{
+		"claims":        claims,
+		"method":        "",
+		"path":          "",
+		"params":        map[string]any{},
+		"routePolicies": routes,
+	}
+
+	if request != nil {
+		input["method"] = request.Method
+		input["path"] = request.Path
+
+		params := make(map[string]any, len(request.PathParams))
+		for k, v := range request.PathParams {
+			params[k] = v
+		}
+
+		input["params"] = params
+	}
+
+	return input
+}
+
+// validateRego checks that the Rego bundle is configured if route policies have Rego queries,
+// the timeout is not negative, and the queries are references to documents under data
+func validateRego(cfg *models.RegoConfig, routePolicies models.RoutePolicyConfig) error {
+	if cfg != nil && cfg.Bundle == "" {
+		return fmt.Errorf("rego bundle path cannot be empty")
+	}
+
+	if cfg != nil && cfg.TimeoutInMilliseconds < 0 {
+		return fmt.Errorf("rego timeout cannot be negative")
+	}
+
+	for _, rp := range routePolicies {
+		if rp.RegoQuery == "" {
+			continue
+		}
+
+		if cfg == nil {
+			return fmt.Errorf("route policy %s has a rego query without a rego bundle configured", rp.Path)
+		}
+
+		ref, err := ast.ParseRef(rp.RegoQuery)
+		if err != nil {
+			return fmt.Errorf("invalid rego query %s: %w", rp.RegoQuery, err)
+		}
+
+		if !ref.HasPrefix(ast.DefaultRootRef) {
+			return fmt.Errorf("rego query %s must refer to a document under data", rp.RegoQuery)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package services_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kaancfidan/bouncer/mocks"
+	"github.com/kaancfidan/bouncer/models"
+	"github.com/kaancfidan/bouncer/services"
+)
+
+const ordersRego = `package orders
+
+default allow = false
+
+allow {
+	input.method == "GET"
+	input.claims.department == data.orders.departments[_]
+}
+
+allow {
+	input.claims.sub == input.params.customer
+}
+
+owned {
+	input.routePolicies[_].policyNames[_] == "Customer"
+}
+
+conflict = true { input.method == "GET" }
+conflict = false { input.method == "GET" }
+
+slow {
+	count([x | numbers.range(1, 5000)[i]; numbers.range(1, 5000)[j]; x := i + j]) > 0
+}
+`
+
+// nextDecision is the decision of the next authorizer, failing the given requirement if not empty
+func nextDecision(failedRequirement string) models.Decision {
+	decision := models.Decision{Allowed: true}
+	if failedRequirement != "" {
+		decision.Record(models.RequirementResult{Requirement: failedRequirement})
+	}
+
+	return decision
+}
+
+func newRegoBundle(t *testing.T) string {
+	dir := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(dir, "orders"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "orders", "policy.rego"), []byte(ordersRego), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "orders", "data.json"), []byte(`{"departments": ["sales"]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestNewRegoAuthorizer(t *testing.T) {
+	bundle := newRegoBundle(t)
+
+	tests := []struct {
+		name          string
+		bundle        string
+		routePolicies []models.RoutePolicy
+		wantErr       bool
+	}{
+		{
+			name:   "happy path",
+			bundle: bundle,
+			routePolicies: []models.RoutePolicy{
+				{Path: "/orders/**", RegoQuery: "data.orders.allow"},
+				{Path: "/orders/{customer}", RegoQuery: "data.orders.allow"},
+				{Path: "/**"},
+			},
+			wantErr: false,
+		},
+		{
+			name:          "missing bundle",
+			bundle:        filepath.Join(bundle, "missing"),
+			routePolicies: []models.RoutePolicy{{Path: "/orders/**", RegoQuery: "data.orders.allow"}},
+			wantErr:       true,
+		},
+		{
+			name:          "missing bundle without queries",
+			bundle:        filepath.Join(bundle, "missing"),
+			routePolicies: []models.RoutePolicy{{Path: "/**"}},
+			wantErr:       true,
+		},
+		{
+			name:          "invalid query",
+			bundle:        bundle,
+			routePolicies: []models.RoutePolicy{{Path: "/orders/**", RegoQuery: "data.orders.allow ==="}},
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := services.NewRegoAuthorizer(context.Background(), models.RegoConfig{Bundle: tt.bundle},
+				tt.routePolicies, &mocks.Authorizer{})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRegoAuthorizer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegoAuthorizer_Authorize(t *testing.T) {
+	routePolicies := []models.RoutePolicy{
+		{Path: "/orders/{customer}", RegoQuery: "data.orders.allow"},
+		{Path: "/owned/**", PolicyName: "Customer", RegoQuery: "data.orders.owned"},
+		{Path: "/conflict", RegoQuery: "data.orders.conflict"},
+		{Path: "/yaml/**", PolicyName: "Admin"},
+		{Path: "/undefined", RegoQuery: "data.orders.undefined"},
+	}
+
+	customerOrders := routePolicies[0]
+	customerOrders.PathParams = map[string]string{"customer": "42"}
+
+	tests := []struct {
+		name            string
+		matchedPolicies []models.RoutePolicy
+		request         *models.RequestContext
+		claims          map[string]any
+		nextFailedClaim string
+		wantFailedClaim string
+		wantErr         bool
+	}{
+		{
+			name:            "department allowed to read",
+			matchedPolicies: []models.RoutePolicy{customerOrders},
+			request:         &models.RequestContext{Method: "GET"},
+			claims:          map[string]any{"department": "sales"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "department not allowed to write",
+			matchedPolicies: []models.RoutePolicy{customerOrders},
+			request:         &models.RequestContext{Method: "POST"},
+			claims:          map[string]any{"department": "sales"},
+			wantFailedClaim: "data.orders.allow",
+		},
+		{
+			name:            "customer allowed to write own orders",
+			matchedPolicies: []models.RoutePolicy{customerOrders},
+			request:         &models.RequestContext{Method: "POST"},
+			claims:          map[string]any{"sub": "42"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "anonymous",
+			matchedPolicies: routePolicies[0:1],
+			request:         &models.RequestContext{Method: "POST"},
+			claims:          nil,
+			wantFailedClaim: "data.orders.allow",
+		},
+		{
+			name:            "input has matched route policies",
+			matchedPolicies: routePolicies[1:2],
+			request:         &models.RequestContext{Method: "GET"},
+			claims:          map[string]any{},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "claim policy fails before query",
+			matchedPolicies: routePolicies[1:2],
+			request:         &models.RequestContext{Method: "GET"},
+			claims:          map[string]any{},
+			nextFailedClaim: "customer_id",
+			wantFailedClaim: "customer_id",
+		},
+		{
+			name:            "yaml route only",
+			matchedPolicies: routePolicies[3:4],
+			request:         &models.RequestContext{Method: "GET"},
+			claims:          map[string]any{},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "undefined query",
+			matchedPolicies: routePolicies[4:5],
+			request:         &models.RequestContext{Method: "GET"},
+			claims:          map[string]any{},
+			wantFailedClaim: "data.orders.undefined",
+		},
+		{
+			name:            "evaluation error",
+			matchedPolicies: routePolicies[2:3],
+			request:         &models.RequestContext{Method: "GET"},
+			claims:          map[string]any{},
+			wantErr:         true,
+		},
+		{
+			name:            "unprepared query",
+			matchedPolicies: []models.RoutePolicy{{Path: "/other", RegoQuery: "data.other.allow"}},
+			request:         &models.RequestContext{Method: "GET"},
+			claims:          map[string]any{},
+			wantErr:         true,
+		},
+		{
+			name: "overridden query",
+			matchedPolicies: []models.RoutePolicy{
+				{Path: "/orders/{customer}/public", Override: true},
+				routePolicies[0],
+			},
+			request:         &models.RequestContext{Method: "POST"},
+			claims:          map[string]any{},
+			wantFailedClaim: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &mocks.Authorizer{}
+			next.On("Authorize", mock.Anything, tt.matchedPolicies, tt.request, tt.claims).Return(nextDecision(tt.nextFailedClaim), nil)
+
+			a, err := services.NewRegoAuthorizer(context.Background(),
+				models.RegoConfig{Bundle: newRegoBundle(t)}, routePolicies, next)
+			if err != nil {
+				t.Fatalf("NewRegoAuthorizer() error = %v", err)
+			}
+
+			decision, err := a.Authorize(context.Background(), tt.matchedPolicies, tt.request, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authorize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if failedClaim != tt.wantFailedClaim {
+				t.Errorf("Authorize() failedClaim = %v, want %v", failedClaim, tt.wantFailedClaim)
+			}
+
+			next.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRegoAuthorizer_Authorize_Timeout(t *testing.T) {
+	matchedPolicies := []models.RoutePolicy{{Path: "/slow", RegoQuery: "data.orders.slow"}}
+	request := &models.RequestContext{Method: "GET"}
+	claims := map[string]any{}
+
+	next := &mocks.Authorizer{}
+	next.On("Authorize", mock.Anything, matchedPolicies, request, claims).Return(nextDecision(""), nil)
+
+	a, err := services.NewRegoAuthorizer(context.Background(),
+		models.RegoConfig{Bundle: newRegoBundle(t), TimeoutInMilliseconds: 10}, matchedPolicies, next)
+	if err != nil {
+		t.Fatalf("NewRegoAuthorizer() error = %v", err)
+	}
+
+	start := time.Now()
+
+	_, err = a.Authorize(context.Background(), matchedPolicies, request, claims)
+	if err == nil {
+		t.Errorf("Authorize() error = nil, want timeout")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Authorize() took %v, want it to be cancelled after the timeout", elapsed)
+	}
+}
+
+func TestRegoAuthorizer_Authorize_Cancelled(t *testing.T) {
+	matchedPolicies := []models.RoutePolicy{{Path: "/slow", RegoQuery: "data.orders.slow"}}
+	request := &models.RequestContext{Method: "GET"}
+	claims := map[string]any{}
+
+	next := &mocks.Authorizer{}
+	next.On("Authorize", mock.Anything, matchedPolicies, request, claims).Return(nextDecision(""), nil)
+
+	a, err := services.NewRegoAuthorizer(context.Background(),
+		models.RegoConfig{Bundle: newRegoBundle(t), TimeoutInMilliseconds: 60000}, matchedPolicies, next)
+	if err != nil {
+		t.Fatalf("NewRegoAuthorizer() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err = a.Authorize(ctx, matchedPolicies, request, claims)
+	if err == nil {
+		t.Errorf("Authorize() error = nil, want cancellation")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Authorize() took %v, want it to be cancelled with the request context", elapsed)
+	}
+}
+
+func TestRegoAuthorizer_Delegates(t *testing.T) {
+	matchedPolicies := []models.RoutePolicy{{Path: "/**", AllowAnonymous: true}}
+	request := &models.RequestContext{Method: "GET"}
+	claims := map[string]any{"sub": "42"}
+
+	next := &mocks.Authorizer{}
+	next.On("IsAnonymousAllowed", matchedPolicies, "GET").Return(true)
+	next.On("CheckDenyPolicies", matchedPolicies, request, claims).Return("/**", nil)
+	next.On("CheckTokenRequirements", matchedPolicies, "issuer", claims).Return("aud")
+
+	a, err := services.NewRegoAuthorizer(context.Background(), models.RegoConfig{Bundle: newRegoBundle(t)}, nil, next)
+	if err != nil {
+		t.Fatalf("NewRegoAuthorizer() error = %v", err)
+	}
+
+	assert.True(t, a.IsAnonymousAllowed(matchedPolicies, "GET"))
+
+	deniedBy, err := a.CheckDenyPolicies(matchedPolicies, request, claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "/**", deniedBy)
+
+	assert.Equal(t, "aud", a.CheckTokenRequirements(matchedPolicies, "issuer", claims))
+
+	next.AssertExpectations(t)
+	next.AssertNotCalled(t, "Authorize", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
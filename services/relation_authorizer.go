@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// RelationAuthorizer implements relationship based authorization with the tuples of a tuple store.
+// Route policies without a relation requirement, and the checks that do not depend on claim policies,
+// are delegated to the next authorizer.
+type RelationAuthorizer struct {
+	next         Authorizer
+	checker      relationChecker
+	subjectType  string
+	subjectClaim string
+	subjectPath  claimPath
+}
+
+// NewRelationAuthorizer creates a new RelationAuthorizer instance
+func NewRelationAuthorizer(cfg models.RelationshipsConfig, store TupleStore, next Authorizer) *RelationAuthorizer {
+	a := &RelationAuthorizer{
+		next:         next,
+		checker:      relationChecker{schema: cfg.Schema, store: store},
+		subjectType:  cfg.SubjectType,
+		subjectClaim: cfg.SubjectClaim,
+	}
+
+	if a.subjectType == "" {
+		a.subjectType = defaultSubjectType
+	}
+
+	if a.subjectClaim == "" {
+		a.subjectClaim = defaultSubjectClaim
+	}
+
+	// a subject claim that is not a path leaves subjectPath nil, subject then only reads the claim by its exact name
+	a.subjectPath, _ = parseClaimPath(a.subjectClaim)
+
+	return a
+}
+
+// Authorize checks the matched route policies with the next authorizer, then checks the relation requirements of
+// the effective route policies, and adds their results to the decision.
+//
+// Relations are checked for the token subject, whose ID is read from the subject claim.
+// The object ID of a check is read from the path parameters captured by the route policy of the check.
+// Requests without a subject, or without the path parameter of a check, fail the check.
+//
+// This function expects the matchedPolicies to be sorted by decreasing specificity.
+func (a RelationAuthorizer) Authorize(
+	ctx context.Context,
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) (models.Decision, error) {
+
+	decision, err := a.next.Authorize(ctx, matchedPolicies, request, claims)
+	if err != nil {
+		return decision, err
+	}
+
+	start := time.Now()
+
+	for _, rp := range effectivePolicies(matchedPolicies) {
+		if rp.Relation == nil {
+			continue
+		}
+
+		decision.Record(models.RequirementResult{
+			RoutePolicy: rp.Path,
+			Requirement: rp.Relation.Check,
+			Passed:      a.relationMet(rp, claims),
+		})
+	}
+
+	decision.Duration += time.Since(start)
+
+	return decision, nil
+}
+
+// relationMet checks the relation requirement of a route policy for the token subject
+func (a RelationAuthorizer) relationMet(rp models.RoutePolicy, claims map[string]any) bool {
+	check, err := parseRelationCheck(rp.Relation.Check)
+	if err != nil {
+		return false
+	}
+
+	object, ok := check.object(rp.PathParams)
+	if !ok {
+		return false
+	}
+
+	subject, ok := a.subject(claims)
+	if !ok {
+		return false
+	}
+
+	return a.checker.check(object, check.relation, subject)
+}
+
+// subject returns the token subject as an object of the subject type
+func (a RelationAuthorizer) subject(claims map[string]any) (string, bool) {
+	claim, exists := claims[a.subjectClaim]
+	if !exists && a.subjectPath != nil {
+		claim, exists = a.subjectPath.lookup(claims)
+	}
+
+	if !exists {
+		return "", false
+	}
+
+	id, ok := claimString(claim)
+	if !ok || id == "" {
+		return "", false
+	}
+
+	return a.subjectType + ":" + id, true
+}
+
+// IsAnonymousAllowed delegates to the next authorizer, routes with relation requirements never allow anonymous requests
+func (a RelationAuthorizer) IsAnonymousAllowed(matchedPolicies []models.RoutePolicy, method string) bool {
+	return a.next.IsAnonymousAllowed(matchedPolicies, method)
+}
+
+// CheckDenyPolicies delegates to the next authorizer, deny route policies cannot have relation requirements
+func (a RelationAuthorizer) CheckDenyPolicies(
+	matchedPolicies []models.RoutePolicy,
+	request *models.RequestContext,
+	claims map[string]any) (deniedBy string, err error) {
+
+	return a.next.CheckDenyPolicies(matchedPolicies, request, claims)
+}
+
+// CheckTokenRequirements delegates to the next authorizer
+func (a RelationAuthorizer) CheckTokenRequirements(
+	matchedPolicies []models.RoutePolicy,
+	issuer string,
+	claims map[string]any) (failedRequirement string) {
+
+	return a.next.CheckTokenRequirements(matchedPolicies, issuer, claims)
+}
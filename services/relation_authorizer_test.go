@@ -0,0 +1,286 @@
+package services_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kaancfidan/bouncer/mocks"
+	"github.com/kaancfidan/bouncer/models"
+	"github.com/kaancfidan/bouncer/services"
+)
+
+var documentSchema = map[string]map[string]models.RelationDefinition{
+	"user": {},
+	"group": {
+		"member": {Types: []string{"user", "group#member"}},
+	},
+	"folder": {
+		"parent": {Types: []string{"folder"}},
+		"owner":  {Types: []string{"user", "group#member"}},
+		"viewer": {Types: []string{"user"}, Union: []string{"owner", "parent->viewer"}},
+	},
+	"document": {
+		"parent": {Types: []string{"folder"}},
+		"owner":  {Types: []string{"user"}},
+		"editor": {Types: []string{"user", "group#member"}, Union: []string{"owner", "parent->owner"}},
+		"viewer": {Types: []string{"user"}, Union: []string{"editor", "parent->viewer"}},
+	},
+}
+
+func newDocumentStore(t *testing.T) services.TupleStore {
+	store, err := services.NewFileTupleStore(filepath.Join(t.TempDir(), "tuples.json"))
+	if err != nil {
+		t.Fatalf("NewFileTupleStore() error = %v", err)
+	}
+
+	err = store.Write([]models.Tuple{
+		{Object: "document:plan", Relation: "parent", Subject: "folder:projects"},
+		{Object: "document:plan", Relation: "owner", Subject: "user:dave"},
+		{Object: "folder:projects", Relation: "owner", Subject: "group:eng#member"},
+		{Object: "folder:projects", Relation: "parent", Subject: "folder:root"},
+		{Object: "folder:root", Relation: "viewer", Subject: "user:erin"},
+		{Object: "group:eng", Relation: "member", Subject: "user:alice"},
+		{Object: "group:eng", Relation: "member", Subject: "group:interns#member"},
+		{Object: "group:interns", Relation: "member", Subject: "user:bob"},
+		{Object: "group:interns", Relation: "member", Subject: "group:eng#member"},
+	})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	return store
+}
+
+func TestRelationAuthorizer_Authorize(t *testing.T) {
+	editor := models.RoutePolicy{
+		Path:     "/documents/{docId}/**",
+		Relation: &models.RelationRequirement{Check: "document#editor@{path.docId}"},
+	}
+
+	viewer := models.RoutePolicy{
+		Path:     "/documents/{docId}",
+		Methods:  []string{"GET"},
+		Override: true,
+		Relation: &models.RelationRequirement{Check: "document#viewer@{path.docId}"},
+	}
+
+	root := models.RoutePolicy{
+		Path:     "/root/**",
+		Relation: &models.RelationRequirement{Check: "folder#viewer@root"},
+	}
+
+	tests := []struct {
+		name            string
+		matchedPolicies []models.RoutePolicy
+		pathParams      map[string]string
+		claims          map[string]any
+		nextFailedClaim string
+		wantFailedClaim string
+	}{
+		{
+			name:            "direct owner is editor",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{"sub": "dave"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "group member owning parent folder is editor",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{"sub": "alice"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "nested group member through group cycle is editor",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{"sub": "bob"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "root folder viewer is not editor",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{"sub": "erin"},
+			wantFailedClaim: "document#editor@{path.docId}",
+		},
+		{
+			name:            "root folder viewer is viewer of overriding route",
+			matchedPolicies: []models.RoutePolicy{viewer, editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{"sub": "erin"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "stranger is not viewer",
+			matchedPolicies: []models.RoutePolicy{viewer, editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{"sub": "mallory"},
+			wantFailedClaim: "document#viewer@{path.docId}",
+		},
+		{
+			name:            "other document",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{"docId": "secret"},
+			claims:          map[string]any{"sub": "dave"},
+			wantFailedClaim: "document#editor@{path.docId}",
+		},
+		{
+			name:            "literal object",
+			matchedPolicies: []models.RoutePolicy{root},
+			claims:          map[string]any{"sub": "erin"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "missing path parameter",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{},
+			claims:          map[string]any{"sub": "dave"},
+			wantFailedClaim: "document#editor@{path.docId}",
+		},
+		{
+			name:            "missing subject",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{},
+			wantFailedClaim: "document#editor@{path.docId}",
+		},
+		{
+			name:            "subject is not a string",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{"sub": []any{"dave"}},
+			wantFailedClaim: "document#editor@{path.docId}",
+		},
+		{
+			name:            "claim policy fails before relation",
+			matchedPolicies: []models.RoutePolicy{editor},
+			pathParams:      map[string]string{"docId": "plan"},
+			claims:          map[string]any{"sub": "dave"},
+			nextFailedClaim: "role",
+			wantFailedClaim: "role",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &models.RequestContext{}
+
+			matchedPolicies := make([]models.RoutePolicy, len(tt.matchedPolicies))
+			for i, rp := range tt.matchedPolicies {
+				rp.PathParams = tt.pathParams
+				matchedPolicies[i] = rp
+			}
+
+			next := &mocks.Authorizer{}
+			next.On("Authorize", mock.Anything, matchedPolicies, request, tt.claims).Return(nextDecision(tt.nextFailedClaim), nil)
+
+			a := services.NewRelationAuthorizer(models.RelationshipsConfig{Schema: documentSchema},
+				newDocumentStore(t), next)
+
+			decision, err := a.Authorize(context.Background(), matchedPolicies, request, tt.claims)
+			failedClaim := decision.FailedRequirement()
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if failedClaim != tt.wantFailedClaim {
+				t.Errorf("Authorize() failedClaim = %v, want %v", failedClaim, tt.wantFailedClaim)
+			}
+
+			next.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRelationAuthorizer_Authorize_SubjectClaim(t *testing.T) {
+	matchedPolicies := []models.RoutePolicy{{
+		Path:       "/documents/{docId}",
+		Relation:   &models.RelationRequirement{Check: "document#owner@{path.docId}"},
+		PathParams: map[string]string{"docId": "plan"},
+	}}
+
+	request := &models.RequestContext{}
+	claims := map[string]any{"sub": "ignored", "profile": map[string]any{"username": "dave"}}
+
+	next := &mocks.Authorizer{}
+	next.On("Authorize", mock.Anything, matchedPolicies, request, claims).Return(nextDecision(""), nil)
+
+	a := services.NewRelationAuthorizer(models.RelationshipsConfig{
+		Schema:       documentSchema,
+		SubjectType:  "user",
+		SubjectClaim: "profile.username",
+	}, newDocumentStore(t), next)
+
+	decision, err := a.Authorize(context.Background(), matchedPolicies, request, claims)
+	failedClaim := decision.FailedRequirement()
+	if err != nil || failedClaim != "" {
+		t.Errorf("Authorize() failedClaim = %v, error = %v", failedClaim, err)
+	}
+}
+
+func TestRelationAuthorizer_Authorize_OverlappingRoutes(t *testing.T) {
+	routePolicies := []models.RoutePolicy{
+		{
+			Path:     "/folders/{folder}/documents/{id}",
+			Relation: &models.RelationRequirement{Check: "document#viewer@{path.id}"},
+		},
+		{
+			Path:     "/folders/{id}/**",
+			Relation: &models.RelationRequirement{Check: "folder#owner@{path.id}"},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		claims          map[string]any
+		wantFailedClaim string
+	}{
+		{
+			name:            "owner of the folder and viewer of the document",
+			claims:          map[string]any{"sub": "alice"},
+			wantFailedClaim: "",
+		},
+		{
+			name:            "owner of a folder named like the document",
+			claims:          map[string]any{"sub": "dave"},
+			wantFailedClaim: "folder#owner@{path.id}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &models.RequestContext{Method: "GET", Path: "/folders/projects/documents/plan"}
+
+			matchedPolicies, err := services.NewRouteMatcher(routePolicies, models.PathConfig{}).
+				MatchRoutePolicies(request.Path, request)
+			if err != nil {
+				t.Fatalf("MatchRoutePolicies() error = %v", err)
+			}
+
+			store := newDocumentStore(t)
+			err = store.Write([]models.Tuple{{Object: "folder:plan", Relation: "owner", Subject: "user:dave"}})
+			if err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			next := &mocks.Authorizer{}
+			next.On("Authorize", mock.Anything, matchedPolicies, request, tt.claims).Return(nextDecision(""), nil)
+
+			a := services.NewRelationAuthorizer(models.RelationshipsConfig{Schema: documentSchema}, store, next)
+
+			decision, err := a.Authorize(context.Background(), matchedPolicies, request, tt.claims)
+			if err != nil {
+				t.Errorf("Authorize() error = %v", err)
+				return
+			}
+
+			if failedClaim := decision.FailedRequirement(); failedClaim != tt.wantFailedClaim {
+				t.Errorf("Authorize() failedClaim = %v, want %v", failedClaim, tt.wantFailedClaim)
+			}
+		})
+	}
+}
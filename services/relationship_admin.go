@@ -0,0 +1,153 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// maxTupleBodySize is the largest tuple list accepted by the admin API
+const maxTupleBodySize = 1 << 20
+
+// tuplesPath is the admin API path of tuples
+const tuplesPath = "/tuples"
+
+// RelationshipAdmin serves the tuple admin API.
+// Requests must carry a token that passes the admin claim policy.
+type RelationshipAdmin struct {
+	store         TupleStore
+	schema        map[string]map[string]models.RelationDefinition
+	authenticator Authenticator
+	authorizer    Authorizer
+	policyName    string
+	// trustedProxies are the ranges of proxies whose X-Forwarded-For is read for the client IP, as in Server
+	trustedProxies []*net.IPNet
+}
+
+// NewRelationshipAdmin creates a new RelationshipAdmin instance.
+// The trusted proxies of the server config are used to read the client IP of admin requests.
+func NewRelationshipAdmin(
+	cfg models.RelationshipsConfig,
+	trustedProxies []string,
+	store TupleStore,
+	authenticator Authenticator,
+	authorizer Authorizer) *RelationshipAdmin {
+
+	return &RelationshipAdmin{
+		store:          store,
+		schema:         cfg.Schema,
+		authenticator:  authenticator,
+		authorizer:     authorizer,
+		policyName:     cfg.AdminPolicyName,
+		trustedProxies: parseTrustedProxies(trustedProxies),
+	}
+}
+
+// Handle lists tuples on GET, optionally of the object query parameter,
+// writes the JSON list of tuples in the body on POST and deletes them on DELETE.
+func (a RelationshipAdmin) Handle(writer http.ResponseWriter, request *http.Request) {
+	requestID := uuid.New()
+
+	if request.URL.Path != tuplesPath {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	requestContext := &models.RequestContext{
+		Method:   request.Method,
+		Path:     request.URL.Path,
+		Query:    request.URL.Query(),
+		Header:   request.Header,
+		Host:     request.Host,
+		ClientIP: clientIP(request, a.trustedProxies),
+	}
+
+	claims, _, err := a.authenticator.Authenticate(request.Context(), requestContext)
+	if err != nil {
+		log.Printf("[%v] Admin authentication failed: %v", requestID, err)
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	decision, err := a.authorizer.Authorize(request.Context(),
+		[]models.RoutePolicy{{Path: tuplesPath, PolicyName: a.policyName}}, requestContext, claims)
+	if err != nil {
+		log.Printf("[%v] Error while authorizing admin: %v", requestID, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !decision.Allowed {
+		log.Printf("[%v] Admin authorization decision: %v", requestID, decision)
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		writer.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(writer).Encode(a.store.List(request.URL.Query().Get("object")))
+		if err != nil {
+			log.Printf("[%v] Error while writing tuples: %v", requestID, err)
+		}
+	case http.MethodPost, http.MethodDelete:
+		tuples, err := a.readTuples(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if request.Method == http.MethodPost {
+			err = a.store.Write(tuples)
+		} else {
+			err = a.store.Delete(tuples)
+		}
+
+		if err != nil {
+			log.Printf("[%v] Error while storing tuples: %v", requestID, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("[%v] Tuples stored: %s %d", requestID, request.Method, len(tuples))
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.Header().Set("Allow", "GET, POST, DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// readTuples reads the JSON list of tuples in the body, and validates them against the schema
+func (a RelationshipAdmin) readTuples(request *http.Request) ([]models.Tuple, error) {
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxTupleBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	if len(body) > maxTupleBodySize {
+		return nil, fmt.Errorf("body is larger than %d bytes", maxTupleBodySize)
+	}
+
+	var tuples []models.Tuple
+	err = json.Unmarshal(body, &tuples)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tuple list: %w", err)
+	}
+
+	for i, t := range tuples {
+		err = validateTuple(a.schema, t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tuple #%d: %w", i+1, err)
+		}
+	}
+
+	return tuples, nil
+}
@@ -0,0 +1,234 @@
+package services_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kaancfidan/bouncer/mocks"
+	"github.com/kaancfidan/bouncer/models"
+	"github.com/kaancfidan/bouncer/services"
+)
+
+func TestRelationshipAdmin_Handle(t *testing.T) {
+	adminPolicy := []models.RoutePolicy{{Path: "/tuples", PolicyName: "TupleAdmin"}}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		authErr        error
+		failedClaim    string
+		wantStatusCode int
+		wantSubjects   []string
+	}{
+		{
+			name:           "write tuples",
+			method:         http.MethodPost,
+			path:           "/tuples",
+			body:           `[{"object": "document:plan", "relation": "owner", "subject": "user:frank"}]`,
+			wantStatusCode: http.StatusNoContent,
+			wantSubjects:   []string{"user:dave", "user:frank"},
+		},
+		{
+			name:           "delete tuples",
+			method:         http.MethodDelete,
+			path:           "/tuples",
+			body:           `[{"object": "document:plan", "relation": "owner", "subject": "user:dave"}]`,
+			wantStatusCode: http.StatusNoContent,
+			wantSubjects:   []string{},
+		},
+		{
+			name:           "unknown object type",
+			method:         http.MethodPost,
+			path:           "/tuples",
+			body:           `[{"object": "page:plan", "relation": "owner", "subject": "user:frank"}]`,
+			wantStatusCode: http.StatusBadRequest,
+			wantSubjects:   []string{"user:dave"},
+		},
+		{
+			name:           "unknown relation",
+			method:         http.MethodPost,
+			path:           "/tuples",
+			body:           `[{"object": "document:plan", "relation": "author", "subject": "user:frank"}]`,
+			wantStatusCode: http.StatusBadRequest,
+			wantSubjects:   []string{"user:dave"},
+		},
+		{
+			name:   "subject type not allowed",
+			method: http.MethodPost,
+			path:   "/tuples",
+			body: `[{"object": "document:plan", "relation": "owner", "subject": "user:frank"},` +
+				`{"object": "document:plan", "relation": "owner", "subject": "group:eng#member"}]`,
+			wantStatusCode: http.StatusBadRequest,
+			wantSubjects:   []string{"user:dave"},
+		},
+		{
+			name:           "object without id",
+			method:         http.MethodPost,
+			path:           "/tuples",
+			body:           `[{"object": "document", "relation": "owner", "subject": "user:frank"}]`,
+			wantStatusCode: http.StatusBadRequest,
+			wantSubjects:   []string{"user:dave"},
+		},
+		{
+			name:           "invalid body",
+			method:         http.MethodPost,
+			path:           "/tuples",
+			body:           `{"object": "document:plan"}`,
+			wantStatusCode: http.StatusBadRequest,
+			wantSubjects:   []string{"user:dave"},
+		},
+		{
+			name:           "unauthenticated",
+			method:         http.MethodPost,
+			path:           "/tuples",
+			body:           `[{"object": "document:plan", "relation": "owner", "subject": "user:frank"}]`,
+			authErr:        errors.New("no token"),
+			wantStatusCode: http.StatusUnauthorized,
+			wantSubjects:   []string{"user:dave"},
+		},
+		{
+			name:           "unauthorized",
+			method:         http.MethodPost,
+			path:           "/tuples",
+			body:           `[{"object": "document:plan", "relation": "owner", "subject": "user:frank"}]`,
+			failedClaim:    "role",
+			wantStatusCode: http.StatusForbidden,
+			wantSubjects:   []string{"user:dave"},
+		},
+		{
+			name:           "unknown path",
+			method:         http.MethodGet,
+			path:           "/relations",
+			wantStatusCode: http.StatusNotFound,
+			wantSubjects:   []string{"user:dave"},
+		},
+		{
+			name:           "unsupported method",
+			method:         http.MethodPut,
+			path:           "/tuples",
+			wantStatusCode: http.StatusMethodNotAllowed,
+			wantSubjects:   []string{"user:dave"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newDocumentStore(t)
+			claims := map[string]any{"sub": "admin"}
+
+			authenticator := &mocks.Authenticator{}
+			authenticator.On("Authenticate", mock.Anything, mock.Anything).Return(claims, "", tt.authErr)
+
+			authorizer := &mocks.Authorizer{}
+			authorizer.On("Authorize", mock.Anything, adminPolicy, mock.Anything, claims).Return(nextDecision(tt.failedClaim), nil)
+
+			admin := services.NewRelationshipAdmin(models.RelationshipsConfig{
+				Schema:          documentSchema,
+				AdminPolicyName: "TupleAdmin",
+			}, nil, store, authenticator, authorizer)
+
+			recorder := httptest.NewRecorder()
+			admin.Handle(recorder, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.wantStatusCode, recorder.Code)
+			assert.Equal(t, tt.wantSubjects, store.Subjects("document:plan", "owner"))
+		})
+	}
+}
+
+func TestRelationshipAdmin_Handle_List(t *testing.T) {
+	claims := map[string]any{"sub": "admin"}
+
+	authenticator := &mocks.Authenticator{}
+	authenticator.On("Authenticate", mock.Anything, mock.Anything).Return(claims, "", nil)
+
+	authorizer := &mocks.Authorizer{}
+	authorizer.On("Authorize", mock.Anything, mock.Anything, mock.Anything, claims).Return(nextDecision(""), nil)
+
+	admin := services.NewRelationshipAdmin(models.RelationshipsConfig{
+		Schema:          documentSchema,
+		AdminPolicyName: "TupleAdmin",
+	}, nil, newDocumentStore(t), authenticator, authorizer)
+
+	recorder := httptest.NewRecorder()
+	admin.Handle(recorder, httptest.NewRequest(http.MethodGet, "/tuples?object=document:plan", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var tuples []models.Tuple
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &tuples))
+	assert.Equal(t, []models.Tuple{
+		{Object: "document:plan", Relation: "owner", Subject: "user:dave"},
+		{Object: "document:plan", Relation: "parent", Subject: "folder:projects"},
+	}, tuples)
+}
+
+func TestRelationshipAdmin_Handle_ClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   string
+		wantStatusCode int
+	}{
+		{
+			name:           "internal client",
+			remoteAddr:     "10.1.2.3:1234",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "internal client behind trusted proxy",
+			remoteAddr:     "192.0.2.10:1234",
+			forwardedFor:   "10.1.2.3",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "external client behind trusted proxy",
+			remoteAddr:     "192.0.2.10:1234",
+			forwardedFor:   "203.0.113.7",
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "spoofed forwarded for of untrusted client",
+			remoteAddr:     "203.0.113.7:1234",
+			forwardedFor:   "10.1.2.3",
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := &mocks.Authenticator{}
+			authenticator.On("Authenticate", mock.Anything, mock.Anything).Return(map[string]any{"sub": "admin"}, "", nil)
+
+			authorizer, err := services.NewAuthorizer(models.ClaimPolicyConfig{
+				"TupleAdmin": {{CIDRs: []string{"10.0.0.0/8"}}},
+			}, models.RolesConfig{}, nil)
+			if err != nil {
+				t.Fatalf("NewAuthorizer() error = %v", err)
+			}
+
+			admin := services.NewRelationshipAdmin(models.RelationshipsConfig{
+				Schema:          documentSchema,
+				AdminPolicyName: "TupleAdmin",
+			}, []string{"192.0.2.0/24"}, newDocumentStore(t), authenticator, authorizer)
+
+			request := httptest.NewRequest(http.MethodGet, "/tuples", nil)
+			request.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				request.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			recorder := httptest.NewRecorder()
+			admin.Handle(recorder, request)
+
+			assert.Equal(t, tt.wantStatusCode, recorder.Code)
+		})
+	}
+}
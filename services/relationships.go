@@ -0,0 +1,319 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// default subject of relation checks
+const (
+	defaultSubjectType  = "user"
+	defaultSubjectClaim = "sub"
+)
+
+// maxRelationDepth bounds the nesting of usersets and rewrites followed by a relation check
+const maxRelationDepth = 32
+
+// relationNameRegexp matches the names of object types and relations
+var relationNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// relationCheckRegexp matches relation requirements, e.g. document#editor@{path.docId} or folder#viewer@root
+var relationCheckRegexp = regexp.MustCompile(`^([^#@]+)#([^#@]+)@(?:\{path\.([A-Za-z_][A-Za-z0-9_]*)\}|([^{}#@:]+))$`)
+
+// relationCheck is a parsed relation requirement
+type relationCheck struct {
+	objectType string
+	relation   string
+	// objectID is either a literal ID, or empty if the ID is read from pathParam
+	objectID  string
+	pathParam string
+}
+
+// parseRelationCheck parses a relation requirement of the form type#relation@id or type#relation@{path.name}
+func parseRelationCheck(check string) (relationCheck, error) {
+	m := relationCheckRegexp.FindStringSubmatch(check)
+	if m == nil {
+		return relationCheck{}, fmt.Errorf("invalid relation check: %s", check)
+	}
+
+	return relationCheck{objectType: m[1], relation: m[2], pathParam: m[3], objectID: m[4]}, nil
+}
+
+// object returns the object to check with the object ID resolved from the path parameters captured by the route policy
+// of the check, or false if it is not captured
+func (c relationCheck) object(pathParams map[string]string) (string, bool) {
+	if c.pathParam == "" {
+		return c.objectType + ":" + c.objectID, true
+	}
+
+	id, exists := pathParams[c.pathParam]
+	if !exists || id == "" {
+		return "", false
+	}
+
+	return c.objectType + ":" + id, true
+}
+
+// relationChecker checks relations of the tuple store according to the schema
+type relationChecker struct {
+	schema map[string]map[string]models.RelationDefinition
+	store  TupleStore
+}
+
+// check returns true if the subject has the relation to the object, directly, through a userset, or a rewrite
+func (c relationChecker) check(object string, relation string, subject string) bool {
+	return c.checkNested(object, relation, subject, 0, make(map[string]bool))
+}
+
+// checkNested checks a relation, skipping object relations visited before, which have not led to the subject
+func (c relationChecker) checkNested(
+	object string,
+	relation string,
+	subject string,
+	depth int,
+	visited map[string]bool) bool {
+
+	key := object + "#" + relation
+	if visited[key] || depth >= maxRelationDepth {
+		return false
+	}
+
+	visited[key] = true
+
+	objectType, _, _ := strings.Cut(object, ":")
+	definition, exists := c.schema[objectType][relation]
+	if !exists {
+		return false
+	}
+
+	for _, s := range c.store.Subjects(object, relation) {
+		if s == subject {
+			return true
+		}
+
+		if i := strings.LastIndex(s, "#"); i >= 0 && c.checkNested(s[:i], s[i+1:], subject, depth+1, visited) {
+			return true
+		}
+	}
+
+	for _, rewrite := range definition.Union {
+		tupleset, related, isTupleset := strings.Cut(rewrite, "->")
+		if !isTupleset {
+			if c.checkNested(object, rewrite, subject, depth+1, visited) {
+				return true
+			}
+			continue
+		}
+
+		for _, s := range c.store.Subjects(object, tupleset) {
+			if c.checkNested(s, related, subject, depth+1, visited) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// validateTuple checks that the object type and relation are defined, and the subject type is allowed by the relation
+func validateTuple(schema map[string]map[string]models.RelationDefinition, t models.Tuple) error {
+	objectType, objectID, _ := strings.Cut(t.Object, ":")
+	if objectID == "" {
+		return fmt.Errorf("invalid object: %s", t.Object)
+	}
+
+	relations, exists := schema[objectType]
+	if !exists {
+		return fmt.Errorf("unknown object type: %s", objectType)
+	}
+
+	definition, exists := relations[t.Relation]
+	if !exists {
+		return fmt.Errorf("unknown relation of %s: %s", objectType, t.Relation)
+	}
+
+	subject, subjectRelation, isUserset := strings.Cut(t.Subject, "#")
+	subjectType, subjectID, _ := strings.Cut(subject, ":")
+	if subjectID == "" || (isUserset && subjectRelation == "") {
+		return fmt.Errorf("invalid subject: %s", t.Subject)
+	}
+
+	allowed := subjectType
+	if isUserset {
+		allowed += "#" + subjectRelation
+	}
+
+	for _, typ := range definition.Types {
+		if typ == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("relation %s of %s does not allow subject type %s", t.Relation, objectType, allowed)
+}
+
+// validateRelationships checks that the schema refers to defined types and relations without forming a rewrite cycle,
+// and the admin API is protected by an existing claim policy
+func validateRelationships(cfg *models.RelationshipsConfig, claimPolicies models.ClaimPolicyConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	if cfg.Store == "" {
+		return fmt.Errorf("tuple store path cannot be empty")
+	}
+
+	if len(cfg.Schema) == 0 {
+		return fmt.Errorf("schema cannot be empty")
+	}
+
+	subjectType := cfg.SubjectType
+	if subjectType == "" {
+		subjectType = defaultSubjectType
+	}
+
+	if _, exists := cfg.Schema[subjectType]; !exists {
+		return fmt.Errorf("subject type %s is not defined", subjectType)
+	}
+
+	if cfg.SubjectClaim != "" {
+		_, err := parseClaimPath(cfg.SubjectClaim)
+		if err != nil {
+			return fmt.Errorf("invalid subject claim: %w", err)
+		}
+	}
+
+	if cfg.AdminListenAddress != "" && cfg.AdminPolicyName == "" {
+		return fmt.Errorf("admin API cannot be enabled without an admin policy name")
+	}
+
+	if cfg.AdminPolicyName != "" {
+		if _, exists := claimPolicies[cfg.AdminPolicyName]; !exists {
+			return fmt.Errorf("non-existing admin policy name: %s", cfg.AdminPolicyName)
+		}
+	}
+
+	var names []string
+	for objectType, relations := range cfg.Schema {
+		if !relationNameRegexp.MatchString(objectType) {
+			return fmt.Errorf("invalid object type name: %s", objectType)
+		}
+
+		for relation, definition := range relations {
+			if !relationNameRegexp.MatchString(relation) {
+				return fmt.Errorf("invalid relation name of %s: %s", objectType, relation)
+			}
+
+			err := validateRelationDefinition(cfg.Schema, objectType, definition)
+			if err != nil {
+				return fmt.Errorf("invalid relation %s of %s: %w", relation, objectType, err)
+			}
+
+			names = append(names, objectType+"#"+relation)
+		}
+	}
+
+	cycle := findCycle(names, func(name string) []string {
+		objectType, relation, _ := strings.Cut(name, "#")
+
+		var rewrites []string
+		for _, rewrite := range cfg.Schema[objectType][relation].Union {
+			if !strings.Contains(rewrite, "->") {
+				rewrites = append(rewrites, objectType+"#"+rewrite)
+			}
+		}
+
+		return rewrites
+	})
+
+	if cycle != nil {
+		return fmt.Errorf("found relation rewrite cycle: %s", strings.Join(cycle, " > "))
+	}
+
+	return nil
+}
+
+// validateRelationDefinition checks that the subject types and rewrites of a relation are defined
+func validateRelationDefinition(
+	schema map[string]map[string]models.RelationDefinition,
+	objectType string,
+	definition models.RelationDefinition) error {
+
+	if len(definition.Types) == 0 && len(definition.Union) == 0 {
+		return fmt.Errorf("types and union cannot both be empty")
+	}
+
+	for _, typ := range definition.Types {
+		subjectType, subjectRelation, isUserset := strings.Cut(typ, "#")
+
+		relations, exists := schema[subjectType]
+		if !exists {
+			return fmt.Errorf("unknown subject type: %s", typ)
+		}
+
+		if _, exists = relations[subjectRelation]; isUserset && !exists {
+			return fmt.Errorf("unknown subject relation: %s", typ)
+		}
+	}
+
+	for _, rewrite := range definition.Union {
+		tupleset, related, isTupleset := strings.Cut(rewrite, "->")
+
+		tuplesetDefinition, exists := schema[objectType][tupleset]
+		if !exists {
+			return fmt.Errorf("unknown relation in union: %s", rewrite)
+		}
+
+		if !isTupleset {
+			continue
+		}
+
+		if len(tuplesetDefinition.Types) == 0 {
+			return fmt.Errorf("relation %s in union has no subject types: %s", tupleset, rewrite)
+		}
+
+		for _, typ := range tuplesetDefinition.Types {
+			if strings.Contains(typ, "#") {
+				return fmt.Errorf("relation %s in union allows usersets: %s", tupleset, rewrite)
+			}
+
+			if _, exists = schema[typ][related]; !exists {
+				return fmt.Errorf("relation %s is not defined on %s: %s", related, typ, rewrite)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateRelationRequirement checks that the relation requirement refers to a defined relation,
+// and to a path parameter captured by the route
+func validateRelationRequirement(cfg *models.RelationshipsConfig, rp models.RoutePolicy) error {
+	if cfg == nil {
+		return fmt.Errorf("relation requirement without relationships configured")
+	}
+
+	check, err := parseRelationCheck(rp.Relation.Check)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := cfg.Schema[check.objectType][check.relation]; !exists {
+		return fmt.Errorf("unknown relation in check: %s", rp.Relation.Check)
+	}
+
+	if check.pathParam == "" {
+		return nil
+	}
+
+	for _, segment := range strings.Split(rp.Path, "/") {
+		if m := pathParamRegexp.FindStringSubmatch(segment); m != nil && m[1] == check.pathParam {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("path parameter %s of check is not captured by the route", check.pathParam)
+}
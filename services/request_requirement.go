@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// validateHosts checks that hosts are not empty, and wildcards are only used as the leftmost label
+func validateHosts(hosts []string) error {
+	if len(hosts) == 0 {
+		return fmt.Errorf("hosts cannot be empty")
+	}
+
+	for _, host := range hosts {
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.Contains(name, "*") {
+			return fmt.Errorf("invalid host: %s", host)
+		}
+	}
+
+	return nil
+}
+
+// validateCIDRs checks that the ranges are not empty and can be parsed
+func validateCIDRs(cidrs []string) error {
+	if len(cidrs) == 0 {
+		return fmt.Errorf("cidrs cannot be empty")
+	}
+
+	for _, cidr := range cidrs {
+		_, err := parseNetwork(cidr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseNetwork parses a CIDR range, or a single IP address as a range of one address
+func parseNetwork(cidr string) (*net.IPNet, error) {
+	if strings.Contains(cidr, "/") {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+
+		return network, nil
+	}
+
+	ip := net.ParseIP(cidr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip address: %s", cidr)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// compileNetworks parses the ranges of all cidr requirements, keyed by range as configured,
+// and stops at the first range parseNetwork rejects
+func compileNetworks(claimPolicies map[string][]models.ClaimRequirement) (map[string]*net.IPNet, error) {
+	networks := make(map[string]*net.IPNet)
+
+	err := walkPolicyRequirements(claimPolicies, func(cp models.ClaimRequirement) error {
+		for _, cidr := range cp.CIDRs {
+			network, err := parseNetwork(cidr)
+			if err != nil {
+				return err
+			}
+
+			networks[cidr] = network
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return networks, nil
+}
+
+// inNetworks checks if the client IP is in one of the ranges
+func (a AuthorizerImpl) inNetworks(ip net.IP, cidrs []string) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range cidrs {
+		if network := a.networks[cidr]; network != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostMatches checks the request host, without port, against the hosts case-insensitively.
+// Hosts starting with *. match any subdomain of the rest, but not the rest itself.
+func hostMatches(requestHost string, hosts []string) bool {
+	host := requestHost
+	if h, _, err := net.SplitHostPort(requestHost); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSuffix(h, "."))
+
+		if suffix := strings.TrimPrefix(h, "*"); suffix != h {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+
+		if host == h {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestValues returns the values of the request header or query parameter of a requirement
+func requestValues(cp models.ClaimRequirement, request *models.RequestContext) []string {
+	if request == nil {
+		return nil
+	}
+
+	if cp.Header != "" {
+		return request.Header.Values(cp.Header)
+	}
+
+	return request.Query[cp.Query]
+}
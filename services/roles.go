@@ -0,0 +1,183 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// default claims of the roles config
+const (
+	defaultRolesClaim       = "roles"
+	defaultPermissionsClaim = "permissions"
+)
+
+// roleExpander expands the roles of a token into the permissions granted by them and the roles they inherit
+type roleExpander struct {
+	rolesClaim       string
+	rolesPath        claimPath
+	permissionsClaim string
+	// permissions are the effective permissions of each role
+	permissions map[string][]string
+}
+
+// newRoleExpander computes the effective permissions of each role, returns nil if no roles are defined
+func newRoleExpander(cfg models.RolesConfig) *roleExpander {
+	if len(cfg.Definitions) == 0 {
+		return nil
+	}
+
+	e := &roleExpander{
+		rolesClaim:       cfg.Claim,
+		permissionsClaim: cfg.PermissionsClaim,
+		permissions:      make(map[string][]string),
+	}
+
+	if e.rolesClaim == "" {
+		e.rolesClaim = defaultRolesClaim
+	}
+
+	if e.permissionsClaim == "" {
+		e.permissionsClaim = defaultPermissionsClaim
+	}
+
+	// a roles claim that is not a path leaves rolesPath nil, expand then only reads the claim by its exact name
+	e.rolesPath, _ = parseClaimPath(e.rolesClaim)
+
+	for role := range cfg.Definitions {
+		permissions := make(map[string]bool)
+		collectPermissions(cfg.Definitions, role, permissions, make(map[string]bool))
+
+		for p := range permissions {
+			e.permissions[role] = append(e.permissions[role], p)
+		}
+	}
+
+	return e
+}
+
+// collectPermissions adds the permissions of a role and its inherited roles, skipping roles already visited
+func collectPermissions(
+	definitions map[string]models.RoleDefinition,
+	role string,
+	permissions map[string]bool,
+	visited map[string]bool) {
+
+	if visited[role] {
+		return
+	}
+
+	visited[role] = true
+
+	definition := definitions[role]
+	for _, p := range definition.Permissions {
+		permissions[p] = true
+	}
+
+	for _, inherited := range definition.Inherits {
+		collectPermissions(definitions, inherited, permissions, visited)
+	}
+}
+
+// expand returns a copy of the claims with the effective permissions of the roles claim added to the permissions claim.
+// Permissions already in the permissions claim are kept, unknown roles grant nothing.
+func (e *roleExpander) expand(claims map[string]any) map[string]any {
+	if e == nil || claims == nil {
+		return claims
+	}
+
+	roles, exists := claims[e.rolesClaim]
+	if !exists && e.rolesPath != nil {
+		roles, exists = e.rolesPath.lookup(claims)
+	}
+
+	if !exists {
+		return claims
+	}
+
+	permissions := make(map[string]bool)
+	for _, p := range claimStrings(claims[e.permissionsClaim], false) {
+		permissions[p] = true
+	}
+
+	for _, role := range claimStrings(roles, false) {
+		for _, p := range e.permissions[role] {
+			permissions[p] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(permissions))
+	for p := range permissions {
+		sorted = append(sorted, p)
+	}
+
+	sort.Strings(sorted)
+
+	effective := make([]any, len(sorted))
+	for i, p := range sorted {
+		effective[i] = p
+	}
+
+	expanded := make(map[string]any, len(claims)+1)
+	for k, v := range claims {
+		expanded[k] = v
+	}
+
+	expanded[e.permissionsClaim] = effective
+
+	return expanded
+}
+
+// validateRoles checks that the roles claim is a valid path, inherited roles are defined and do not form a cycle
+func validateRoles(cfg models.RolesConfig) error {
+	if len(cfg.Definitions) == 0 {
+		if cfg.Claim != "" || cfg.PermissionsClaim != "" {
+			return fmt.Errorf("roles claims cannot be configured without role definitions")
+		}
+
+		return nil
+	}
+
+	if cfg.Claim != "" {
+		_, err := parseClaimPath(cfg.Claim)
+		if err != nil {
+			return fmt.Errorf("invalid roles claim: %w", err)
+		}
+	}
+
+	rolesClaim, permissionsClaim := cfg.Claim, cfg.PermissionsClaim
+	if rolesClaim == "" {
+		rolesClaim = defaultRolesClaim
+	}
+
+	if permissionsClaim == "" {
+		permissionsClaim = defaultPermissionsClaim
+	}
+
+	if rolesClaim == permissionsClaim {
+		return fmt.Errorf("roles and permissions claims must be different")
+	}
+
+	names := make([]string, 0, len(cfg.Definitions))
+	for role, definition := range cfg.Definitions {
+		for _, inherited := range definition.Inherits {
+			if _, exists := cfg.Definitions[inherited]; !exists {
+				return fmt.Errorf("role %s inherits non-existing role: %s", role, inherited)
+			}
+		}
+
+		names = append(names, role)
+	}
+
+	cycle := findCycle(names, func(role string) []string {
+		return cfg.Definitions[role].Inherits
+	})
+
+	if cycle != nil {
+		return fmt.Errorf("found role inheritance cycle: %s", strings.Join(cycle, " > "))
+	}
+
+	return nil
+}
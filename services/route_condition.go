@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// routeCondition is a compiled header or query parameter condition of a route policy
+type routeCondition struct {
+	header bool
+	name   string
+	match  models.RequestMatch
+	regex  *regexp.Regexp
+}
+
+// compileRouteConditions compiles the header and query parameter conditions of a route policy, sorted by name
+func compileRouteConditions(rp models.RoutePolicy) ([]routeCondition, error) {
+	conditions := make([]routeCondition, 0, len(rp.Headers)+len(rp.Query))
+
+	for name, match := range rp.Headers {
+		conditions = append(conditions, routeCondition{header: true, name: http.CanonicalHeaderKey(name), match: match})
+	}
+
+	for name, match := range rp.Query {
+		conditions = append(conditions, routeCondition{name: name, match: match})
+	}
+
+	sort.Slice(conditions, func(i, j int) bool {
+		if conditions[i].header != conditions[j].header {
+			return conditions[i].header
+		}
+
+		return conditions[i].name < conditions[j].name
+	})
+
+	for i, c := range conditions {
+		if c.match.Regex == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(c.match.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex of %s: %w", c.description(), err)
+		}
+
+		conditions[i].regex = re
+	}
+
+	return conditions, nil
+}
+
+// met checks the condition against the values of the header or query parameter of the request.
+// Value and regex conditions are met if any of the values matches.
+func (c routeCondition) met(request *models.RequestContext) bool {
+	var values []string
+	if c.header {
+		values = request.Header.Values(c.name)
+	} else {
+		values = request.Query[c.name]
+	}
+
+	if c.match.Present != nil {
+		return (len(values) != 0) == *c.match.Present
+	}
+
+	for _, v := range values {
+		if c.regex != nil && c.regex.MatchString(v) || c.regex == nil && v == c.match.Value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// description names the header or query parameter of the condition
+func (c routeCondition) description() string {
+	if c.header {
+		return "header " + c.name
+	}
+
+	return "query " + c.name
+}
+
+// conditionCount returns the number of header and query parameter conditions of a route policy
+func conditionCount(rp models.RoutePolicy) int {
+	return len(rp.Headers) + len(rp.Query)
+}
+
+// conditionsMayOverlap checks if a request may meet the conditions of both route policies.
+// Conditions of the same header or query parameter conflict if one requires its absence and the other its presence,
+// or if they require different values, assuming that the header or query parameter has a single value.
+func conditionsMayOverlap(rp1 models.RoutePolicy, rp2 models.RoutePolicy) bool {
+	c1, err1 := compileRouteConditions(rp1)
+	c2, err2 := compileRouteConditions(rp2)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+
+	for _, a := range c1 {
+		for _, b := range c2 {
+			if a.header == b.header && a.name == b.name && conditionsConflict(a.match, b.match) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// conditionsConflict checks if no request can meet both conditions of the same header or query parameter
+func conditionsConflict(m1 models.RequestMatch, m2 models.RequestMatch) bool {
+	absent1 := m1.Present != nil && !*m1.Present
+	absent2 := m2.Present != nil && !*m2.Present
+	if absent1 != absent2 {
+		return true
+	}
+
+	return m1.Value != "" && m2.Value != "" && m1.Value != m2.Value
+}
+
+// validateRouteConditions checks that each header and query parameter condition of a route policy has a name,
+// exactly one of a value, a regex or presence, and that regexes compile
+func validateRouteConditions(rp models.RoutePolicy) error {
+	conditions, err := compileRouteConditions(rp)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range conditions {
+		if c.name == "" {
+			return fmt.Errorf("condition without a name")
+		}
+
+		set := 0
+		for _, isSet := range []bool{c.match.Value != "", c.match.Regex != "", c.match.Present != nil} {
+			if isSet {
+				set++
+			}
+		}
+
+		if set != 1 {
+			return fmt.Errorf("%s must have exactly one of value, regex or present", c.description())
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package services
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/gobwas/glob"
@@ -10,59 +12,549 @@ import (
 	"github.com/kaancfidan/bouncer/models"
 )
 
-// RouteMatcher matches given path and method to configured route policies
+// RouteMatcher matches given request path, method, host, headers and query parameters to configured route policies
 type RouteMatcher interface {
-	MatchRoutePolicies(path string, method string) ([]models.RoutePolicy, error)
+	MatchRoutePolicies(path string, request *models.RequestContext) (matches []models.RoutePolicy, err error)
 }
 
-// RouteMatcherImpl implements glob-based route matching
+// pathParamRegexp matches path segments that capture a named parameter, e.g. {id}
+var pathParamRegexp = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// globMetaChars are the characters that make a glob segment match more than its literal text
+const globMetaChars = `*?[]{}\`
+
+// RouteMatcherImpl implements glob-based route matching.
+// Route policy globs are compiled once, and indexed by their literal leading path segments and methods.
 type RouteMatcherImpl struct {
-	routePolicies []models.RoutePolicy
+	routes []compiledRoute
+	index  *routeNode
+	paths  models.PathConfig
+	err    error
+}
+
+// compiledRoute is a route policy with its normalized path, compiled glob and header and query parameter conditions
+type compiledRoute struct {
+	policy     models.RoutePolicy
+	path       string
+	glob       glob.Glob
+	conditions []routeCondition
 }
 
-// NewRouteMatcher creates a new RouteMatcherImpl instance
-func NewRouteMatcher(routePolicies []models.RoutePolicy) *RouteMatcherImpl {
-	return &RouteMatcherImpl{routePolicies: routePolicies}
+// routeNode is a node of the segment trie of route policies.
+// Route policies are stored at the node of their literal leading segments, by the indices of their configuration order.
+type routeNode struct {
+	children  map[string]*routeNode
+	anyMethod []int
+	byMethod  map[string][]int
 }
 
-// MatchRoutePolicies matches given the request path-method pair to configured routes
+// NewRouteMatcher creates a new RouteMatcherImpl instance, canonicalizing request paths with the given config
+func NewRouteMatcher(routePolicies []models.RoutePolicy, pathConfig models.PathConfig) *RouteMatcherImpl {
+	g := &RouteMatcherImpl{
+		routes: make([]compiledRoute, 0, len(routePolicies)),
+		index:  &routeNode{},
+		paths:  pathConfig,
+	}
+
+	for i, rp := range routePolicies {
+		normalizedPolicyPath := "/" + strings.Trim(rp.Path, " \t\n/") + "/"
+		pattern := globPattern(foldPath(normalizedPolicyPath, pathConfig))
+
+		compiled, err := glob.Compile(pattern, '/')
+		if err != nil {
+			// skipping the route could let a less specific route allow its requests, so matching fails instead
+			g.err = fmt.Errorf("could not compile policy glob: %v", err)
+		}
+
+		conditions, err := compileRouteConditions(rp)
+		if err != nil {
+			// the same regular expressions are compiled by validateRouteConditions
+			g.err = fmt.Errorf("could not compile policy conditions: %v", err)
+		}
+
+		g.routes = append(g.routes, compiledRoute{
+			policy:     rp,
+			path:       normalizedPolicyPath,
+			glob:       compiled,
+			conditions: conditions,
+		})
+		g.index.insert(literalPrefix(pattern), rp.Methods, i)
+	}
+
+	return g
+}
+
+// MatchRoutePolicies matches given the escaped request path, and the host, method, headers and query parameters
+// of the request to configured routes
 // Paths are matched using standard wildcard globs
 // If no method is specified in the configuration, that route matches to all methods
-func (g RouteMatcherImpl) MatchRoutePolicies(path string, method string) ([]models.RoutePolicy, error) {
+// If no host is specified in the configuration, that route matches to all hosts
+// Routes match only if all of their header and query parameter conditions are met
+// Matches are returned in the configuration order of their route policies.
+//
+// The path is canonicalized before matching, paths that cannot be canonicalized fail to match.
+//
+// Path segments like {id} match any single segment and capture it as a named parameter.
+// Captured parameters are returned in the PathParams of each matched route policy,
+// so that the same name captured by several routes keeps the value of each route.
+func (g RouteMatcherImpl) MatchRoutePolicies(
+	path string,
+	request *models.RequestContext) ([]models.RoutePolicy, error) {
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
 	matches := make([]models.RoutePolicy, 0)
-	for _, rp := range g.routePolicies {
-		parsed, err := url.Parse(path)
-		if err != nil {
-			return nil, fmt.Errorf("could not parse path: %v", err)
-		}
 
-		normalizedPath := "/" + strings.Trim(parsed.Path, " \t\n/") + "/"
-		normalizedPolicyPath := "/" + strings.Trim(rp.Path, " \t\n/") + "/"
+	if len(g.routes) == 0 {
+		return matches, nil
+	}
 
-		g, err := glob.Compile(normalizedPolicyPath, '/')
-		if err != nil {
-			return nil, fmt.Errorf("could not compile policy glob: %v", err)
+	parsed, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse path: %v", err)
+	}
+
+	canonical, err := canonicalPath(parsed.EscapedPath(), g.paths)
+	if err != nil {
+		return nil, fmt.Errorf("could not canonicalize path: %w", err)
+	}
+
+	normalizedPath := "/" + strings.Trim(canonical, " \t\n/") + "/"
+	foldedPath := foldPath(normalizedPath, g.paths)
+
+	candidates := g.index.candidates(pathSegments(foldedPath), request.Method)
+	sort.Ints(candidates)
+
+	for k, i := range candidates {
+		// a route listing a method more than once is indexed once per listing
+		if k > 0 && candidates[k-1] == i {
+			continue
 		}
 
+		route := g.routes[i]
+
 		// check if route matches
-		if !g.Match(normalizedPath) {
+		if !route.glob.Match(foldedPath) {
 			continue
 		}
 
-		// check if method matches
-		// all methods match if no method specified
-		if rp.Methods == nil {
-			matches = append(matches, rp)
+		// check if host matches
+		// all hosts match if no host specified
+		if route.policy.Hosts != nil && !hostMatches(request.Host, route.policy.Hosts) {
 			continue
 		}
 
-		for _, m := range rp.Methods {
-			if m == method {
-				matches = append(matches, rp)
-				break
-			}
+		if !route.conditionsMet(request) {
+			continue
 		}
+
+		match := route.policy
+		match.PathParams = capturePathParams(route.path, normalizedPath)
+
+		matches = append(matches, match)
 	}
 
 	return matches, nil
 }
+
+// conditionsMet checks the header and query parameter conditions of the route
+func (r compiledRoute) conditionsMet(request *models.RequestContext) bool {
+	for _, c := range r.conditions {
+		if !c.met(request) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// insert adds the route policy at index i under its literal leading segments.
+// All methods match if no method specified.
+func (n *routeNode) insert(prefix []string, methods []string, i int) {
+	node := n
+	for _, segment := range prefix {
+		if node.children == nil {
+			node.children = make(map[string]*routeNode)
+		}
+
+		child, exists := node.children[segment]
+		if !exists {
+			child = &routeNode{}
+			node.children[segment] = child
+		}
+
+		node = child
+	}
+
+	if methods == nil {
+		node.anyMethod = append(node.anyMethod, i)
+		return
+	}
+
+	if node.byMethod == nil {
+		node.byMethod = make(map[string][]int)
+	}
+
+	for _, m := range methods {
+		node.byMethod[m] = append(node.byMethod[m], i)
+	}
+}
+
+// candidates returns the indices of the route policies of the given method,
+// whose literal leading segments are a prefix of the path segments
+func (n *routeNode) candidates(segments []string, method string) []int {
+	var indices []int
+
+	node := n
+	for i := 0; node != nil; i++ {
+		indices = append(indices, node.anyMethod...)
+		indices = append(indices, node.byMethod[method]...)
+
+		if i == len(segments) {
+			break
+		}
+
+		node = node.children[segments[i]]
+	}
+
+	return indices
+}
+
+// literalPrefix returns the leading segments of a glob pattern that have no wildcards
+func literalPrefix(pattern string) []string {
+	segments := pathSegments(pattern)
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, globMetaChars) {
+			return segments[:i]
+		}
+	}
+
+	return segments
+}
+
+// pathSegments splits a normalized path into its segments, the root path has none
+func pathSegments(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil
+	}
+
+	return strings.Split(trimmed, "/")
+}
+
+// validateRouteGlob checks that the glob of a route policy path compiles
+func validateRouteGlob(policyPath string) error {
+	normalizedPolicyPath := "/" + strings.Trim(policyPath, " \t\n/") + "/"
+
+	_, err := glob.Compile(globPattern(normalizedPolicyPath), '/')
+
+	return err
+}
+
+// globPattern replaces the path parameter segments of a policy path with single segment wildcards
+func globPattern(policyPath string) string {
+	segments := strings.Split(policyPath, "/")
+	for i, segment := range segments {
+		if pathParamRegexp.MatchString(segment) {
+			segments[i] = "*"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// capturePathParams returns the segments of a matched canonical path at the positions of the policy path's parameters,
+// or nil if the policy path has no parameters.
+// Parameters before the first ** are positioned from the start of the path, the ones after the last ** from the end.
+func capturePathParams(policyPath string, path string) map[string]string {
+	policySegments := strings.Split(strings.Trim(policyPath, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var params map[string]string
+	fromEnd := false
+
+	for i, segment := range policySegments {
+		if strings.Contains(segment, "**") {
+			fromEnd = true
+			continue
+		}
+
+		m := pathParamRegexp.FindStringSubmatch(segment)
+		if m == nil {
+			continue
+		}
+
+		index := i
+		if fromEnd {
+			index = len(pathSegments) - (len(policySegments) - i)
+		}
+
+		if index >= 0 && index < len(pathSegments) {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[m[1]] = pathSegments[index]
+		}
+	}
+
+	return params
+}
+
+// validatePathParams checks that path parameter names are unique and that their positions can be told,
+// i.e. no parameter is placed between two ** wildcards.
+func validatePathParams(policyPath string) error {
+	segments := strings.Split(strings.Trim(policyPath, " \t\n/"), "/")
+
+	lastSuper := -1
+	for i, segment := range segments {
+		if strings.Contains(segment, "**") {
+			lastSuper = i
+		}
+	}
+
+	names := make(map[string]bool)
+	seenSuper := false
+
+	for i, segment := range segments {
+		if strings.Contains(segment, "**") {
+			seenSuper = true
+			continue
+		}
+
+		m := pathParamRegexp.FindStringSubmatch(segment)
+		if m == nil {
+			if strings.ContainsAny(segment, "{}") && !strings.Contains(segment, ",") {
+				return fmt.Errorf("invalid path parameter segment: %s", segment)
+			}
+			continue
+		}
+
+		if names[m[1]] {
+			return fmt.Errorf("duplicate path parameter: %s", m[1])
+		}
+
+		if seenSuper && i < lastSuper {
+			return fmt.Errorf("path parameter %s cannot be placed between ** wildcards", m[1])
+		}
+
+		names[m[1]] = true
+	}
+
+	return nil
+}
+
+// segment ranks of the specificity order, literal segments are the most specific
+const (
+	rankEnd = iota
+	rankMultiSegment
+	rankSingleSegment
+	rankLiteral
+)
+
+// host ranks of the specificity order, routes of exact hosts are the most specific
+const (
+	hostRankAny = iota
+	hostRankWildcard
+	hostRankExact
+)
+
+// compareRoutePolicies returns a positive number if route policy rp1 takes precedence over rp2, negative if rp2 does,
+// zero if neither. Route policies with higher priority take precedence, then the ones with more specific paths,
+// then the ones with more specific hosts, then the ones with more header and query parameter conditions.
+func compareRoutePolicies(rp1 models.RoutePolicy, rp2 models.RoutePolicy) int {
+	if rp1.Priority != rp2.Priority {
+		return rp1.Priority - rp2.Priority
+	}
+
+	if c := compareSpecificity(rp1.Path, rp2.Path); c != 0 {
+		return c
+	}
+
+	if c := hostRank(rp1.Hosts) - hostRank(rp2.Hosts); c != 0 {
+		return c
+	}
+
+	return conditionCount(rp1) - conditionCount(rp2)
+}
+
+// hostRank returns the specificity rank of the hosts of a route policy, the rank of its least specific host
+func hostRank(hosts []string) int {
+	if hosts == nil {
+		return hostRankAny
+	}
+
+	for _, h := range hosts {
+		if strings.HasPrefix(h, "*.") {
+			return hostRankWildcard
+		}
+	}
+
+	return hostRankExact
+}
+
+// compareSpecificity returns a positive number if path p1 is more specific than p2, negative if less, zero if equal.
+//
+// Segments are compared position by position, and the first position that differs decides:
+// a literal segment is more specific than a single segment wildcard like * or {id},
+// which is more specific than a multi segment wildcard **, which is more specific than the end of the path.
+// If all positions are equal, the path with more literal characters in its wildcard segments is more specific.
+func compareSpecificity(p1 string, p2 string) int {
+	s1 := pathSegments(strings.Trim(p1, " \t\n/"))
+	s2 := pathSegments(strings.Trim(p2, " \t\n/"))
+
+	literals := 0
+	for i := 0; i < len(s1) || i < len(s2); i++ {
+		r1, r2 := segmentRank(s1, i), segmentRank(s2, i)
+		if r1 != r2 {
+			return r1 - r2
+		}
+
+		if r1 != rankEnd && r1 != rankLiteral {
+			literals += len(segmentLiteral(s1[i])) - len(segmentLiteral(s2[i]))
+		}
+	}
+
+	return literals
+}
+
+// segmentRank returns the specificity rank of the path segment at index i
+func segmentRank(segments []string, i int) int {
+	switch {
+	case i >= len(segments):
+		return rankEnd
+	case strings.Contains(segments[i], "**"):
+		return rankMultiSegment
+	case strings.ContainsAny(segments[i], globMetaChars):
+		return rankSingleSegment
+	}
+
+	return rankLiteral
+}
+
+// segmentLiteral returns the literal characters of a path segment, without wildcards, parameters,
+// character classes and alternatives
+func segmentLiteral(segment string) string {
+	var literal strings.Builder
+
+	depth := 0
+	escaped := false
+
+	for _, c := range segment {
+		switch {
+		case escaped:
+			escaped = false
+			if depth == 0 {
+				literal.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			if depth > 0 {
+				depth--
+			}
+		case c == '*' || c == '?':
+		case depth == 0:
+			literal.WriteRune(c)
+		}
+	}
+
+	return literal.String()
+}
+
+// ambiguousRoutePolicies returns the pairs of route policies, sorted by precedence, that take precedence over each
+// other neither by priority nor by specificity, but may match the same request with the same method.
+// Their order is decided by their paths, then by their order in the configuration.
+func ambiguousRoutePolicies(routePolicies []models.RoutePolicy) [][2]models.RoutePolicy {
+	var ambiguous [][2]models.RoutePolicy
+
+	for i := range routePolicies {
+		for j := i + 1; j < len(routePolicies) && compareRoutePolicies(routePolicies[i], routePolicies[j]) == 0; j++ {
+			if mayMatchSameRequest(routePolicies[i], routePolicies[j]) {
+				ambiguous = append(ambiguous, [2]models.RoutePolicy{routePolicies[i], routePolicies[j]})
+			}
+		}
+	}
+
+	return ambiguous
+}
+
+// mayMatchSameRequest checks if two route policies of the same specificity may match the same request.
+// Routes of the same path are ordered by their methods if only one of them lists methods.
+func mayMatchSameRequest(rp1 models.RoutePolicy, rp2 models.RoutePolicy) bool {
+	if !hostsMayOverlap(rp1.Hosts, rp2.Hosts) || !conditionsMayOverlap(rp1, rp2) {
+		return false
+	}
+
+	s1 := pathSegments(strings.Trim(rp1.Path, " \t\n/"))
+	s2 := pathSegments(strings.Trim(rp2.Path, " \t\n/"))
+
+	samePath := len(s1) == len(s2)
+	for i := 0; i < len(s1) && i < len(s2); i++ {
+		if !segmentsMayOverlap(s1[i], s2[i]) {
+			return false
+		}
+
+		samePath = samePath && s1[i] == s2[i]
+	}
+
+	if rp1.Methods == nil || rp2.Methods == nil {
+		return !samePath || (rp1.Methods == nil && rp2.Methods == nil)
+	}
+
+	for _, m1 := range rp1.Methods {
+		for _, m2 := range rp2.Methods {
+			if m1 == m2 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// segmentsMayOverlap checks if two path segments of the same rank may match the same segment,
+// by their literals, or the literal prefixes and suffixes of wildcard segments
+func segmentsMayOverlap(s1 string, s2 string) bool {
+	if !strings.ContainsAny(s1, globMetaChars) {
+		return s1 == s2
+	}
+
+	p1 := s1[:strings.IndexAny(s1, globMetaChars)]
+	p2 := s2[:strings.IndexAny(s2, globMetaChars)]
+	if !strings.HasPrefix(p1, p2) && !strings.HasPrefix(p2, p1) {
+		return false
+	}
+
+	x1 := s1[strings.LastIndexAny(s1, globMetaChars)+1:]
+	x2 := s2[strings.LastIndexAny(s2, globMetaChars)+1:]
+
+	return strings.HasSuffix(x1, x2) || strings.HasSuffix(x2, x1)
+}
+
+// hostsMayOverlap checks if a request host may match both of the given route policy hosts
+func hostsMayOverlap(hosts1 []string, hosts2 []string) bool {
+	if hosts1 == nil || hosts2 == nil {
+		return true
+	}
+
+	for _, h1 := range hosts1 {
+		for _, h2 := range hosts2 {
+			n1 := strings.ToLower(strings.TrimPrefix(h1, "*."))
+			n2 := strings.ToLower(strings.TrimPrefix(h2, "*."))
+
+			switch {
+			case n1 == n2 && strings.HasPrefix(h1, "*.") == strings.HasPrefix(h2, "*."):
+				return true
+			case strings.HasPrefix(h1, "*.") && strings.HasSuffix(n2, "."+n1),
+				strings.HasPrefix(h2, "*.") && strings.HasSuffix(n1, "."+n2):
+				return true
+			}
+		}
+	}
+
+	return false
+}
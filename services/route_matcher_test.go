@@ -1,6 +1,9 @@
 package services_test
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
 	"reflect"
 	"testing"
 
@@ -31,6 +34,18 @@ func TestRouteMatcherImpl_MatchRoutePolicies(t *testing.T) {
 			want:    nil,
 			wantErr: true,
 		},
+		{
+			name: "method listed twice",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/test", Methods: []string{"GET", "GET"}},
+			},
+			path:   "/test",
+			method: "GET",
+			want: []models.RoutePolicy{
+				{Path: "/test", Methods: []string{"GET", "GET"}},
+			},
+			wantErr: false,
+		},
 		{
 			name: "path error",
 			routePolicies: []models.RoutePolicy{
@@ -185,12 +200,34 @@ func TestRouteMatcherImpl_MatchRoutePolicies(t *testing.T) {
 			want:    []models.RoutePolicy{},
 			wantErr: false,
 		},
+		{
+			name: "matches in configuration order across literal prefixes and methods",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/api/users/{id}", Methods: []string{"GET"}},
+				{Path: "/api/users/*", Methods: []string{"POST"}},
+				{Path: "/api/**"},
+				{Path: "/api/users/42", Methods: []string{"GET", "POST"}},
+				{Path: "/*/users/**", Methods: []string{"GET"}},
+				{Path: "/api/orders/**"},
+				{Path: "/**"},
+			},
+			path:   "/api/users/42",
+			method: "GET",
+			want: []models.RoutePolicy{
+				{Path: "/api/users/{id}", Methods: []string{"GET"}, PathParams: map[string]string{"id": "42"}},
+				{Path: "/api/**"},
+				{Path: "/api/users/42", Methods: []string{"GET", "POST"}},
+				{Path: "/*/users/**", Methods: []string{"GET"}},
+				{Path: "/**"},
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			rm := services.NewRouteMatcher(tt.routePolicies)
+			rm := services.NewRouteMatcher(tt.routePolicies, models.PathConfig{})
 
-			got, err := rm.MatchRoutePolicies(tt.path, tt.method)
+			got, err := rm.MatchRoutePolicies(tt.path, &models.RequestContext{Method: tt.method, Host: "example.com"})
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("MatchRoutePolicies() error = %v, wantErr %v", err, tt.wantErr)
@@ -202,3 +239,422 @@ func TestRouteMatcherImpl_MatchRoutePolicies(t *testing.T) {
 		})
 	}
 }
+
+func TestRouteMatcherImpl_MatchRoutePolicies_PathParams(t *testing.T) {
+	tests := []struct {
+		name          string
+		routePolicies []models.RoutePolicy
+		path          string
+		wantParams    []map[string]string
+	}{
+		{
+			name:          "single parameter",
+			routePolicies: []models.RoutePolicy{{Path: "/users/{id}"}},
+			path:          "/users/42",
+			wantParams:    []map[string]string{{"id": "42"}},
+		},
+		{
+			name:          "parameter matches a single segment",
+			routePolicies: []models.RoutePolicy{{Path: "/users/{id}"}},
+			path:          "/users/42/orders",
+			wantParams:    []map[string]string{},
+		},
+		{
+			name:          "parameter before super wildcard",
+			routePolicies: []models.RoutePolicy{{Path: "/tenants/{tenant}/**"}},
+			path:          "/tenants/acme/users/42",
+			wantParams:    []map[string]string{{"tenant": "acme"}},
+		},
+		{
+			name:          "parameter after super wildcard",
+			routePolicies: []models.RoutePolicy{{Path: "/**/orders/{order}"}},
+			path:          "/tenants/acme/orders/7",
+			wantParams:    []map[string]string{{"order": "7"}},
+		},
+		{
+			name:          "escaped parameter value",
+			routePolicies: []models.RoutePolicy{{Path: "/users/{id}"}},
+			path:          "/users/john%40example.com",
+			wantParams:    []map[string]string{{"id": "john@example.com"}},
+		},
+		{
+			name: "same name captured at different positions",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/tenants/{tid}/users/{id}"},
+				{Path: "/tenants/{id}/**"},
+			},
+			path: "/tenants/victim/users/mine",
+			wantParams: []map[string]string{
+				{"tid": "victim", "id": "mine"},
+				{"id": "victim"},
+			},
+		},
+		{
+			name: "routes without parameters",
+			routePolicies: []models.RoutePolicy{
+				{Path: "/tenants/{id}/**"},
+				{Path: "/**"},
+			},
+			path:       "/tenants/acme/users",
+			wantParams: []map[string]string{{"id": "acme"}, nil},
+		},
+		{
+			name:          "alternatives are not parameters",
+			routePolicies: []models.RoutePolicy{{Path: "/{users,groups}/*"}},
+			path:          "/groups/42",
+			wantParams:    []map[string]string{nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := services.NewRouteMatcher(tt.routePolicies, models.PathConfig{})
+
+			got, err := rm.MatchRoutePolicies(tt.path, &models.RequestContext{Method: "GET", Host: "example.com"})
+			if err != nil {
+				t.Errorf("MatchRoutePolicies() error = %v", err)
+				return
+			}
+
+			params := make([]map[string]string, len(got))
+			for i, rp := range got {
+				params[i] = rp.PathParams
+			}
+
+			if !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("MatchRoutePolicies() params = %v, want %v", params, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestRouteMatcherImpl_MatchRoutePolicies_Canonicalization(t *testing.T) {
+	routePolicies := []models.RoutePolicy{
+		{Path: "/admin/**", PolicyName: "Admin"},
+		{Path: "/files/{name}", PolicyName: "Reader"},
+		{Path: "/**", AllowAnonymous: true},
+	}
+
+	tests := []struct {
+		name       string
+		pathConfig models.PathConfig
+		path       string
+		want       []string
+		wantParams map[string]string
+		wantErr    bool
+	}{
+		{
+			name: "dot segments",
+			path: "/public/../admin/users",
+			want: []string{"/admin/**", "/**"},
+		},
+		{
+			name: "encoded dot segments",
+			path: "/public/%2e%2e/admin/users",
+			want: []string{"/admin/**", "/**"},
+		},
+		{
+			name: "dot segments above root",
+			path: "/../../admin",
+			want: []string{"/admin/**", "/**"},
+		},
+		{
+			name: "duplicate slashes",
+			path: "//admin//users",
+			want: []string{"/admin/**", "/**"},
+		},
+		{
+			name:    "encoded slash rejected by default",
+			path:    "/admin%2F..%2Fpublic",
+			wantErr: true,
+		},
+		{
+			name:       "encoded slash decoded",
+			pathConfig: models.PathConfig{EncodedSlashes: "decode"},
+			path:       "/files/a%2F..%2F..%2Fadmin",
+			want:       []string{"/admin/**", "/**"},
+		},
+		{
+			name: "case sensitive by default",
+			path: "/ADMIN/users",
+			want: []string{"/**"},
+		},
+		{
+			name:       "case insensitive",
+			pathConfig: models.PathConfig{CaseInsensitive: true},
+			path:       "/ADMIN/users",
+			want:       []string{"/admin/**", "/**"},
+		},
+		{
+			name:       "case insensitive keeps parameter case",
+			pathConfig: models.PathConfig{CaseInsensitive: true},
+			path:       "/Files/README",
+			want:       []string{"/files/{name}", "/**"},
+			wantParams: map[string]string{"name": "README"},
+		},
+		{
+			name:       "matrix parameters kept by default",
+			path:       "/files/report;v=1",
+			want:       []string{"/files/{name}", "/**"},
+			wantParams: map[string]string{"name": "report;v=1"},
+		},
+		{
+			name:       "matrix parameters stripped",
+			pathConfig: models.PathConfig{StripMatrixParams: true},
+			path:       "/admin;jsessionid=x/users",
+			want:       []string{"/admin/**", "/**"},
+		},
+		{
+			name:       "encoded semicolon is not a matrix parameter",
+			pathConfig: models.PathConfig{StripMatrixParams: true},
+			path:       "/files/report%3Bv=1",
+			want:       []string{"/files/{name}", "/**"},
+			wantParams: map[string]string{"name": "report;v=1"},
+		},
+		{
+			name:       "parameter value decoded once",
+			path:       "/files/100%2525",
+			want:       []string{"/files/{name}", "/**"},
+			wantParams: map[string]string{"name": "100%25"},
+		},
+		{
+			name:    "null character",
+			path:    "/admin%00/users",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := services.NewRouteMatcher(routePolicies, tt.pathConfig)
+
+			got, err := rm.MatchRoutePolicies(tt.path, &models.RequestContext{Method: "GET", Host: "example.com"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MatchRoutePolicies() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var paths []string
+			for _, rp := range got {
+				paths = append(paths, rp.Path)
+			}
+
+			if !reflect.DeepEqual(paths, tt.want) {
+				t.Errorf("MatchRoutePolicies() got = %v, want %v", paths, tt.want)
+			}
+
+			if tt.wantParams != nil && !reflect.DeepEqual(got[0].PathParams, tt.wantParams) {
+				t.Errorf("MatchRoutePolicies() params = %v, want %v", got[0].PathParams, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestRouteMatcherImpl_MatchRoutePolicies_Hosts(t *testing.T) {
+	routePolicies := []models.RoutePolicy{
+		{Path: "/users/**", Hosts: []string{"admin.example.com"}, PolicyName: "Admin"},
+		{Path: "/users/**", Hosts: []string{"*.tenant.example.com"}, PolicyName: "TenantMember"},
+		{Path: "/users/**", PolicyName: "User"},
+	}
+
+	tests := []struct {
+		name string
+		host string
+		want []string
+	}{
+		{
+			name: "exact host",
+			host: "admin.example.com",
+			want: []string{"Admin", "User"},
+		},
+		{
+			name: "exact host with port and different case",
+			host: "Admin.Example.com:8443",
+			want: []string{"Admin", "User"},
+		},
+		{
+			name: "wildcard host",
+			host: "acme.tenant.example.com",
+			want: []string{"TenantMember", "User"},
+		},
+		{
+			name: "wildcard host does not match its parent",
+			host: "tenant.example.com",
+			want: []string{"User"},
+		},
+		{
+			name: "other host",
+			host: "api.example.com",
+			want: []string{"User"},
+		},
+		{
+			name: "no host",
+			host: "",
+			want: []string{"User"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := services.NewRouteMatcher(routePolicies, models.PathConfig{})
+
+			got, err := rm.MatchRoutePolicies("/users/42", &models.RequestContext{Method: "GET", Host: tt.host})
+			if err != nil {
+				t.Errorf("MatchRoutePolicies() error = %v", err)
+				return
+			}
+
+			var names []string
+			for _, rp := range got {
+				names = append(names, rp.PolicyName)
+			}
+
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("MatchRoutePolicies() got = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteMatcherImpl_MatchRoutePolicies_Conditions(t *testing.T) {
+	present, absent := true, false
+
+	routePolicies := []models.RoutePolicy{
+		{
+			Path:       "/orders/**",
+			Headers:    map[string]models.RequestMatch{"accept-version": {Value: "2"}},
+			PolicyName: "V2Header",
+		},
+		{
+			Path:       "/orders/**",
+			Query:      map[string]models.RequestMatch{"api-version": {Regex: "^2\\.[0-9]+$"}},
+			PolicyName: "V2Query",
+		},
+		{
+			Path:       "/orders/**",
+			Headers:    map[string]models.RequestMatch{"X-Debug": {Present: &present}},
+			PolicyName: "Debug",
+		},
+		{
+			Path: "/orders/**",
+			Headers: map[string]models.RequestMatch{
+				"Accept-Version": {Present: &absent},
+			},
+			Query: map[string]models.RequestMatch{
+				"api-version": {Present: &absent},
+			},
+			PolicyName: "Unversioned",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		query  url.Values
+		want   []string
+	}{
+		{
+			name: "no conditions met but absence",
+			want: []string{"Unversioned"},
+		},
+		{
+			name:   "exact header value",
+			header: http.Header{"Accept-Version": {"2"}},
+			want:   []string{"V2Header"},
+		},
+		{
+			name:   "any of repeated header values",
+			header: http.Header{"Accept-Version": {"1", "2"}},
+			want:   []string{"V2Header"},
+		},
+		{
+			name:   "other header value",
+			header: http.Header{"Accept-Version": {"1"}},
+			want:   nil,
+		},
+		{
+			name:  "query parameter regex",
+			query: url.Values{"api-version": {"2.1"}},
+			want:  []string{"V2Query"},
+		},
+		{
+			name:  "query parameter regex not matched",
+			query: url.Values{"api-version": {"1.0"}},
+			want:  nil,
+		},
+		{
+			name:   "present header and absent parameters",
+			header: http.Header{"X-Debug": {""}},
+			want:   []string{"Debug", "Unversioned"},
+		},
+		{
+			name:   "all conditions of several routes",
+			header: http.Header{"Accept-Version": {"2"}, "X-Debug": {"1"}},
+			query:  url.Values{"api-version": {"2.0"}},
+			want:   []string{"V2Header", "V2Query", "Debug"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := services.NewRouteMatcher(routePolicies, models.PathConfig{})
+
+			got, err := rm.MatchRoutePolicies("/orders/42", &models.RequestContext{
+				Method: "GET",
+				Header: tt.header,
+				Query:  tt.query,
+			})
+			if err != nil {
+				t.Errorf("MatchRoutePolicies() error = %v", err)
+				return
+			}
+
+			var names []string
+			for _, rp := range got {
+				names = append(names, rp.PolicyName)
+			}
+
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("MatchRoutePolicies() got = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+// benchmarkRoutePolicies generates n route policies of a typical REST API, with literal, parameter and wildcard routes
+func benchmarkRoutePolicies(n int) []models.RoutePolicy {
+	routePolicies := make([]models.RoutePolicy, 0, n)
+
+	for i := 0; len(routePolicies) < n; i++ {
+		resource := fmt.Sprintf("/api/v1/resource%d", i)
+
+		for _, rp := range []models.RoutePolicy{
+			{Path: resource + "/{id}/items/{itemId}", Methods: []string{"GET"}, PolicyName: "Reader"},
+			{Path: resource + "/{id}", Methods: []string{"PUT", "DELETE"}, PolicyName: "Writer"},
+			{Path: resource + "/*/export", PolicyName: "Exporter"},
+			{Path: resource + "/**", PolicyName: "Reader"},
+		} {
+			if len(routePolicies) < n {
+				routePolicies = append(routePolicies, rp)
+			}
+		}
+	}
+
+	return append(routePolicies, models.RoutePolicy{Path: "/**", AllowAnonymous: true})
+}
+
+func BenchmarkRouteMatcherImpl_MatchRoutePolicies(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("%d policies", n), func(b *testing.B) {
+			m := services.NewRouteMatcher(benchmarkRoutePolicies(n), models.PathConfig{})
+			path := fmt.Sprintf("/api/v1/resource%d/42/items/7", n/8)
+			request := &models.RequestContext{Method: "GET", Host: "example.com"}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_, err := m.MatchRoutePolicies(path, request)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,24 +1,35 @@
 package services
 
 import (
+	"bytes"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 
 	"github.com/google/uuid"
 
 	"github.com/kaancfidan/bouncer/models"
 )
 
+// maxFormSize is the largest form body read to look for tokens in
+const maxFormSize = 1 << 20
+
 // Server struct holds references to necessary services
 type Server struct {
-	upstream      http.Handler
-	routeMatcher  RouteMatcher
-	authorizer    Authorizer
-	authenticator Authenticator
-	config        models.ServerConfig
-	proxyEnabled  bool
+	upstream       http.Handler
+	routeMatcher   RouteMatcher
+	authorizer     Authorizer
+	authenticator  Authenticator
+	config         models.ServerConfig
+	proxyEnabled   bool
+	trustedProxies []*net.IPNet
+	// readsForm is set if the authenticator reads tokens from form fields, form bodies are not buffered otherwise
+	readsForm bool
 }
 
 // NewServer checks if upstream is set to enable proxy behavior, then returns a new Server instance
@@ -32,12 +43,14 @@ func NewServer(
 	proxyEnabled := upstream != nil && !reflect.ValueOf(upstream).IsNil()
 
 	return &Server{
-		upstream:      upstream,
-		routeMatcher:  routeMatcher,
-		authorizer:    authorizer,
-		authenticator: authenticator,
-		config:        config,
-		proxyEnabled:  proxyEnabled,
+		upstream:       upstream,
+		routeMatcher:   routeMatcher,
+		authorizer:     authorizer,
+		authenticator:  authenticator,
+		config:         config,
+		proxyEnabled:   proxyEnabled,
+		trustedProxies: parseTrustedProxies(config.TrustedProxies),
+		readsForm:      authenticator.ReadsForm(),
 	}
 }
 
@@ -46,25 +59,44 @@ func NewServer(
 func (s Server) Handle(writer http.ResponseWriter, request *http.Request) {
 	requestID := uuid.New()
 
-	var path, method string
-	if s.config.OriginalRequestHeaders == nil {
-		path = request.URL.Path
-		method = request.Method
-	} else {
-		parsed, err := url.Parse(request.Header.Get(s.config.OriginalRequestHeaders.Path))
+	requestContext := &models.RequestContext{
+		Method:   request.Method,
+		Path:     request.URL.Path,
+		Query:    request.URL.Query(),
+		Header:   request.Header,
+		Host:     request.Host,
+		ClientIP: clientIP(request, s.trustedProxies),
+	}
+
+	escapedPath := request.URL.EscapedPath()
+
+	if s.config.OriginalRequestHeaders != nil {
+		parsed, err := url.ParseRequestURI(request.Header.Get(s.config.OriginalRequestHeaders.Path))
 		if err != nil {
 			log.Printf("[%v] Request path read from header could not be parsed: %v", requestID, err)
 			writer.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		path = parsed.Path
-		method = request.Header.Get(s.config.OriginalRequestHeaders.Method)
+		escapedPath = parsed.EscapedPath()
+		requestContext.Path = parsed.Path
+		requestContext.Query = parsed.Query()
+		requestContext.Method = request.Header.Get(s.config.OriginalRequestHeaders.Method)
+
+		if s.config.OriginalRequestHeaders.Host != "" {
+			requestContext.Host = request.Header.Get(s.config.OriginalRequestHeaders.Host)
+		}
+	}
+
+	log.Printf("[%v] Request received: %s %s", requestID, requestContext.Method, escapedPath)
+
+	if !s.canonicalize(writer, request, requestID, requestContext, escapedPath) {
+		return
 	}
 
-	log.Printf("[%v] Request received: %s %s", requestID, method, path)
+	method := requestContext.Method
 
-	matchedPolicies, err := s.routeMatcher.MatchRoutePolicies(path, method)
+	matchedPolicies, err := s.routeMatcher.MatchRoutePolicies(escapedPath, requestContext)
 	if err != nil {
 		log.Printf("[%v] Error while matching path policies: %v", requestID, err)
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -80,15 +112,23 @@ func (s Server) Handle(writer http.ResponseWriter, request *http.Request) {
 
 	var matchedPolicyNames []string
 	for _, r := range matchedPolicies {
-		if r.PolicyName != "" {
-			matchedPolicyNames = append(matchedPolicyNames, r.PolicyName)
-		}
+		matchedPolicyNames = append(matchedPolicyNames, routePolicyNames(r)...)
 	}
 
 	log.Printf("[%v] Policies matched: %v", requestID, matchedPolicyNames)
 
 	// check if the most specific route allows anonymous requests
 	if s.authorizer.IsAnonymousAllowed(matchedPolicies, method) {
+		if s.denied(writer, requestID, matchedPolicies, requestContext, nil) {
+			return
+		}
+
+		decision := models.Decision{Allowed: true}
+		if len(matchedPolicies) != 0 {
+			decision.RoutePolicy = matchedPolicies[0].Path
+		}
+
+		s.decided(writer, requestID, decision)
 		log.Printf("[%v] Allowed anonymous request.", requestID)
 
 		if s.proxyEnabled {
@@ -100,25 +140,47 @@ func (s Server) Handle(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	authHeader := request.Header.Get("Authorization")
+	if s.readsForm {
+		requestContext.Form = readForm(request)
+	}
 
-	claims, err := s.authenticator.Authenticate(authHeader)
+	claims, issuer, err := s.authenticator.Authenticate(request.Context(), requestContext)
 	if err != nil {
 		log.Printf("[%v] Error while authenticating: %v", requestID, err)
+
+		if s.denied(writer, requestID, matchedPolicies, requestContext, nil) {
+			return
+		}
+
 		writer.Header().Add("WWW-Authenticate", "Bearer")
 		writer.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	failedClaim, err := s.authorizer.Authorize(matchedPolicyNames, claims)
+	if s.denied(writer, requestID, matchedPolicies, requestContext, claims) {
+		return
+	}
+
+	failedRequirement := s.authorizer.CheckTokenRequirements(matchedPolicies, issuer, claims)
+	if failedRequirement != "" {
+		log.Printf("[%v] Route requirement failed: %s.", requestID, failedRequirement)
+		s.decided(writer, requestID, models.Decision{
+			Requirements: []models.RequirementResult{{Requirement: failedRequirement}},
+		})
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	decision, err := s.authorizer.Authorize(request.Context(), matchedPolicies, requestContext, claims)
 	if err != nil {
 		log.Printf("[%v] Error while authorizing: %v", requestID, err)
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if failedClaim != "" {
-		log.Printf("[%v] Check for claim \"%s\" failed.", requestID, failedClaim)
+	s.decided(writer, requestID, decision)
+
+	if !decision.Allowed {
 		writer.WriteHeader(http.StatusForbidden)
 		return
 	}
@@ -132,3 +194,194 @@ func (s Server) Handle(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(http.StatusOK)
 	}
 }
+
+// canonicalize replaces the request path with its canonical form, or rejects the request with 400
+// if the path cannot be canonicalized, or is not canonical and non-canonical paths are rejected.
+// Non-canonical paths are always rejected in authorization extension mode, since the original request
+// is forwarded by the caller with its non-canonical path.
+// In proxy mode, non-canonical paths are forwarded upstream in their canonical form,
+// canonical paths are forwarded as they were escaped by the client.
+func (s Server) canonicalize(
+	writer http.ResponseWriter,
+	request *http.Request,
+	requestID uuid.UUID,
+	requestContext *models.RequestContext,
+	escapedPath string) bool {
+
+	canonical, err := canonicalPath(escapedPath, s.config.Paths)
+	if err != nil {
+		log.Printf("[%v] Request path rejected: %v", requestID, err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+
+	requestContext.Path = canonical
+
+	if isCanonicalPath(escapedPath, canonical) {
+		return true
+	}
+
+	if s.config.Paths.RejectNonCanonical || !s.proxyEnabled {
+		log.Printf("[%v] Request path rejected: %s is not canonical, %s expected", requestID, escapedPath, canonical)
+		writer.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+
+	log.Printf("[%v] Request path canonicalized: %s", requestID, canonical)
+
+	request.URL.Path = canonical
+	request.URL.RawPath = ""
+
+	return true
+}
+
+// denied checks the deny route policies and writes the response if the request is denied.
+// Anonymous requests, with nil claims, are rejected with the configured anonymous deny status.
+func (s Server) denied(
+	writer http.ResponseWriter,
+	requestID uuid.UUID,
+	matchedPolicies []models.RoutePolicy,
+	requestContext *models.RequestContext,
+	claims map[string]any) bool {
+
+	deniedBy, err := s.authorizer.CheckDenyPolicies(matchedPolicies, requestContext, claims)
+	if err != nil {
+		log.Printf("[%v] Error while checking deny policies: %v", requestID, err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+
+	if deniedBy == "" {
+		return false
+	}
+
+	log.Printf("[%v] Denied by route policy %s.", requestID, deniedBy)
+	s.decided(writer, requestID, models.Decision{
+		RoutePolicy:  deniedBy,
+		Requirements: []models.RequirementResult{{RoutePolicy: deniedBy, Requirement: "deny"}},
+	})
+
+	if claims != nil {
+		writer.WriteHeader(http.StatusForbidden)
+		return true
+	}
+
+	status := s.config.AnonymousDenyStatus
+	if status == 0 {
+		status = http.StatusUnauthorized
+	}
+
+	if status == http.StatusUnauthorized {
+		writer.Header().Add("WWW-Authenticate", "Bearer")
+	}
+
+	writer.WriteHeader(status)
+	return true
+}
+
+// decided logs an authorization decision with its failed requirements,
+// and summarizes it in the decision header if one is configured
+func (s Server) decided(writer http.ResponseWriter, requestID uuid.UUID, decision models.Decision) {
+	log.Printf("[%v] Authorization decision in %v: %v", requestID, decision.Duration, decision)
+
+	for _, r := range decision.Failed() {
+		if r.Actual == nil && r.Expected == nil {
+			continue
+		}
+
+		operator := r.Operator
+		if operator == "" {
+			operator = operatorEquals
+		}
+
+		log.Printf("[%v] Check for %q failed: actual %q, expected %s %q",
+			requestID, r.Requirement, r.Actual, operator, r.Expected)
+	}
+
+	if s.config.DecisionHeader != "" {
+		writer.Header().Set(s.config.DecisionHeader, decision.String())
+	}
+}
+
+// readForm parses URL encoded form bodies up to maxFormSize, and rewinds the body to be forwarded to upstream
+func readForm(request *http.Request) url.Values {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxFormSize+1))
+	request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+
+	if err != nil || len(body) > maxFormSize {
+		return nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil
+	}
+
+	return form
+}
+
+// parseTrustedProxies parses the ranges of trusted proxies for clientIP
+func parseTrustedProxies(cidrs []string) []*net.IPNet {
+	var trustedProxies []*net.IPNet
+	for _, cidr := range cidrs {
+		// a range that does not parse trusts no proxy, X-Forwarded-For is not read for any address in it
+		if network, err := parseNetwork(cidr); err == nil {
+			trustedProxies = append(trustedProxies, network)
+		}
+	}
+
+	return trustedProxies
+}
+
+// clientIP returns the address of the client. If the request comes from a trusted proxy, X-Forwarded-For is read from
+// right to left, and the first address that is not a trusted proxy is the client.
+func clientIP(request *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+
+	var forwarded []string
+	for _, header := range request.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0 && isTrusted(ip, trustedProxies); i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+	}
+
+	return ip
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
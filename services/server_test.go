@@ -2,9 +2,12 @@ package services_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,6 +18,13 @@ import (
 	"github.com/kaancfidan/bouncer/services"
 )
 
+// requestWith matches request contexts of the given method and host
+func requestWith(method string, host string) any {
+	return mock.MatchedBy(func(request *models.RequestContext) bool {
+		return request.Method == method && request.Host == host
+	})
+}
+
 func TestServer_Handle(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -36,7 +46,7 @@ func TestServer_Handle(t *testing.T) {
 				authorizer *mocks.Authorizer) {
 
 				routeMatcher.On("MatchRoutePolicies",
-					request.URL.Path, request.Method).Return(nil, fmt.Errorf("path error"))
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(nil, fmt.Errorf("path error"))
 			},
 			wantUpstreamCalled: false,
 			wantStatusCode:     http.StatusInternalServerError,
@@ -78,9 +88,11 @@ func TestServer_Handle(t *testing.T) {
 				}
 
 				routeMatcher.On("MatchRoutePolicies",
-					request.URL.Path, request.Method).Return(matchedRoutes, nil)
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
 
 				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(true)
+
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
 			},
 			wantUpstreamCalled: false,
 			wantStatusCode:     http.StatusOK,
@@ -110,11 +122,13 @@ func TestServer_Handle(t *testing.T) {
 
 				routeMatcher.On("MatchRoutePolicies",
 					request.Header.Get("X-Original-URI"),
-					request.Header.Get("X-Original-Method")).Return(matchedRoutes, nil)
+					requestWith(request.Header.Get("X-Original-Method"), request.Host)).Return(matchedRoutes, nil)
 
 				authorizer.On("IsAnonymousAllowed",
 					matchedRoutes,
 					request.Header.Get("X-Original-Method")).Return(true)
+
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
 			},
 			wantUpstreamCalled: false,
 			wantStatusCode:     http.StatusOK,
@@ -137,9 +151,11 @@ func TestServer_Handle(t *testing.T) {
 				}
 
 				routeMatcher.On("MatchRoutePolicies",
-					request.URL.Path, request.Method).Return(matchedRoutes, nil)
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
 
 				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(true)
+
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
 			},
 			wantUpstreamCalled: true,
 			wantStatusCode:     0,
@@ -162,19 +178,18 @@ func TestServer_Handle(t *testing.T) {
 				}
 
 				routeMatcher.On("MatchRoutePolicies",
-					request.URL.Path, request.Method).Return(matchedRoutes, nil)
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
 
 				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
 
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
+
 				authenticator.On("Authenticate",
-					mock.Anything).Return(claims, nil)
+					mock.Anything, mock.Anything).Return(claims, "", nil)
 
-				authorizer.On("Authorize",
-					mock.MatchedBy(
-						func(policyNames []string) bool {
-							return len(policyNames) == 0
-						}),
-					claims).Return("", nil)
+				authorizer.On("CheckTokenRequirements", matchedRoutes, "", claims).Return("")
+
+				authorizer.On("Authorize", mock.Anything, matchedRoutes, mock.Anything, claims).Return(models.Decision{Allowed: true}, nil)
 			},
 			wantUpstreamCalled: false,
 			wantStatusCode:     http.StatusOK,
@@ -197,19 +212,18 @@ func TestServer_Handle(t *testing.T) {
 				}
 
 				routeMatcher.On("MatchRoutePolicies",
-					request.URL.Path, request.Method).Return(matchedRoutes, nil)
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
 
 				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
 
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
+
 				authenticator.On("Authenticate",
-					mock.Anything).Return(claims, nil)
+					mock.Anything, mock.Anything).Return(claims, "", nil)
+
+				authorizer.On("CheckTokenRequirements", matchedRoutes, "", claims).Return("")
 
-				authorizer.On("Authorize",
-					mock.MatchedBy(
-						func(policyNames []string) bool {
-							return len(policyNames) == 0
-						}),
-					claims).Return("", nil)
+				authorizer.On("Authorize", mock.Anything, matchedRoutes, mock.Anything, claims).Return(models.Decision{Allowed: true}, nil)
 			},
 			wantUpstreamCalled: true,
 			wantStatusCode:     0,
@@ -228,12 +242,14 @@ func TestServer_Handle(t *testing.T) {
 				matchedRoutes := make([]models.RoutePolicy, 0)
 
 				routeMatcher.On("MatchRoutePolicies",
-					request.URL.Path, request.Method).Return(matchedRoutes, nil)
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
 
 				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
 
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
+
 				authenticator.On("Authenticate",
-					mock.Anything).Return(nil, fmt.Errorf("the guy is an imposter"))
+					mock.Anything, mock.Anything).Return(nil, "", fmt.Errorf("the guy is an imposter"))
 			},
 			wantUpstreamCalled: false,
 			wantStatusCode:     http.StatusUnauthorized,
@@ -261,19 +277,57 @@ func TestServer_Handle(t *testing.T) {
 				}
 
 				routeMatcher.On("MatchRoutePolicies",
-					request.URL.Path, request.Method).Return(matchedRoutes, nil)
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
+
+				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
+
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
+
+				authenticator.On("Authenticate",
+					mock.Anything, mock.Anything).Return(claims, "", nil)
+
+				authorizer.On("CheckTokenRequirements", matchedRoutes, "", claims).Return("")
+
+				authorizer.On("Authorize", mock.Anything, matchedRoutes, mock.Anything, claims).Return(models.Decision{
+					Requirements: []models.RequirementResult{{Requirement: "SomePolicy"}},
+				}, nil)
+			},
+			wantUpstreamCalled: false,
+			wantStatusCode:     http.StatusForbidden,
+		},
+		{
+			name:         "authentication - success, issuer not allowed",
+			requestPath:  "/some/path",
+			proxyEnabled: false,
+			expectations: func(
+				request *http.Request,
+				routeMatcher *mocks.RouteMatcher,
+				authenticator *mocks.Authenticator,
+				authorizer *mocks.Authorizer) {
+
+				matchedRoutes := []models.RoutePolicy{
+					{
+						Path:    "/",
+						Issuers: []string{"https://internal"},
+					},
+				}
+
+				claims := map[string]any{
+					"claim": "value",
+				}
+
+				routeMatcher.On("MatchRoutePolicies",
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
 
 				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
 
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
+
 				authenticator.On("Authenticate",
-					mock.Anything).Return(claims, nil)
+					mock.Anything, mock.Anything).Return(claims, "https://customers", nil)
 
-				authorizer.On("Authorize",
-					mock.MatchedBy(
-						func(policyNames []string) bool {
-							return len(policyNames) == 1 && policyNames[0] == "SomePolicy"
-						}),
-					claims).Return("SomePolicy", nil)
+				authorizer.On("CheckTokenRequirements", matchedRoutes, "https://customers", claims).
+					Return(`issuer "https://customers"`)
 			},
 			wantUpstreamCalled: false,
 			wantStatusCode:     http.StatusForbidden,
@@ -301,19 +355,137 @@ func TestServer_Handle(t *testing.T) {
 				}
 
 				routeMatcher.On("MatchRoutePolicies",
-					request.URL.Path, request.Method).Return(matchedRoutes, nil)
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
 
 				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
 
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, mock.Anything).Return("", nil)
+
 				authenticator.On("Authenticate",
-					mock.Anything).Return(claims, nil)
+					mock.Anything, mock.Anything).Return(claims, "", nil)
 
-				authorizer.On("Authorize",
-					mock.MatchedBy(
-						func(policyNames []string) bool {
-							return len(policyNames) == 1 && policyNames[0] == "SomePolicy"
-						}),
-					claims).Return("", fmt.Errorf("SomePolicy does not exist"))
+				authorizer.On("CheckTokenRequirements", matchedRoutes, "", claims).Return("")
+
+				authorizer.On("Authorize", mock.Anything, matchedRoutes, mock.Anything, claims).
+					Return(models.Decision{}, fmt.Errorf("SomePolicy does not exist"))
+			},
+			wantUpstreamCalled: false,
+			wantStatusCode:     http.StatusInternalServerError,
+		},
+		{
+			name:         "authentication - success, denied",
+			requestPath:  "/admin/users",
+			proxyEnabled: true,
+			expectations: func(
+				request *http.Request,
+				routeMatcher *mocks.RouteMatcher,
+				authenticator *mocks.Authenticator,
+				authorizer *mocks.Authorizer) {
+
+				matchedRoutes := []models.RoutePolicy{
+					{Path: "/admin/**", Deny: true, PolicyName: "Locked"},
+				}
+
+				claims := map[string]any{
+					"account_status": "locked",
+				}
+
+				routeMatcher.On("MatchRoutePolicies",
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
+
+				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
+
+				authenticator.On("Authenticate",
+					mock.Anything, mock.Anything).Return(claims, "", nil)
+
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, claims).Return("/admin/**", nil)
+			},
+			wantUpstreamCalled: false,
+			wantStatusCode:     http.StatusForbidden,
+		},
+		{
+			name:         "allow anonymous, denied",
+			requestPath:  "/some/path",
+			proxyEnabled: true,
+			expectations: func(
+				request *http.Request,
+				routeMatcher *mocks.RouteMatcher,
+				authenticator *mocks.Authenticator,
+				authorizer *mocks.Authorizer) {
+
+				matchedRoutes := []models.RoutePolicy{
+					{Path: "/some/path", Deny: true},
+					{Path: "/", AllowAnonymous: true},
+				}
+
+				routeMatcher.On("MatchRoutePolicies",
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
+
+				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(true)
+
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything,
+					map[string]any(nil)).Return("/some/path", nil)
+			},
+			wantUpstreamCalled: false,
+			wantStatusCode:     http.StatusUnauthorized,
+		},
+		{
+			name:         "authentication - failed, denied with configured status",
+			requestPath:  "/some/path",
+			config:       models.ServerConfig{AnonymousDenyStatus: http.StatusForbidden},
+			proxyEnabled: true,
+			expectations: func(
+				request *http.Request,
+				routeMatcher *mocks.RouteMatcher,
+				authenticator *mocks.Authenticator,
+				authorizer *mocks.Authorizer) {
+
+				matchedRoutes := []models.RoutePolicy{
+					{Path: "/some/path", Deny: true},
+				}
+
+				routeMatcher.On("MatchRoutePolicies",
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
+
+				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
+
+				authenticator.On("Authenticate",
+					mock.Anything, mock.Anything).Return(nil, "", fmt.Errorf("no token found in the request"))
+
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything,
+					map[string]any(nil)).Return("/some/path", nil)
+			},
+			wantUpstreamCalled: false,
+			wantStatusCode:     http.StatusForbidden,
+		},
+		{
+			name:         "deny policy error",
+			requestPath:  "/some/path",
+			proxyEnabled: false,
+			expectations: func(
+				request *http.Request,
+				routeMatcher *mocks.RouteMatcher,
+				authenticator *mocks.Authenticator,
+				authorizer *mocks.Authorizer) {
+
+				matchedRoutes := []models.RoutePolicy{
+					{Path: "/some/path", Deny: true, PolicyName: "SomePolicy"},
+				}
+
+				claims := map[string]any{
+					"claim": "value",
+				}
+
+				routeMatcher.On("MatchRoutePolicies",
+					request.URL.Path, requestWith(request.Method, request.Host)).Return(matchedRoutes, nil)
+
+				authorizer.On("IsAnonymousAllowed", matchedRoutes, request.Method).Return(false)
+
+				authenticator.On("Authenticate",
+					mock.Anything, mock.Anything).Return(claims, "", nil)
+
+				authorizer.On("CheckDenyPolicies", matchedRoutes, mock.Anything, claims).
+					Return("", fmt.Errorf("SomePolicy does not exist"))
 			},
 			wantUpstreamCalled: false,
 			wantStatusCode:     http.StatusInternalServerError,
@@ -352,6 +524,7 @@ func TestServer_Handle(t *testing.T) {
 
 			routeMatcher := &mocks.RouteMatcher{}
 			authenticator := &mocks.Authenticator{}
+			authenticator.On("ReadsForm").Return(false)
 			authorizer := &mocks.Authorizer{}
 
 			s := services.NewServer(
@@ -454,7 +627,62 @@ func TestIntegration(t *testing.T) {
 		" - path: /test\n" +
 		"   allowAnonymous: false\n"
 
+	tokenSourceCfg := "server:\n" +
+		" originalRequestHeaders:\n" +
+		"  method: X-Original-Method\n" +
+		"  path: X-Original-URI\n" +
+		"authentication:\n" +
+		" tokenSources:\n" +
+		"  - header: Authorization\n" +
+		"    scheme: Bearer\n" +
+		"  - cookie: session\n" +
+		"  - query: access_token\n" +
+		"  - form: access_token\n" +
+		"claimPolicies: {}\n" +
+		"routePolicies: []\n"
+
+	pathParamCfg := "server:\n" +
+		" originalRequestHeaders:\n" +
+		"  method: X-Original-Method\n" +
+		"  path: X-Original-URI\n" +
+		"claimPolicies:\n" +
+		" Owner:\n" +
+		"  - claim: name\n" +
+		"    valueFrom: path.user\n" +
+		"routePolicies:\n" +
+		" - path: /users/{user}/**\n" +
+		"   policyName: Owner\n"
+
+	clientIPCfg := "server:\n" +
+		" trustedProxies: [192.0.2.0/24]\n" +
+		"claimPolicies:\n" +
+		" Internal:\n" +
+		"  - cidrs: [10.0.0.0/8]\n" +
+		"routePolicies:\n" +
+		" - path: /**\n" +
+		"   policyName: Internal\n"
+
+	denyCfg := "server:\n" +
+		" anonymousDenyStatus: 403\n" +
+		"claimPolicies:\n" +
+		" Named:\n" +
+		"  - claim: name\n" +
+		"    values: [John Doe]\n" +
+		"routePolicies:\n" +
+		" - path: /**\n" +
+		"   allowAnonymous: true\n" +
+		" - path: /internal/**\n" +
+		"   deny: true\n" +
+		" - path: /members/**\n" +
+		"   allowAnonymous: false\n" +
+		" - path: /members/**\n" +
+		"   deny: true\n" +
+		"   policyName: Named\n"
+
 	signingKey := []byte("iH0dQSVASteCf0ko3E9Ae9-rb_Ob4JD4bKVZQ7cTJphLxdhkOdTyXyFpk1nCASCx")
+	token := "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
+		"eyJuYW1lIjoiSm9obiBEb2UifQ." +
+		"fVd54ocVD8GYRqBqTvit8aJm0tyesbocOTlOfiv_m1Y"
 
 	tests := []struct {
 		name           string
@@ -462,6 +690,8 @@ func TestIntegration(t *testing.T) {
 		method         string
 		path           string
 		headers        map[string]string
+		body           string
+		remoteAddr     string
 		wantStatusCode int
 	}{
 		{
@@ -684,6 +914,148 @@ func TestIntegration(t *testing.T) {
 			},
 			wantStatusCode: http.StatusUnauthorized,
 		},
+		{
+			name:       "token source example - cookie",
+			configYaml: tokenSourceCfg,
+			method:     "GET",
+			path:       "/auth",
+			headers: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URI":    "/test",
+				"Cookie":            "session=" + token,
+			},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:       "token source example - original query parameter",
+			configYaml: tokenSourceCfg,
+			method:     "GET",
+			path:       "/auth",
+			headers: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URI":    "/test?access_token=" + token,
+			},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:       "token source example - auth request query parameter",
+			configYaml: tokenSourceCfg,
+			method:     "GET",
+			path:       "/auth?access_token=" + token,
+			headers: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URI":    "/test",
+			},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "token source example - form field",
+			configYaml: tokenSourceCfg,
+			method:     "POST",
+			path:       "/auth",
+			headers: map[string]string{
+				"X-Original-Method": "POST",
+				"X-Original-URI":    "/test",
+				"Content-Type":      "application/x-www-form-urlencoded",
+			},
+			body:           "name=John&access_token=" + token,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:       "path parameter example - owner",
+			configYaml: pathParamCfg,
+			method:     "GET",
+			path:       "/auth",
+			headers: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URI":    "/users/John%20Doe/settings",
+				"Authorization":     "Bearer " + token,
+			},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:       "path parameter example - other user",
+			configYaml: pathParamCfg,
+			method:     "GET",
+			path:       "/auth",
+			headers: map[string]string{
+				"X-Original-Method": "GET",
+				"X-Original-URI":    "/users/Jane%20Doe/settings",
+				"Authorization":     "Bearer " + token,
+			},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:       "client ip example - direct",
+			configYaml: clientIPCfg,
+			method:     "GET",
+			path:       "/test",
+			headers: map[string]string{
+				"Authorization": "Bearer " + token,
+			},
+			remoteAddr:     "10.0.0.5:41234",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:       "client ip example - forwarded by trusted proxy",
+			configYaml: clientIPCfg,
+			method:     "GET",
+			path:       "/test",
+			headers: map[string]string{
+				"Authorization":   "Bearer " + token,
+				"X-Forwarded-For": "10.0.0.5, 192.0.2.7",
+			},
+			remoteAddr:     "192.0.2.1:41234",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:       "client ip example - spoofed behind trusted proxy",
+			configYaml: clientIPCfg,
+			method:     "GET",
+			path:       "/test",
+			headers: map[string]string{
+				"Authorization":   "Bearer " + token,
+				"X-Forwarded-For": "10.0.0.5, 203.0.113.9",
+			},
+			remoteAddr:     "192.0.2.1:41234",
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:       "client ip example - forwarded by untrusted client",
+			configYaml: clientIPCfg,
+			method:     "GET",
+			path:       "/test",
+			headers: map[string]string{
+				"Authorization":   "Bearer " + token,
+				"X-Forwarded-For": "10.0.0.5",
+			},
+			remoteAddr:     "203.0.113.9:41234",
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "deny example - anonymous",
+			configYaml:     denyCfg,
+			method:         "GET",
+			path:           "/internal/metrics",
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "deny example - condition not met",
+			configYaml:     denyCfg,
+			method:         "GET",
+			path:           "/members/page",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "deny example - condition met",
+			configYaml: denyCfg,
+			method:     "GET",
+			path:       "/members/page",
+			headers: map[string]string{
+				"Authorization": "Bearer " + token,
+			},
+			wantStatusCode: http.StatusForbidden,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -702,12 +1074,18 @@ func TestIntegration(t *testing.T) {
 				return
 			}
 
-			routeMatcher := services.NewRouteMatcher(cfg.RoutePolicies)
-			authorizer := services.NewAuthorizer(cfg.ClaimPolicies)
+			routeMatcher := services.NewRouteMatcher(cfg.RoutePolicies, cfg.Server.Paths)
+			authorizer, err := services.NewAuthorizer(cfg.ClaimPolicies, cfg.Roles, cfg.Server.RedactClaims)
+			if err != nil {
+				t.Errorf("could not create authorizer: %v", err)
+				return
+			}
+
 			authenticator, err := services.NewAuthenticator(
+				context.Background(),
 				signingKey,
 				"HS256",
-				models.AuthenticationConfig{})
+				cfg.Authentication)
 
 			if err != nil {
 				t.Errorf("could not create authenticator: %v", err)
@@ -721,13 +1099,17 @@ func TestIntegration(t *testing.T) {
 				authenticator,
 				cfg.Server)
 
-			req, err := http.NewRequest(tt.method, tt.path, nil)
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
 			assert.Nil(t, err)
 
 			for k, v := range tt.headers {
 				req.Header.Add(k, v)
 			}
 
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(s.Handle)
 
@@ -740,3 +1122,272 @@ func TestIntegration(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_Handle_FormBodyForwarded(t *testing.T) {
+	body := "name=John&access_token=token"
+
+	authenticator := &mocks.Authenticator{}
+	authenticator.On("Authenticate", mock.Anything,
+		mock.MatchedBy(func(request *models.RequestContext) bool {
+			return request.Form.Get("access_token") == "token"
+		})).Return(map[string]any{}, "", nil)
+	authenticator.On("ReadsForm").Return(true)
+
+	var forwarded string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		forwarded = string(b)
+	})
+
+	authorizer, err := services.NewAuthorizer(models.ClaimPolicyConfig{}, models.RolesConfig{}, nil)
+	assert.Nil(t, err)
+
+	s := services.NewServer(
+		upstream,
+		services.NewRouteMatcher(models.RoutePolicyConfig{}, models.PathConfig{}),
+		authorizer,
+		authenticator,
+		models.ServerConfig{})
+
+	request := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	s.Handle(httptest.NewRecorder(), request)
+
+	assert.Equal(t, body, forwarded)
+	authenticator.AssertExpectations(t)
+}
+
+func TestServer_Handle_FormBodyNotParsed(t *testing.T) {
+	body := "name=John&access_token=token"
+
+	authenticator := &mocks.Authenticator{}
+	authenticator.On("Authenticate", mock.Anything,
+		mock.MatchedBy(func(request *models.RequestContext) bool {
+			return request.Form == nil
+		})).Return(map[string]any{}, "", nil)
+	authenticator.On("ReadsForm").Return(false)
+
+	var forwarded string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		assert.Nil(t, err)
+		forwarded = string(b)
+	})
+
+	authorizer, err := services.NewAuthorizer(models.ClaimPolicyConfig{}, models.RolesConfig{}, nil)
+	assert.Nil(t, err)
+
+	s := services.NewServer(
+		upstream,
+		services.NewRouteMatcher(models.RoutePolicyConfig{}, models.PathConfig{}),
+		authorizer,
+		authenticator,
+		models.ServerConfig{})
+
+	request := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	s.Handle(httptest.NewRecorder(), request)
+
+	assert.Equal(t, body, forwarded)
+	authenticator.AssertExpectations(t)
+}
+
+func TestServer_Handle_DecisionHeader(t *testing.T) {
+	claims := map[string]any{"role": "user"}
+
+	authenticator := &mocks.Authenticator{}
+	authenticator.On("Authenticate", mock.Anything, mock.Anything).Return(claims, "", nil)
+	authenticator.On("ReadsForm").Return(false)
+
+	authorizer, err := services.NewAuthorizer(models.ClaimPolicyConfig{
+		"Admin": {{Claim: "role", Values: []string{"admin"}}},
+	}, models.RolesConfig{}, nil)
+	assert.Nil(t, err)
+
+	s := services.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		services.NewRouteMatcher(models.RoutePolicyConfig{{Path: "/admin/**", PolicyName: "Admin"}}, models.PathConfig{}),
+		authorizer,
+		authenticator,
+		models.ServerConfig{DecisionHeader: "X-Bouncer-Decision"})
+
+	recorder := httptest.NewRecorder()
+	s.Handle(recorder, httptest.NewRequest(http.MethodGet, "/admin/users", nil))
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Equal(t, "deny route=/admin/** failed=[Admin: role]", recorder.Header().Get("X-Bouncer-Decision"))
+}
+
+func TestServer_Handle_CanonicalPath(t *testing.T) {
+	tests := []struct {
+		name          string
+		paths         models.PathConfig
+		subRequest    bool
+		target        string
+		wantStatus    int
+		wantForwarded string
+	}{
+		{
+			name:          "dot segments do not bypass a protected route",
+			target:        "/public/../admin//users",
+			wantStatus:    http.StatusForbidden,
+			wantForwarded: "",
+		},
+		{
+			name:          "canonical path forwarded to an allowed route",
+			target:        "/admin/../public//docs/",
+			wantStatus:    http.StatusOK,
+			wantForwarded: "/public/docs/",
+		},
+		{
+			name:          "matrix parameters stripped before forwarding",
+			paths:         models.PathConfig{StripMatrixParams: true},
+			target:        "/public;jsessionid=x/docs",
+			wantStatus:    http.StatusOK,
+			wantForwarded: "/public/docs",
+		},
+		{
+			name:          "canonical path not rewritten",
+			target:        "/public/a%20b",
+			wantStatus:    http.StatusOK,
+			wantForwarded: "/public/a%20b",
+		},
+		{
+			name:       "non-canonical path rejected",
+			paths:      models.PathConfig{RejectNonCanonical: true},
+			target:     "/admin/../public/docs",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "encoded slash rejected",
+			target:     "/public%2F..%2Fadmin",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:          "decoded encoded slash forwarded",
+			paths:         models.PathConfig{EncodedSlashes: "decode"},
+			target:        "/public/a%2Fb",
+			wantStatus:    http.StatusOK,
+			wantForwarded: "/public/a/b",
+		},
+		{
+			name:       "decoded encoded slash is not canonical",
+			paths:      models.PathConfig{EncodedSlashes: "decode", RejectNonCanonical: true},
+			target:     "/public/a%2Fb",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:          "percent-encoded character is canonical",
+			paths:         models.PathConfig{RejectNonCanonical: true},
+			target:        "/public/a%20b",
+			wantStatus:    http.StatusOK,
+			wantForwarded: "/public/a%20b",
+		},
+		{
+			name:          "sub-delims forwarded as sent",
+			paths:         models.PathConfig{RejectNonCanonical: true},
+			target:        "/public/a(1)!'*.txt",
+			wantStatus:    http.StatusOK,
+			wantForwarded: "/public/a(1)!'*.txt",
+		},
+		{
+			name:          "lowercase percent-encoding forwarded as sent",
+			paths:         models.PathConfig{RejectNonCanonical: true},
+			target:        "/public/caf%c3%a9",
+			wantStatus:    http.StatusOK,
+			wantForwarded: "/public/caf%c3%a9",
+		},
+		{
+			name:       "sub-request with sub-delims allowed",
+			subRequest: true,
+			target:     "/public/a(1)!'*.txt",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "sub-request with lowercase percent-encoding allowed",
+			subRequest: true,
+			target:     "/public/caf%c3%a9",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "sub-request with stripped matrix parameters rejected",
+			paths:      models.PathConfig{StripMatrixParams: true},
+			subRequest: true,
+			target:     "/public;jsessionid=x/docs",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "canonical sub-request allowed",
+			subRequest: true,
+			target:     "/public/a%20b",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "non-canonical sub-request rejected by default",
+			subRequest: true,
+			target:     "/admin/../public/docs",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "sub-request with decoded encoded slash rejected",
+			paths:      models.PathConfig{EncodedSlashes: "decode"},
+			subRequest: true,
+			target:     "/public/a%2Fb",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := &mocks.Authenticator{}
+			authenticator.On("Authenticate", mock.Anything, mock.Anything).Return(map[string]any{}, "", nil)
+			authenticator.On("ReadsForm").Return(false)
+
+			forwarded := ""
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.URL.EscapedPath()
+				w.WriteHeader(http.StatusOK)
+			})
+
+			routePolicies := models.RoutePolicyConfig{
+				{Path: "/admin/**", PolicyName: "Admin"},
+				{Path: "/public/**", AllowAnonymous: true},
+			}
+
+			config := models.ServerConfig{Paths: tt.paths}
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+
+			if tt.subRequest {
+				upstream = nil
+				config.OriginalRequestHeaders = &models.OriginalRequestHeaders{
+					Method: "X-Original-Method",
+					Path:   "X-Original-URI",
+				}
+
+				request = httptest.NewRequest(http.MethodGet, "/auth", nil)
+				request.Header.Set("X-Original-Method", http.MethodGet)
+				request.Header.Set("X-Original-URI", tt.target)
+			}
+
+			authorizer, err := services.NewAuthorizer(models.ClaimPolicyConfig{
+				"Admin": {{Claim: "role", Values: []string{"admin"}}},
+			}, models.RolesConfig{}, nil)
+			assert.Nil(t, err)
+
+			s := services.NewServer(
+				upstream,
+				services.NewRouteMatcher(routePolicies, tt.paths),
+				authorizer,
+				authenticator,
+				config)
+
+			recorder := httptest.NewRecorder()
+			s.Handle(recorder, request)
+
+			assert.Equal(t, tt.wantStatus, recorder.Code)
+			assert.Equal(t, tt.wantForwarded, forwarded)
+		})
+	}
+}
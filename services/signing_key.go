@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// parseSigningAlgorithm parses a JWS algorithm name, rejecting unknown names and the "none" algorithm
+func parseSigningAlgorithm(name string) (jwa.SignatureAlgorithm, error) {
+	alg, ok := jwa.KeyAlgorithmFrom(name).(jwa.SignatureAlgorithm)
+	if !ok || alg == jwa.NoSignature {
+		return "", fmt.Errorf("unknown signing algorithm: %s", name)
+	}
+
+	return alg, nil
+}
+
+func containsAlgorithm(algs []jwa.SignatureAlgorithm, alg jwa.SignatureAlgorithm) bool {
+	for _, a := range algs {
+		if a == alg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseSigningKey detects the encoding of the given key material and returns the public keys in it.
+//
+// - PEM blocks (public keys, private keys and X.509 certificates) are parsed as asymmetric keys.
+//
+// - JSON documents are parsed as a JWK or a JWK Set.
+//
+// - Anything else is treated as a raw symmetric (HMAC) secret.
+func parseSigningKey(signingKey []byte) (jwk.Set, error) {
+	trimmed := bytes.TrimSpace(signingKey)
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("signing key is empty")
+	}
+
+	var keys jwk.Set
+	var err error
+
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("-----BEGIN")):
+		keys, err = jwk.Parse(trimmed, jwk.WithPEM(true))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse PEM encoded key: %w", err)
+		}
+	case trimmed[0] == '{':
+		keys, err = jwk.Parse(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse JWK: %w", err)
+		}
+	default:
+		key, err := jwk.FromRaw(signingKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse key: %w", err)
+		}
+
+		keys = jwk.NewSet()
+		_ = keys.AddKey(key)
+	}
+
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("no keys found in signing key")
+	}
+
+	// private keys are accepted for convenience, but only their public halves are kept
+	return jwk.PublicSetOf(keys)
+}
+
+// checkKeyAlgorithm checks if the given key can be used to verify signatures of the given algorithm
+func checkKeyAlgorithm(key jwk.Key, alg jwa.SignatureAlgorithm) error {
+	if usage := key.KeyUsage(); usage != "" && usage != jwk.ForSignature.String() {
+		return fmt.Errorf("key usage %q is not signature verification", usage)
+	}
+
+	if keyAlg := key.Algorithm().String(); keyAlg != "" && keyAlg != alg.String() {
+		return fmt.Errorf("key algorithm %s does not match signing algorithm %s", keyAlg, alg)
+	}
+
+	var wantType jwa.KeyType
+	var wantCurves []jwa.EllipticCurveAlgorithm
+
+	switch alg {
+	case jwa.HS256, jwa.HS384, jwa.HS512:
+		wantType = jwa.OctetSeq
+	case jwa.RS256, jwa.RS384, jwa.RS512, jwa.PS256, jwa.PS384, jwa.PS512:
+		wantType = jwa.RSA
+	case jwa.ES256:
+		wantType, wantCurves = jwa.EC, []jwa.EllipticCurveAlgorithm{jwa.P256}
+	case jwa.ES384:
+		wantType, wantCurves = jwa.EC, []jwa.EllipticCurveAlgorithm{jwa.P384}
+	case jwa.ES512:
+		wantType, wantCurves = jwa.EC, []jwa.EllipticCurveAlgorithm{jwa.P521}
+	case jwa.ES256K:
+		wantType, wantCurves = jwa.EC, []jwa.EllipticCurveAlgorithm{"secp256k1"}
+	case jwa.EdDSA:
+		wantType, wantCurves = jwa.OKP, []jwa.EllipticCurveAlgorithm{jwa.Ed25519, jwa.Ed448}
+	default:
+		return fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	if key.KeyType() != wantType {
+		return fmt.Errorf("key type %s is not compatible with signing algorithm %s", key.KeyType(), alg)
+	}
+
+	if wantCurves == nil {
+		return nil
+	}
+
+	curveKey, ok := key.(interface {
+		Crv() jwa.EllipticCurveAlgorithm
+	})
+	if !ok {
+		return fmt.Errorf("key curve is missing")
+	}
+
+	crv := curveKey.Crv()
+	for _, c := range wantCurves {
+		if crv == c {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("key curve %s is not compatible with signing algorithm %s", crv, alg)
+}
+
+// keySetProvider feeds the keys of a static key set to the JWS verifier
+type keySetProvider struct {
+	keys jwk.Set
+	algs []jwa.SignatureAlgorithm
+}
+
+// newKeySetProvider parses the given signing key and checks if all the keys in it fit at least one of the signing
+// algorithms
+func newKeySetProvider(signingKey []byte, algs []jwa.SignatureAlgorithm) (*keySetProvider, error) {
+	keys, err := parseSigningKey(signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < keys.Len(); i++ {
+		key, _ := keys.Key(i)
+
+		for _, alg := range algs {
+			err = checkKeyAlgorithm(key, alg)
+			if err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("signing key #%d cannot be used with %v: %w", i+1, algs, err)
+		}
+	}
+
+	return &keySetProvider{keys: keys, algs: algs}, nil
+}
+
+// FetchKeys implements jws.KeyProvider.
+// If the token names a key ID, only that key is provided, otherwise all keys in the set that fit the algorithm are
+// tried.
+func (p *keySetProvider) FetchKeys(_ context.Context, sink jws.KeySink, sig *jws.Signature, _ *jws.Message) error {
+	alg := sig.ProtectedHeaders().Algorithm()
+	if !containsAlgorithm(p.algs, alg) {
+		return fmt.Errorf("unexpected signing algorithm: %s", alg)
+	}
+
+	if kid := sig.ProtectedHeaders().KeyID(); kid != "" && p.keys.Len() > 1 {
+		key, found := p.keys.LookupKeyID(kid)
+		if !found {
+			return fmt.Errorf("no key found with key ID: %s", kid)
+		}
+
+		err := checkKeyAlgorithm(key, alg)
+		if err != nil {
+			return fmt.Errorf("key with key ID %s cannot be used with %s: %w", kid, alg, err)
+		}
+
+		sink.Key(alg, key)
+		return nil
+	}
+
+	for i := 0; i < p.keys.Len(); i++ {
+		key, _ := p.keys.Key(i)
+		if checkKeyAlgorithm(key, alg) == nil {
+			sink.Key(alg, key)
+		}
+	}
+
+	return nil
+}
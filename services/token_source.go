@@ -0,0 +1,104 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// defaultTokenSources is the Bearer authorization header
+var defaultTokenSources = []models.TokenSource{
+	{Header: "Authorization", Scheme: "Bearer"},
+}
+
+// tokenExtractor looks for the token in the request through a list of token sources, the first one found is used
+type tokenExtractor struct {
+	sources []models.TokenSource
+}
+
+func newTokenExtractor(sources []models.TokenSource) tokenExtractor {
+	if len(sources) == 0 {
+		sources = defaultTokenSources
+	}
+
+	return tokenExtractor{sources: sources}
+}
+
+// extract returns the first token found in the request.
+// If none of the sources has a token, the reason the first source with a value was skipped is returned.
+func (e tokenExtractor) extract(request *models.RequestContext) (string, error) {
+	var skipped error
+
+	for _, source := range e.sources {
+		token, err := lookupToken(source, request)
+		if err != nil {
+			if skipped == nil {
+				skipped = err
+			}
+			continue
+		}
+
+		if token != "" {
+			return token, nil
+		}
+	}
+
+	if skipped != nil {
+		return "", skipped
+	}
+
+	return "", fmt.Errorf("no token found in the request")
+}
+
+// readsForm reports if one of the sources is a form field
+func (e tokenExtractor) readsForm() bool {
+	for _, source := range e.sources {
+		if source.Form != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lookupToken(source models.TokenSource, request *models.RequestContext) (string, error) {
+	switch {
+	case source.Header != "":
+		return headerToken(request.Header.Get(source.Header), source.Scheme)
+	case source.Cookie != "":
+		cookie, err := (&http.Request{Header: request.Header}).Cookie(source.Cookie)
+		if err != nil {
+			return "", nil
+		}
+
+		return strings.TrimSpace(cookie.Value), nil
+	case source.Query != "":
+		return strings.TrimSpace(request.Query.Get(source.Query)), nil
+	case source.Form != "":
+		return strings.TrimSpace(request.Form.Get(source.Form)), nil
+	}
+
+	return "", nil
+}
+
+// headerToken strips the scheme off a header value. Scheme matching is case-insensitive and
+// any amount of whitespace is accepted around the scheme and the token.
+func headerToken(value string, scheme string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || scheme == "" {
+		return value, nil
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("invalid authentication header format")
+	}
+
+	if !strings.EqualFold(fields[0], scheme) {
+		return "", fmt.Errorf("authentication scheme expected to be %q, actual: %s", scheme, fields[0])
+	}
+
+	return fields[1], nil
+}
@@ -0,0 +1,123 @@
+package services_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kaancfidan/bouncer/models"
+	"github.com/kaancfidan/bouncer/services"
+)
+
+func TestAuthenticatorImpl_Authenticate_TokenSources(t *testing.T) {
+	key := []byte("ThisIsSupposedToBeALongStringOfBytesLikeSixtyFourCharactersLong.")
+	token := signTestToken(t, jwa.HS256, key, "")
+
+	sources := []models.TokenSource{
+		{Header: "Authorization", Scheme: "Bearer"},
+		{Header: "X-Api-Token"},
+		{Cookie: "session"},
+		{Query: "access_token"},
+		{Form: "access_token"},
+	}
+
+	tests := []struct {
+		name    string
+		sources []models.TokenSource
+		request *models.RequestContext
+		wantErr bool
+	}{
+		{
+			name:    "default source",
+			request: &models.RequestContext{Header: http.Header{"Authorization": {"Bearer " + token}}},
+			wantErr: false,
+		},
+		{
+			name:    "default source ignores cookies",
+			request: &models.RequestContext{Header: http.Header{"Cookie": {"session=" + token}}},
+			wantErr: true,
+		},
+		{
+			name:    "extra whitespace and scheme casing",
+			sources: sources,
+			request: &models.RequestContext{Header: http.Header{"Authorization": {"  BEARER \t " + token + " "}}},
+			wantErr: false,
+		},
+		{
+			name:    "header without scheme",
+			sources: sources,
+			request: &models.RequestContext{Header: http.Header{"X-Api-Token": {token}}},
+			wantErr: false,
+		},
+		{
+			name:    "cookie",
+			sources: sources,
+			request: &models.RequestContext{Header: http.Header{"Cookie": {"theme=dark; session=" + token}}},
+			wantErr: false,
+		},
+		{
+			name:    "query parameter",
+			sources: sources,
+			request: &models.RequestContext{
+				Header: http.Header{},
+				Query:  url.Values{"access_token": {token}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "form field",
+			sources: sources,
+			request: &models.RequestContext{
+				Header: http.Header{},
+				Form:   url.Values{"access_token": {token}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "other scheme falls through to next source",
+			sources: sources,
+			request: &models.RequestContext{
+				Header: http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}},
+				Query:  url.Values{"access_token": {token}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "first source found wins",
+			sources: sources,
+			request: &models.RequestContext{
+				Header: http.Header{"Authorization": {"Bearer invalid"}},
+				Query:  url.Values{"access_token": {token}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "other scheme only",
+			sources: sources,
+			request: &models.RequestContext{Header: http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}},
+			wantErr: true,
+		},
+		{
+			name:    "no token",
+			sources: sources,
+			request: &models.RequestContext{Header: http.Header{}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := services.NewAuthenticator(context.Background(), key, "HS256",
+				models.AuthenticationConfig{TokenSources: tt.sources})
+			assert.Nil(t, err)
+
+			_, _, err = a.Authenticate(context.Background(), tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/kaancfidan/bouncer/models"
+)
+
+// TupleStore is the relationship tuple storage interface
+type TupleStore interface {
+	// Subjects returns the subjects related to the object by the relation
+	Subjects(object string, relation string) []string
+	// List returns the tuples of the object, or all tuples if object is empty
+	List(object string) []models.Tuple
+	Write(tuples []models.Tuple) error
+	Delete(tuples []models.Tuple) error
+}
+
+// FileTupleStore keeps tuples in memory, and persists them to a JSON file on every change
+type FileTupleStore struct {
+	path string
+
+	mu sync.RWMutex
+	// subjects are the related subjects by object, then relation
+	subjects map[string]map[string]map[string]bool
+}
+
+// NewFileTupleStore loads the tuples of the file, a missing file is created on the first write
+func NewFileTupleStore(path string) (*FileTupleStore, error) {
+	s := &FileTupleStore{
+		path:     path,
+		subjects: make(map[string]map[string]map[string]bool),
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tuple store: %w", err)
+	}
+
+	var tuples []models.Tuple
+	err = json.Unmarshal(content, &tuples)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tuple store: %w", err)
+	}
+
+	for _, t := range tuples {
+		s.add(t)
+	}
+
+	return s, nil
+}
+
+// Subjects returns the subjects related to the object by the relation
+func (s *FileTupleStore) Subjects(object string, relation string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subjects := make([]string, 0, len(s.subjects[object][relation]))
+	for subject := range s.subjects[object][relation] {
+		subjects = append(subjects, subject)
+	}
+
+	sort.Strings(subjects)
+
+	return subjects
+}
+
+// List returns the tuples of the object, or all tuples if object is empty, sorted
+func (s *FileTupleStore) List(object string) []models.Tuple {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.list(object)
+}
+
+// Write adds the tuples and persists the store, existing tuples are ignored.
+// If the store cannot be persisted, the tuples are not added.
+func (s *FileTupleStore) Write(tuples []models.Tuple) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var added []models.Tuple
+	for _, t := range tuples {
+		if s.add(t) {
+			added = append(added, t)
+		}
+	}
+
+	err := s.persist()
+	if err != nil {
+		for _, t := range added {
+			s.remove(t)
+		}
+	}
+
+	return err
+}
+
+// Delete removes the tuples and persists the store, missing tuples are ignored.
+// If the store cannot be persisted, the tuples are not removed.
+func (s *FileTupleStore) Delete(tuples []models.Tuple) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed []models.Tuple
+	for _, t := range tuples {
+		if s.remove(t) {
+			removed = append(removed, t)
+		}
+	}
+
+	err := s.persist()
+	if err != nil {
+		for _, t := range removed {
+			s.add(t)
+		}
+	}
+
+	return err
+}
+
+// add adds a tuple, and returns false if it already exists
+func (s *FileTupleStore) add(t models.Tuple) bool {
+	relations, exists := s.subjects[t.Object]
+	if !exists {
+		relations = make(map[string]map[string]bool)
+		s.subjects[t.Object] = relations
+	}
+
+	subjects, exists := relations[t.Relation]
+	if !exists {
+		subjects = make(map[string]bool)
+		relations[t.Relation] = subjects
+	}
+
+	if subjects[t.Subject] {
+		return false
+	}
+
+	subjects[t.Subject] = true
+
+	return true
+}
+
+// remove removes a tuple, and returns false if it does not exist
+func (s *FileTupleStore) remove(t models.Tuple) bool {
+	if !s.subjects[t.Object][t.Relation][t.Subject] {
+		return false
+	}
+
+	delete(s.subjects[t.Object][t.Relation], t.Subject)
+
+	if len(s.subjects[t.Object][t.Relation]) == 0 {
+		delete(s.subjects[t.Object], t.Relation)
+	}
+
+	if len(s.subjects[t.Object]) == 0 {
+		delete(s.subjects, t.Object)
+	}
+
+	return true
+}
+
+// list returns the tuples of the object, or all tuples if object is empty, sorted
+func (s *FileTupleStore) list(object string) []models.Tuple {
+	tuples := make([]models.Tuple, 0)
+
+	for o, relations := range s.subjects {
+		if object != "" && o != object {
+			continue
+		}
+
+		for relation, subjects := range relations {
+			for subject := range subjects {
+				tuples = append(tuples, models.Tuple{Object: o, Relation: relation, Subject: subject})
+			}
+		}
+	}
+
+	sort.Slice(tuples, func(i, j int) bool {
+		if tuples[i].Object != tuples[j].Object {
+			return tuples[i].Object < tuples[j].Object
+		}
+
+		if tuples[i].Relation != tuples[j].Relation {
+			return tuples[i].Relation < tuples[j].Relation
+		}
+
+		return tuples[i].Subject < tuples[j].Subject
+	})
+
+	return tuples
+}
+
+// persist replaces the file with the current tuples, through a temporary file so that it is never left half written
+func (s *FileTupleStore) persist() error {
+	content, err := json.MarshalIndent(s.list(""), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize tuples: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to persist tuples: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to persist tuples: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		return fmt.Errorf("failed to persist tuples: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package services_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kaancfidan/bouncer/models"
+	"github.com/kaancfidan/bouncer/services"
+)
+
+func TestFileTupleStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tuples.json")
+
+	store, err := services.NewFileTupleStore(path)
+	if err != nil {
+		t.Fatalf("NewFileTupleStore() error = %v", err)
+	}
+
+	assert.Empty(t, store.List(""))
+
+	err = store.Write([]models.Tuple{
+		{Object: "document:readme", Relation: "editor", Subject: "user:bob"},
+		{Object: "document:readme", Relation: "editor", Subject: "user:alice"},
+		{Object: "document:readme", Relation: "viewer", Subject: "group:eng#member"},
+		{Object: "group:eng", Relation: "member", Subject: "user:carol"},
+		{Object: "group:eng", Relation: "member", Subject: "user:carol"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"user:alice", "user:bob"}, store.Subjects("document:readme", "editor"))
+	assert.Empty(t, store.Subjects("document:readme", "owner"))
+	assert.Len(t, store.List(""), 4)
+	assert.Equal(t, []models.Tuple{{Object: "group:eng", Relation: "member", Subject: "user:carol"}},
+		store.List("group:eng"))
+
+	err = store.Delete([]models.Tuple{
+		{Object: "document:readme", Relation: "editor", Subject: "user:bob"},
+		{Object: "document:readme", Relation: "editor", Subject: "user:dave"},
+		{Object: "group:eng", Relation: "member", Subject: "user:carol"},
+	})
+	assert.NoError(t, err)
+
+	reloaded, err := services.NewFileTupleStore(path)
+	if err != nil {
+		t.Fatalf("NewFileTupleStore() error = %v", err)
+	}
+
+	assert.Equal(t, []models.Tuple{
+		{Object: "document:readme", Relation: "editor", Subject: "user:alice"},
+		{Object: "document:readme", Relation: "viewer", Subject: "group:eng#member"},
+	}, reloaded.List(""))
+}
+
+func TestFileTupleStore_PersistFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tuples.json")
+
+	store, err := services.NewFileTupleStore(path)
+	if err != nil {
+		t.Fatalf("NewFileTupleStore() error = %v", err)
+	}
+
+	tuple := models.Tuple{Object: "document:readme", Relation: "editor", Subject: "user:alice"}
+	assert.NoError(t, store.Write([]models.Tuple{tuple}))
+
+	// the store cannot be replaced once its directory is gone
+	assert.NoError(t, os.RemoveAll(dir))
+
+	assert.Error(t, store.Write([]models.Tuple{{Object: "document:readme", Relation: "editor", Subject: "user:bob"}}))
+	assert.Equal(t, []string{"user:alice"}, store.Subjects("document:readme", "editor"))
+
+	assert.Error(t, store.Delete([]models.Tuple{tuple}))
+	assert.Equal(t, []string{"user:alice"}, store.Subjects("document:readme", "editor"))
+}
+
+func TestNewFileTupleStore_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tuples.json")
+
+	err := os.WriteFile(path, []byte("{invalid"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = services.NewFileTupleStore(path)
+	assert.Error(t, err)
+}